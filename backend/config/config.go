@@ -1,62 +1,319 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"log"
+	"net/url"
 	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
-	"github.com/jmoiron/sqlx"
-	"github.com/zucong/rp/models"
+	"github.com/zucong/rp/llm"
 	"gopkg.in/yaml.v3"
 )
 
+// Settings are layered, each layer overriding the one before:
+//
+//  1. built-in defaults
+//  2. config.yaml
+//  3. RP_* environment variables, named after the YAML key
+//     (llm.default_model is RP_LLM_DEFAULT_MODEL)
+//  4. for the llm section only, overrides saved from the settings page in
+//     the database (see Store)
+//
+// config.yaml is watched and reloaded when it changes. LLM settings take
+// effect on the next call; the rest are read at startup and need a restart.
+
+// Sources of a setting's value, in precedence order
+const (
+	SourceDefault = "default"
+	SourceYAML    = "yaml"
+	SourceEnv     = "env"
+	SourceDB      = "db"
+)
+
+// EnvPrefix starts the name of every environment variable setting
+const EnvPrefix = "RP_"
+
 type AppConfig struct {
 	Database struct {
 		Path string `yaml:"path"`
 	} `yaml:"database"`
-	LLM struct {
-		APIEndpoint  string `yaml:"api_endpoint"`
-		APIKey       string `yaml:"api_key"`
-		DefaultModel string `yaml:"default_model"`
-	} `yaml:"llm"`
+	LLM    LLMConfig `yaml:"llm"`
 	Server struct {
 		Port int    `yaml:"port"`
 		Host string `yaml:"host"`
+		// AllowedOrigins are the other sites allowed to call the API, such
+		// as a separately hosted frontend; empty allows same-origin only
+		AllowedOrigins []string `yaml:"allowed_origins"`
 	} `yaml:"server"`
+	Auth struct {
+		// AdminUsername and AdminPassword create the first account when the
+		// database has none; they are ignored once any account exists
+		AdminUsername string `yaml:"admin_username"`
+		AdminPassword string `yaml:"admin_password"`
+		// SessionHours is how long a login lasts; defaults to a week
+		SessionHours int `yaml:"session_hours"`
+		// SecureCookie marks the session cookie HTTPS-only
+		SecureCookie bool `yaml:"secure_cookie"`
+	} `yaml:"auth"`
+	Security struct {
+		// KeyFile holds the key the stored API key is encrypted with;
+		// defaults to secret.key next to the database
+		KeyFile string `yaml:"key_file"`
+	} `yaml:"security"`
+}
+
+// LLMConfig is the file and environment layer of the LLM settings; the
+// database can override each field
+type LLMConfig struct {
+	APIEndpoint      string `yaml:"api_endpoint"`
+	APIKey           string `yaml:"api_key"`
+	DefaultModel     string `yaml:"default_model"`
+	EmbeddingModel   string `yaml:"embedding_model"`
+	Provider         string `yaml:"provider"`
+	APIMode          string `yaml:"api_mode"`
+	InstructTemplate string `yaml:"instruct_template"`
 }
 
+// Defaults returns the built-in settings
+func Defaults() AppConfig {
+	var cfg AppConfig
+	cfg.Database.Path = "./data/app.db"
+	cfg.LLM = LLMConfig{
+		APIEndpoint:      "https://api.openai.com/v1",
+		DefaultModel:     "gpt-3.5-turbo",
+		EmbeddingModel:   "text-embedding-3-small",
+		Provider:         llm.ProviderAuto,
+		APIMode:          llm.ModeChat,
+		InstructTemplate: "chatml",
+	}
+	cfg.Server.Port = 8080
+	cfg.Server.Host = "0.0.0.0"
+	cfg.Auth.SessionHours = 24 * 7
+	return cfg
+}
+
+// GlobalConfig holds the settings as loaded at startup. Settings that can
+// change while running are read through Current instead.
 var GlobalConfig AppConfig
 
+var (
+	mu      sync.RWMutex
+	current AppConfig
+	sources map[string]string
+)
+
+// Current returns the latest settings, including changes to config.yaml
+// made since startup
+func Current() AppConfig {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current
+}
+
 func LoadConfig(configPath string) error {
+	cfg, src, err := load(configPath)
+	if err != nil {
+		return err
+	}
+	GlobalConfig = cfg
+	mu.Lock()
+	current, sources = cfg, src
+	mu.Unlock()
+	return nil
+}
+
+// load builds the settings from the defaults, the YAML file and the
+// environment, and records where each value came from
+func load(configPath string) (AppConfig, map[string]string, error) {
+	cfg := Defaults()
+	src := map[string]string{}
+
 	data, err := os.ReadFile(configPath)
 	if err != nil {
-		return fmt.Errorf("failed to read config file: %w", err)
+		return cfg, nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return cfg, nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+	var present map[string]map[string]interface{}
+	_ = yaml.Unmarshal(data, &present)
+
+	var errs []error
+	eachSetting(&cfg, func(key, env string, v reflect.Value) {
+		src[key] = SourceDefault
+		section, name, _ := strings.Cut(key, ".")
+		if _, ok := present[section][name]; ok {
+			src[key] = SourceYAML
+		}
+		if raw, ok := os.LookupEnv(env); ok {
+			if err := setFromEnv(v, raw); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", env, err))
+				return
+			}
+			src[key] = SourceEnv
+		}
+	})
+	if err := errors.Join(errs...); err != nil {
+		return cfg, nil, err
+	}
+
+	if err := Validate(&cfg); err != nil {
+		return cfg, nil, fmt.Errorf("invalid config: %w", err)
 	}
+	return cfg, src, nil
+}
 
-	if err := yaml.Unmarshal(data, &GlobalConfig); err != nil {
-		return fmt.Errorf("failed to parse config file: %w", err)
+// eachSetting calls fn with every leaf setting, its YAML key and its
+// environment variable
+func eachSetting(cfg *AppConfig, fn func(key, env string, v reflect.Value)) {
+	root := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < root.NumField(); i++ {
+		section := yamlName(root.Type().Field(i))
+		group := root.Field(i)
+		for j := 0; j < group.NumField(); j++ {
+			name := yamlName(group.Type().Field(j))
+			fn(section+"."+name, EnvPrefix+strings.ToUpper(section+"_"+name), group.Field(j))
+		}
 	}
+}
+
+func yamlName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+	return name
+}
 
+// setFromEnv parses an environment value into a setting; lists are
+// comma-separated
+func setFromEnv(v reflect.Value, raw string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Int:
+		n, err := strconv.Atoi(strings.TrimSpace(raw))
+		if err != nil {
+			return fmt.Errorf("%q is not a number", raw)
+		}
+		v.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(raw))
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", raw)
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.Kind())
+	}
 	return nil
 }
 
-type Store struct {
-	db *sqlx.DB
+// Validate checks the settings, reporting every problem at once
+func Validate(cfg *AppConfig) error {
+	var errs []error
+	if cfg.Database.Path == "" {
+		errs = append(errs, errors.New("database.path is required"))
+	}
+	if cfg.Server.Port < 1 || cfg.Server.Port > 65535 {
+		errs = append(errs, fmt.Errorf("server.port %d is out of range", cfg.Server.Port))
+	}
+	if cfg.Auth.SessionHours <= 0 {
+		errs = append(errs, errors.New("auth.session_hours must be positive"))
+	}
+	if err := ValidateLLM(cfg.LLM); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// ValidateLLM checks the LLM settings, whichever layers they came from
+func ValidateLLM(l LLMConfig) error {
+	var errs []error
+	if u, err := url.Parse(l.APIEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("api_endpoint %q must be an http or https URL", l.APIEndpoint))
+	}
+	if l.DefaultModel == "" {
+		errs = append(errs, errors.New("default_model is required"))
+	}
+	if !slices.Contains(llm.Providers, l.Provider) {
+		errs = append(errs, errors.New("provider must be one of "+strings.Join(llm.Providers, ", ")))
+	}
+	if !slices.Contains(llm.Modes, l.APIMode) {
+		errs = append(errs, errors.New("api_mode must be one of "+strings.Join(llm.Modes, ", ")))
+	}
+	if !slices.Contains(llm.TemplateNames(), l.InstructTemplate) {
+		errs = append(errs, errors.New("instruct_template must be one of "+strings.Join(llm.TemplateNames(), ", ")))
+	}
+	return errors.Join(errs...)
 }
 
-func NewStore(db *sqlx.DB) *Store {
-	return &Store{db: db}
+// Watch polls config.yaml and reloads it when it changes. A file that fails
+// to load or validate is reported and the previous settings are kept.
+func Watch(configPath string, interval time.Duration) {
+	modTime := func() time.Time {
+		info, err := os.Stat(configPath)
+		if err != nil {
+			return time.Time{}
+		}
+		return info.ModTime()
+	}
+	last := modTime()
+	for range time.Tick(interval) {
+		if mt := modTime(); !mt.Equal(last) {
+			last = mt
+			reload(configPath)
+		}
+	}
 }
 
-func (s *Store) Get() (*models.Config, error) {
-	var cfg models.Config
-	err := s.db.Get(&cfg, "SELECT api_endpoint, api_key, default_model FROM config WHERE id = 1")
-	return &cfg, err
+func reload(configPath string) {
+	cfg, src, err := load(configPath)
+	if err != nil {
+		log.Printf("[Config] Not reloading %s: %v", configPath, err)
+		return
+	}
+
+	mu.Lock()
+	old := current
+	current, sources = cfg, src
+	mu.Unlock()
+
+	changed := changedSettings(&old, &cfg)
+	if len(changed) == 0 {
+		return
+	}
+	log.Printf("[Config] Reloaded %s, changed: %s", configPath, strings.Join(changed, ", "))
+	for _, key := range changed {
+		if !strings.HasPrefix(key, "llm.") {
+			log.Printf("[Config] %s takes effect after a restart", key)
+		}
+	}
 }
 
-func (s *Store) Update(cfg *models.Config) error {
-	_, err := s.db.Exec(
-		"UPDATE config SET api_endpoint = ?, api_key = ?, default_model = ? WHERE id = 1",
-		cfg.APIEndpoint, cfg.APIKey, cfg.DefaultModel,
-	)
-	return err
+// changedSettings lists the keys whose values differ between two configs
+func changedSettings(a, b *AppConfig) []string {
+	values := map[string]interface{}{}
+	eachSetting(a, func(key, _ string, v reflect.Value) {
+		values[key] = v.Interface()
+	})
+	var changed []string
+	eachSetting(b, func(key, _ string, v reflect.Value) {
+		if !reflect.DeepEqual(values[key], v.Interface()) {
+			changed = append(changed, key)
+		}
+	})
+	return changed
 }
@@ -0,0 +1,149 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The API key is stored encrypted with AES-256-GCM. The encryption key is
+// derived from RP_SECRET_KEY when it is set, or else from the contents of a
+// key file: RP_SECRET_KEY_FILE, security.key_file in the config, or
+// secret.key next to the database. A missing key file is generated on first
+// start. Losing the key means re-entering the API key in the settings.
+
+// SecretKeyEnv and SecretKeyFileEnv name the environment variables holding
+// the key material or the path to a key file
+const (
+	SecretKeyEnv     = "RP_SECRET_KEY"
+	SecretKeyFileEnv = "RP_SECRET_KEY_FILE"
+)
+
+// encryptedPrefix marks an encrypted value; values without it are plain
+// text left from before encryption and are encrypted on startup
+const encryptedPrefix = "enc:v1:"
+
+var secretCipher cipher.AEAD
+
+// InitSecrets loads or creates the encryption key. It must run before the
+// config store is used.
+func InitSecrets() error {
+	material, source, err := loadKeyMaterial()
+	if err != nil {
+		return err
+	}
+	// Derive the key so any length of material gives an AES-256 key
+	key := sha256.Sum256(append([]byte("rp api key encryption\x00"), material...))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return err
+	}
+	secretCipher, err = cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	log.Printf("[Config] Using encryption key from %s", source)
+	return nil
+}
+
+func loadKeyMaterial() ([]byte, string, error) {
+	if key := os.Getenv(SecretKeyEnv); key != "" {
+		return []byte(key), SecretKeyEnv, nil
+	}
+
+	path := os.Getenv(SecretKeyFileEnv)
+	if path == "" {
+		path = GlobalConfig.Security.KeyFile
+	}
+	if path == "" {
+		path = filepath.Join(filepath.Dir(GlobalConfig.Database.Path), "secret.key")
+	}
+
+	data, err := os.ReadFile(path)
+	if err == nil {
+		material := []byte(strings.TrimSpace(string(data)))
+		if len(material) == 0 {
+			return nil, "", fmt.Errorf("key file %s is empty", path)
+		}
+		return material, path, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, "", fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, "", err
+	}
+	material := []byte(base64.StdEncoding.EncodeToString(raw))
+	if dir := filepath.Dir(path); dir != "" && dir != "." {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, "", fmt.Errorf("failed to create key file directory: %w", err)
+		}
+	}
+	if err := os.WriteFile(path, append(material, '\n'), 0600); err != nil {
+		return nil, "", fmt.Errorf("failed to write key file: %w", err)
+	}
+	log.Printf("[Config] Generated a new encryption key in %s; back it up with the database", path)
+	return material, path, nil
+}
+
+// EncryptSecret encrypts a value for storage; empty values stay empty
+func EncryptSecret(plain string) (string, error) {
+	if plain == "" {
+		return "", nil
+	}
+	if secretCipher == nil {
+		return "", errors.New("secret encryption is not initialized")
+	}
+	nonce := make([]byte, secretCipher.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := secretCipher.Seal(nonce, nonce, []byte(plain), nil)
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptSecret reverses EncryptSecret. Plain-text values are returned as
+// they are.
+func DecryptSecret(stored string) (string, error) {
+	if !strings.HasPrefix(stored, encryptedPrefix) {
+		return stored, nil
+	}
+	if secretCipher == nil {
+		return "", errors.New("secret encryption is not initialized")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(stored, encryptedPrefix))
+	if err != nil || len(sealed) < secretCipher.NonceSize() {
+		return "", errors.New("stored API key is corrupt")
+	}
+	nonce, ciphertext := sealed[:secretCipher.NonceSize()], sealed[secretCipher.NonceSize():]
+	plain, err := secretCipher.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", errors.New("stored API key can't be decrypted; the encryption key has changed, so enter the API key again")
+	}
+	return string(plain), nil
+}
+
+// RedactSecret shows enough of a key to recognise it, such as sk-...abcd
+func RedactSecret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	if len(secret) < 12 {
+		return "..."
+	}
+	prefix := ""
+	if i := strings.Index(secret, "-"); i >= 0 && i < 4 {
+		prefix = secret[:i+1]
+	}
+	return prefix + "..." + secret[len(secret)-4:]
+}
@@ -0,0 +1,291 @@
+package config
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"reflect"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/zucong/rp/llm"
+	"github.com/zucong/rp/models"
+)
+
+// Store reads and writes the database layer of the LLM settings. Each
+// column of the config row overrides the file and environment value; NULL
+// inherits it.
+type Store struct {
+	db *sqlx.DB
+}
+
+func NewStore(db *sqlx.DB) *Store {
+	return &Store{db: db}
+}
+
+// Overrides are the LLM settings saved in the database; nil fields inherit
+// the file and environment value
+type Overrides struct {
+	APIEndpoint      *string                  `json:"api_endpoint"`
+	APIKey           *string                  `json:"api_key"`
+	DefaultModel     *string                  `json:"default_model"`
+	EmbeddingModel   *string                  `json:"embedding_model"`
+	Provider         *string                  `json:"provider"`
+	APIMode          *string                  `json:"api_mode"`
+	InstructTemplate *string                  `json:"instruct_template"`
+	CustomTemplate   *models.InstructTemplate `json:"custom_template"`
+}
+
+// overrideRow is the config row as stored, with the API key encrypted
+type overrideRow struct {
+	APIEndpoint      sql.NullString `db:"api_endpoint"`
+	APIKey           sql.NullString `db:"api_key"`
+	DefaultModel     sql.NullString `db:"default_model"`
+	EmbeddingModel   sql.NullString `db:"embedding_model"`
+	Provider         sql.NullString `db:"provider"`
+	APIMode          sql.NullString `db:"api_mode"`
+	InstructTemplate sql.NullString `db:"instruct_template"`
+	CustomTemplate   sql.NullString `db:"custom_template"`
+}
+
+func nullable(s sql.NullString) *string {
+	if !s.Valid {
+		return nil
+	}
+	return &s.String
+}
+
+// Overrides returns the settings saved in the database. A stored API key
+// that can't be decrypted is treated as unset, so the file or environment
+// key applies until it is entered again.
+func (s *Store) Overrides() (*Overrides, error) {
+	var row overrideRow
+	err := s.db.Get(&row, "SELECT api_endpoint, api_key, default_model, embedding_model, provider, api_mode, instruct_template, custom_template FROM config WHERE id = 1")
+	if err != nil {
+		return nil, err
+	}
+
+	o := &Overrides{
+		APIEndpoint:      nullable(row.APIEndpoint),
+		DefaultModel:     nullable(row.DefaultModel),
+		EmbeddingModel:   nullable(row.EmbeddingModel),
+		Provider:         nullable(row.Provider),
+		APIMode:          nullable(row.APIMode),
+		InstructTemplate: nullable(row.InstructTemplate),
+	}
+	if row.APIKey.Valid {
+		if key, err := DecryptSecret(row.APIKey.String); err == nil {
+			o.APIKey = &key
+		}
+	}
+	if row.CustomTemplate.Valid {
+		var t models.InstructTemplate
+		if err := t.Scan(row.CustomTemplate.String); err != nil {
+			return nil, err
+		}
+		o.CustomTemplate = &t
+	}
+	return o, nil
+}
+
+// Get returns the effective LLM settings: the current file and environment
+// settings with the database overrides applied
+func (s *Store) Get() (*models.Config, error) {
+	o, err := s.Overrides()
+	if err != nil {
+		return &models.Config{}, err
+	}
+	return o.apply(Current().LLM), nil
+}
+
+func (o *Overrides) apply(base LLMConfig) *models.Config {
+	pick := func(override *string, inherited string) string {
+		if override != nil {
+			return *override
+		}
+		return inherited
+	}
+	cfg := &models.Config{
+		APIEndpoint:      pick(o.APIEndpoint, base.APIEndpoint),
+		APIKey:           pick(o.APIKey, base.APIKey),
+		DefaultModel:     pick(o.DefaultModel, base.DefaultModel),
+		EmbeddingModel:   pick(o.EmbeddingModel, base.EmbeddingModel),
+		Provider:         pick(o.Provider, base.Provider),
+		APIMode:          pick(o.APIMode, base.APIMode),
+		InstructTemplate: pick(o.InstructTemplate, base.InstructTemplate),
+	}
+	if o.CustomTemplate != nil {
+		cfg.CustomTemplate = *o.CustomTemplate
+	}
+	return cfg
+}
+
+// Overridden lists the settings set in the database, by JSON name
+func (o *Overrides) Overridden() []string {
+	names := []string{}
+	v := reflect.ValueOf(o).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if !v.Field(i).IsNil() {
+			names = append(names, strings.Split(v.Type().Field(i).Tag.Get("json"), ",")[0])
+		}
+	}
+	return names
+}
+
+// Update replaces the database overrides after checking the settings they
+// produce are valid. The API key is encrypted.
+func (s *Store) Update(o *Overrides) error {
+	cfg := o.apply(Current().LLM)
+	if err := ValidateLLM(LLMConfig{
+		APIEndpoint:      cfg.APIEndpoint,
+		DefaultModel:     cfg.DefaultModel,
+		Provider:         cfg.Provider,
+		APIMode:          cfg.APIMode,
+		InstructTemplate: cfg.InstructTemplate,
+	}); err != nil {
+		return &ValidationError{err}
+	}
+	if cfg.InstructTemplate == llm.TemplateCustom && cfg.CustomTemplate.AssistantPrefix == "" && cfg.CustomTemplate.UserPrefix == "" {
+		return &ValidationError{errors.New("custom template needs a user or assistant prefix")}
+	}
+
+	var apiKey, customTemplate interface{}
+	if o.APIKey != nil {
+		encrypted, err := EncryptSecret(*o.APIKey)
+		if err != nil {
+			return err
+		}
+		apiKey = encrypted
+	}
+	if o.CustomTemplate != nil {
+		customTemplate = *o.CustomTemplate
+	}
+	_, err := s.db.Exec(
+		`UPDATE config SET api_endpoint = ?, api_key = ?, default_model = ?, embedding_model = ?, provider = ?,
+			api_mode = ?, instruct_template = ?, custom_template = ?
+		WHERE id = 1`,
+		o.APIEndpoint, apiKey, o.DefaultModel, o.EmbeddingModel, o.Provider,
+		o.APIMode, o.InstructTemplate, customTemplate,
+	)
+	return err
+}
+
+// ValidationError reports settings that were rejected, as opposed to a
+// failure saving them
+type ValidationError struct {
+	Err error
+}
+
+func (e *ValidationError) Error() string {
+	return strings.ReplaceAll(e.Err.Error(), "\n", "; ")
+}
+
+// Setting is one effective setting and the layer it came from
+type Setting struct {
+	Key   string      `json:"key"`
+	Env   string      `json:"env,omitempty"`
+	Value interface{} `json:"value"`
+	// Source is default, yaml, env or db
+	Source string `json:"source"`
+	// PendingRestart is set when config.yaml changed a setting that is only
+	// read at startup
+	PendingRestart bool `json:"pending_restart,omitempty"`
+}
+
+// secretSettings are never shown in full; the API key is shown redacted
+// so it can be recognised, passwords not at all
+var secretSettings = map[string]func(string) string{
+	"llm.api_key": RedactSecret,
+	"auth.admin_password": func(password string) string {
+		if password == "" {
+			return ""
+		}
+		return "********"
+	},
+}
+
+// Effective lists every setting with its value and source. Secrets are
+// redacted.
+func (s *Store) Effective() ([]Setting, error) {
+	o, err := s.Overrides()
+	if err != nil {
+		return nil, err
+	}
+	dbValues := map[string]*string{
+		"llm.api_endpoint":      o.APIEndpoint,
+		"llm.api_key":           o.APIKey,
+		"llm.default_model":     o.DefaultModel,
+		"llm.embedding_model":   o.EmbeddingModel,
+		"llm.provider":          o.Provider,
+		"llm.api_mode":          o.APIMode,
+		"llm.instruct_template": o.InstructTemplate,
+	}
+
+	mu.RLock()
+	cfg, src := current, sources
+	mu.RUnlock()
+	startup := map[string]interface{}{}
+	eachSetting(&GlobalConfig, func(key, _ string, v reflect.Value) {
+		startup[key] = v.Interface()
+	})
+
+	var settings []Setting
+	eachSetting(&cfg, func(key, env string, v reflect.Value) {
+		setting := Setting{Key: key, Env: env, Value: v.Interface(), Source: src[key]}
+		if override := dbValues[key]; override != nil {
+			setting.Value, setting.Source = *override, SourceDB
+		}
+		if !strings.HasPrefix(key, "llm.") && !reflect.DeepEqual(startup[key], v.Interface()) {
+			setting.PendingRestart = true
+		}
+		if redact := secretSettings[key]; redact != nil {
+			setting.Value = redact(setting.Value.(string))
+		}
+		settings = append(settings, setting)
+	})
+
+	custom := Setting{Key: "llm.custom_template", Value: models.InstructTemplate{}, Source: SourceDefault}
+	if o.CustomTemplate != nil {
+		custom.Value, custom.Source = *o.CustomTemplate, SourceDB
+	}
+	return append(settings, custom), nil
+}
+
+// EncryptStoredKey encrypts an API key stored in plain text by an older
+// version and scrubs it from the LLM call logs written meanwhile. It also
+// warns when the stored key can't be decrypted with the current key.
+func (s *Store) EncryptStoredKey() error {
+	var stored sql.NullString
+	if err := s.db.Get(&stored, "SELECT api_key FROM config WHERE id = 1"); err != nil {
+		return err
+	}
+	if !stored.Valid || stored.String == "" {
+		return nil
+	}
+	if strings.HasPrefix(stored.String, encryptedPrefix) {
+		if _, err := DecryptSecret(stored.String); err != nil {
+			log.Printf("[Config] %v", err)
+		}
+		return nil
+	}
+
+	encrypted, err := EncryptSecret(stored.String)
+	if err != nil {
+		return err
+	}
+	if _, err := s.db.Exec("UPDATE config SET api_key = ? WHERE id = 1", encrypted); err != nil {
+		return err
+	}
+	redacted := RedactSecret(stored.String)
+	_, err = s.db.Exec(`
+		UPDATE llm_call_logs SET
+			request_body = REPLACE(request_body, ?1, ?2),
+			response_body = REPLACE(response_body, ?1, ?2),
+			error_message = REPLACE(error_message, ?1, ?2)`,
+		stored.String, redacted)
+	if err != nil {
+		return err
+	}
+	log.Printf("[Config] Encrypted the stored API key")
+	return nil
+}
@@ -2,8 +2,11 @@ package db
 
 import (
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/jmoiron/sqlx"
@@ -171,9 +174,507 @@ CREATE INDEX IF NOT EXISTS idx_decisions_llm_call ON orchestrator_decisions(llm_
 		return err
 	}
 
+	// Migration: create room checkpoint and rewind history tables
+	_, err = DB.Exec(`
+CREATE TABLE IF NOT EXISTS room_checkpoints (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    room_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    setting TEXT DEFAULT '',
+    last_message_id INTEGER DEFAULT 0,
+    message_count INTEGER DEFAULT 0,
+    snapshot TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (room_id) REFERENCES rooms(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_checkpoints_room_id ON room_checkpoints(room_id);
+
+CREATE TABLE IF NOT EXISTS room_rewinds (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    room_id INTEGER NOT NULL,
+    checkpoint_id INTEGER DEFAULT 0,
+    message_id INTEGER DEFAULT 0,
+    removed_messages TEXT NOT NULL,
+    restored_messages TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (room_id) REFERENCES rooms(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_rewinds_room_id ON room_rewinds(room_id);
+`)
+	if err != nil {
+		return err
+	}
+
+	// Migration: index messages for cursor pagination
+	_, err = DB.Exec(`CREATE INDEX IF NOT EXISTS idx_messages_room_id ON messages(room_id, id)`)
+	if err != nil {
+		return err
+	}
+
+	// Migration: message embeddings for semantic search
+	_, _ = DB.Exec(`ALTER TABLE config ADD COLUMN embedding_model TEXT DEFAULT 'text-embedding-3-small'`)
+	_, err = DB.Exec(`
+CREATE TABLE IF NOT EXISTS message_embeddings (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    message_id INTEGER NOT NULL,
+    room_id INTEGER NOT NULL,
+    model TEXT NOT NULL,
+    chunk_index INTEGER NOT NULL,
+    chunk_text TEXT NOT NULL,
+    embedding BLOB NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
+    FOREIGN KEY (room_id) REFERENCES rooms(id) ON DELETE CASCADE,
+    UNIQUE(message_id, model, chunk_index)
+);
+CREATE INDEX IF NOT EXISTS idx_embeddings_room_model ON message_embeddings(room_id, model);
+
+CREATE TABLE IF NOT EXISTS embedding_jobs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    room_id INTEGER NOT NULL,
+    model TEXT NOT NULL,
+    status TEXT NOT NULL,
+    total INTEGER DEFAULT 0,
+    processed INTEGER DEFAULT 0,
+    last_message_id INTEGER DEFAULT 0,
+    error_message TEXT DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (room_id) REFERENCES rooms(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_embedding_jobs_room_id ON embedding_jobs(room_id);
+`)
+	if err != nil {
+		return err
+	}
+
+	// Migration: avatar images kept from imported character cards
+	_, err = DB.Exec(`
+CREATE TABLE IF NOT EXISTS character_images (
+    character_id INTEGER PRIMARY KEY,
+    image BLOB NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (character_id) REFERENCES characters(id) ON DELETE CASCADE
+);
+`)
+	if err != nil {
+		return err
+	}
+
+	// Migration: alternative responses kept from imported chats
+	_, err = DB.Exec(`
+CREATE TABLE IF NOT EXISTS message_swipes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    message_id INTEGER NOT NULL,
+    swipe_index INTEGER NOT NULL,
+    content TEXT NOT NULL,
+    is_selected BOOLEAN DEFAULT FALSE,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
+    UNIQUE(message_id, swipe_index)
+);
+`)
+	if err != nil {
+		return err
+	}
+
+	// Migration: structured character fields
+	for _, column := range []string{
+		"description", "personality", "scenario",
+		"example_dialogues", "greeting", "post_history_instructions",
+	} {
+		_, _ = DB.Exec("ALTER TABLE characters ADD COLUMN " + column + " TEXT DEFAULT ''")
+	}
+
+	// Migration: immutable character versions
+	_, _ = DB.Exec(`ALTER TABLE characters ADD COLUMN version INTEGER DEFAULT 1`)
+	_, _ = DB.Exec(`ALTER TABLE room_participants ADD COLUMN character_version INTEGER DEFAULT 0`)
+	_, _ = DB.Exec(`ALTER TABLE llm_call_logs ADD COLUMN character_id INTEGER DEFAULT 0`)
+	_, _ = DB.Exec(`ALTER TABLE llm_call_logs ADD COLUMN character_version INTEGER DEFAULT 0`)
+	_, err = DB.Exec(`
+CREATE TABLE IF NOT EXISTS character_versions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    character_id INTEGER NOT NULL,
+    version INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    avatar TEXT DEFAULT '',
+    prompt TEXT NOT NULL,
+    description TEXT DEFAULT '',
+    personality TEXT DEFAULT '',
+    scenario TEXT DEFAULT '',
+    example_dialogues TEXT DEFAULT '',
+    greeting TEXT DEFAULT '',
+    post_history_instructions TEXT DEFAULT '',
+    is_user_playable BOOLEAN DEFAULT FALSE,
+    model_name TEXT DEFAULT 'gpt-3.5-turbo',
+    temperature REAL DEFAULT 0.7,
+    max_tokens INTEGER DEFAULT 1000,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (character_id) REFERENCES characters(id) ON DELETE CASCADE,
+    UNIQUE(character_id, version)
+);
+
+-- Existing characters start at version 1
+INSERT INTO character_versions (
+    character_id, version, name, avatar, prompt, description, personality, scenario,
+    example_dialogues, greeting, post_history_instructions, is_user_playable,
+    model_name, temperature, max_tokens, created_at
+)
+SELECT
+    id, 1, name, avatar, prompt, description, personality, scenario,
+    example_dialogues, greeting, post_history_instructions, is_user_playable,
+    model_name, temperature, max_tokens, updated_at
+FROM characters
+WHERE NOT EXISTS (SELECT 1 FROM character_versions v WHERE v.character_id = characters.id);
+`)
+	if err != nil {
+		return err
+	}
+
+	// Migration: per-room participant overrides
+	_, _ = DB.Exec(`ALTER TABLE room_participants ADD COLUMN model_override TEXT DEFAULT ''`)
+	_, _ = DB.Exec(`ALTER TABLE room_participants ADD COLUMN temperature_override REAL`)
+	_, _ = DB.Exec(`ALTER TABLE room_participants ADD COLUMN max_tokens_override INTEGER`)
+	_, _ = DB.Exec(`ALTER TABLE room_participants ADD COLUMN extra_prompt TEXT DEFAULT ''`)
+	_, _ = DB.Exec(`ALTER TABLE room_participants ADD COLUMN nickname TEXT DEFAULT ''`)
+
+	// Migration: sampler presets and provider-specific parameter support
+	_, _ = DB.Exec(`ALTER TABLE config ADD COLUMN provider TEXT DEFAULT 'auto'`)
+	_, _ = DB.Exec(`ALTER TABLE characters ADD COLUMN sampler_preset_id INTEGER DEFAULT 0`)
+	_, _ = DB.Exec(`ALTER TABLE character_versions ADD COLUMN sampler_preset_id INTEGER DEFAULT 0`)
+	_, _ = DB.Exec(`ALTER TABLE rooms ADD COLUMN sampler_preset_id INTEGER DEFAULT 0`)
+	_, err = DB.Exec(`
+CREATE TABLE IF NOT EXISTS sampler_presets (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL UNIQUE,
+    description TEXT DEFAULT '',
+    params TEXT NOT NULL DEFAULT '{}',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+`)
+	if err != nil {
+		return err
+	}
+
+	// Migration: text-completion mode with instruct templates
+	_, _ = DB.Exec(`ALTER TABLE config ADD COLUMN api_mode TEXT DEFAULT 'chat'`)
+	_, _ = DB.Exec(`ALTER TABLE config ADD COLUMN instruct_template TEXT DEFAULT 'chatml'`)
+	_, _ = DB.Exec(`ALTER TABLE config ADD COLUMN custom_template TEXT DEFAULT '{}'`)
+
+	// Migration: editable prompt templates; room_id 0 holds the global ones
+	_, err = DB.Exec(`
+CREATE TABLE IF NOT EXISTS prompt_templates (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    room_id INTEGER NOT NULL DEFAULT 0,
+    kind TEXT NOT NULL,
+    content TEXT NOT NULL,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(room_id, kind)
+);
+`)
+	if err != nil {
+		return err
+	}
+
+	// Migration: author's notes for rooms and characters
+	_, err = DB.Exec(`
+CREATE TABLE IF NOT EXISTS authors_notes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    room_id INTEGER NOT NULL DEFAULT 0,
+    character_id INTEGER NOT NULL DEFAULT 0,
+    content TEXT NOT NULL,
+    depth INTEGER NOT NULL DEFAULT 4,
+    role TEXT NOT NULL DEFAULT 'system',
+    frequency INTEGER NOT NULL DEFAULT 1,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(room_id, character_id)
+);
+`)
+	if err != nil {
+		return err
+	}
+
+	// Migration: user accounts and login sessions; sessions keep only a
+	// hash of the token handed to the client
+	_, err = DB.Exec(`
+CREATE TABLE IF NOT EXISTS users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    username TEXT NOT NULL UNIQUE,
+    password_hash TEXT NOT NULL,
+    is_admin BOOLEAN NOT NULL DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    expires_at DATETIME NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(user_id);
+`)
+	if err != nil {
+		return err
+	}
+
+	// Migration: room membership roles and human participants assigned to
+	// accounts
+	_, err = DB.Exec(`
+CREATE TABLE IF NOT EXISTS room_members (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    room_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    role TEXT NOT NULL DEFAULT 'player',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(room_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_room_members_user ON room_members(user_id);
+`)
+	if err != nil {
+		return err
+	}
+	_, _ = DB.Exec(`ALTER TABLE room_participants ADD COLUMN user_id INTEGER DEFAULT 0`)
+
+	// Migration: public read-only share links for rooms; a NULL expires_at
+	// never expires
+	_, err = DB.Exec(`
+CREATE TABLE IF NOT EXISTS share_links (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    room_id INTEGER NOT NULL,
+    token TEXT NOT NULL UNIQUE,
+    live BOOLEAN NOT NULL DEFAULT 0,
+    expires_at DATETIME,
+    created_by INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+`)
+	if err != nil {
+		return err
+	}
+
+	// Migration: config row columns become overrides of config.yaml and
+	// the environment, with NULL inheriting. Values still at the old
+	// built-in defaults or empty are cleared once so the config file takes
+	// effect.
+	var hasLayeredConfig bool
+	err = DB.Get(&hasLayeredConfig, `SELECT COUNT(*) > 0 FROM pragma_table_info('config') WHERE name = 'layered'`)
+	if err != nil {
+		return err
+	}
+	if !hasLayeredConfig {
+		_, err = DB.Exec(`
+UPDATE config SET
+    api_endpoint = NULLIF(NULLIF(api_endpoint, 'https://api.openai.com/v1'), ''),
+    api_key = NULLIF(api_key, ''),
+    default_model = NULLIF(NULLIF(default_model, 'gpt-3.5-turbo'), ''),
+    embedding_model = NULLIF(NULLIF(embedding_model, 'text-embedding-3-small'), ''),
+    provider = NULLIF(NULLIF(provider, 'auto'), ''),
+    api_mode = NULLIF(NULLIF(api_mode, 'chat'), ''),
+    instruct_template = NULLIF(NULLIF(instruct_template, 'chatml'), ''),
+    custom_template = NULLIF(custom_template, '{}');
+ALTER TABLE config ADD COLUMN layered BOOLEAN DEFAULT 1;
+`)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Migration: add turn_policy to rooms
+	_, _ = DB.Exec(`ALTER TABLE rooms ADD COLUMN turn_policy TEXT DEFAULT 'queue'`)
+
+	// Migration: per-room message sequence numbers. created_at only has
+	// one-second resolution, so messages are ordered by seq. Existing
+	// messages are numbered in their old created_at, id order, computed
+	// once into a temporary table rather than per row. Each room keeps the
+	// last number handed out so numbers are never reused.
+	var hasMessageSeq bool
+	err = DB.Get(&hasMessageSeq, `SELECT COUNT(*) > 0 FROM pragma_table_info('messages') WHERE name = 'seq'`)
+	if err != nil {
+		return err
+	}
+	if !hasMessageSeq {
+		tx, err := DB.Beginx()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		_, err = tx.Exec(`
+ALTER TABLE messages ADD COLUMN seq INTEGER;
+ALTER TABLE rooms ADD COLUMN message_seq INTEGER NOT NULL DEFAULT 0;
+CREATE TEMP TABLE message_numbers (id INTEGER PRIMARY KEY, seq INTEGER NOT NULL);
+INSERT INTO message_numbers (id, seq)
+SELECT id, ROW_NUMBER() OVER (PARTITION BY room_id ORDER BY created_at, id) FROM messages;
+UPDATE messages SET seq = n.seq FROM message_numbers n WHERE n.id = messages.id;
+DROP TABLE message_numbers;
+UPDATE rooms SET message_seq = COALESCE((SELECT MAX(seq) FROM messages WHERE room_id = rooms.id), 0);
+`)
+		if err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	// New messages take their number in the INSERT (see NextMessageSeq)
+	// and the room's counter follows the highest number inserted. The
+	// earlier trigger numbered messages with an UPDATE after the insert,
+	// which reindexed every new message for search.
+	_, err = DB.Exec(`
+CREATE UNIQUE INDEX IF NOT EXISTS idx_messages_room_seq ON messages(room_id, seq);
+DROP TRIGGER IF EXISTS messages_seq;
+CREATE TRIGGER IF NOT EXISTS messages_seq_counter AFTER INSERT ON messages WHEN NEW.seq IS NOT NULL BEGIN
+    UPDATE rooms SET message_seq = NEW.seq WHERE id = NEW.room_id AND message_seq < NEW.seq;
+END;
+`)
+	if err != nil {
+		return err
+	}
+
+	// Migration: remove embeddings and embedding jobs left behind by
+	// messages and rooms deleted before their cleanup was added. Foreign
+	// keys aren't enforced, so ON DELETE CASCADE never removed them.
+	_, err = DB.Exec(`
+DELETE FROM message_embeddings WHERE message_id NOT IN (SELECT id FROM messages);
+DELETE FROM embedding_jobs WHERE room_id NOT IN (SELECT id FROM rooms);
+`)
+	if err != nil {
+		return err
+	}
+
+	// Migration: remove checkpoints and rewinds of rooms deleted before
+	// their cleanup was added
+	_, err = DB.Exec(`
+DELETE FROM room_checkpoints WHERE room_id NOT IN (SELECT id FROM rooms);
+DELETE FROM room_rewinds WHERE room_id NOT IN (SELECT id FROM rooms);
+`)
+	if err != nil {
+		return err
+	}
+
+	// Migration: remove swipes of messages deleted before their cleanup
+	// was added
+	_, err = DB.Exec("DELETE FROM message_swipes WHERE message_id NOT IN (SELECT id FROM messages)")
+	if err != nil {
+		return err
+	}
+
+	// Migration: remove prompt templates of rooms deleted before their
+	// cleanup was added; room_id 0 holds the global ones
+	_, err = DB.Exec("DELETE FROM prompt_templates WHERE room_id != 0 AND room_id NOT IN (SELECT id FROM rooms)")
+	if err != nil {
+		return err
+	}
+
+	// Migration: remove author's notes of rooms deleted before their
+	// cleanup was added; room_id 0 holds the character notes
+	_, err = DB.Exec("DELETE FROM authors_notes WHERE room_id != 0 AND room_id NOT IN (SELECT id FROM rooms)")
+	if err != nil {
+		return err
+	}
+
+	// Migration: full-text search indexes
+	if err := migrateFTS(); err != nil {
+		log.Printf("[DB] Full-text search disabled: %v", err)
+	}
+
+	return nil
+}
+
+// FTSEnabled reports whether the FTS5 search indexes are available. FTS5 is
+// only compiled into go-sqlite3 when building with -tags sqlite_fts5.
+var FTSEnabled bool
+
+// ftsIndexes describes each external-content FTS5 table, the table it
+// indexes and the columns it mirrors
+var ftsIndexes = []struct {
+	table   string
+	source  string
+	columns []string
+}{
+	{"messages_fts", "messages", []string{"content"}},
+	{"characters_fts", "characters", []string{"name", "prompt"}},
+	{"rooms_fts", "rooms", []string{"name", "setting"}},
+}
+
+func migrateFTS() error {
+	var available bool
+	if err := DB.Get(&available, `SELECT sqlite_compileoption_used('ENABLE_FTS5')`); err != nil {
+		return err
+	}
+	if !available {
+		// Without FTS5 the triggers would make every write to the source tables fail
+		for _, idx := range ftsIndexes {
+			for _, suffix := range []string{"_ai", "_ad", "_au"} {
+				_, _ = DB.Exec("DROP TRIGGER IF EXISTS " + idx.table + suffix)
+			}
+		}
+		return fmt.Errorf("sqlite was built without FTS5")
+	}
+
+	for _, idx := range ftsIndexes {
+		// The triggers are dropped whenever FTS5 is unavailable, so a missing
+		// trigger means the index may be stale and has to be rebuilt
+		var synced bool
+		err := DB.Get(&synced, `SELECT COUNT(*) > 0 FROM sqlite_master WHERE type = 'trigger' AND name = ?`, idx.table+"_ai")
+		if err != nil {
+			return err
+		}
+
+		cols := strings.Join(idx.columns, ", ")
+		newCols := "new." + strings.Join(idx.columns, ", new.")
+		oldCols := "old." + strings.Join(idx.columns, ", old.")
+		// Updates are only reindexed when an indexed column changes, so
+		// counters such as rooms.message_seq don't rewrite the index. The
+		// update trigger is recreated to replace the unscoped one earlier
+		// versions created.
+		_, err = DB.Exec(fmt.Sprintf(`
+CREATE VIRTUAL TABLE IF NOT EXISTS %[1]s USING fts5(%[3]s, content='%[2]s', content_rowid='id');
+
+CREATE TRIGGER IF NOT EXISTS %[1]s_ai AFTER INSERT ON %[2]s BEGIN
+    INSERT INTO %[1]s(rowid, %[3]s) VALUES (new.id, %[4]s);
+END;
+CREATE TRIGGER IF NOT EXISTS %[1]s_ad AFTER DELETE ON %[2]s BEGIN
+    INSERT INTO %[1]s(%[1]s, rowid, %[3]s) VALUES ('delete', old.id, %[5]s);
+END;
+DROP TRIGGER IF EXISTS %[1]s_au;
+CREATE TRIGGER %[1]s_au AFTER UPDATE OF %[3]s ON %[2]s BEGIN
+    INSERT INTO %[1]s(%[1]s, rowid, %[3]s) VALUES ('delete', old.id, %[5]s);
+    INSERT INTO %[1]s(rowid, %[3]s) VALUES (new.id, %[4]s);
+END;
+`, idx.table, idx.source, cols, newCols, oldCols))
+		if err != nil {
+			return err
+		}
+
+		// Index rows written while the triggers were missing
+		if !synced {
+			_, err = DB.Exec(fmt.Sprintf(`INSERT INTO %[1]s(%[1]s) VALUES ('rebuild')`, idx.table))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	FTSEnabled = true
 	return nil
 }
 
+// NextMessageSeq is the value of messages.seq when inserting a new message:
+// the room's next sequence number. It takes the room ID as its parameter.
+const NextMessageSeq = "(SELECT message_seq + 1 FROM rooms WHERE id = ?)"
+
+// FormatTime formats t the way CURRENT_TIMESTAMP does, so that rows written
+// with explicit timestamps sort and compare correctly against defaulted ones
+func FormatTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
+
 func Close() error {
 	if DB != nil {
 		return DB.Close()
@@ -0,0 +1,343 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"github.com/zucong/rp/config"
+	"github.com/zucong/rp/models"
+	"github.com/zucong/rp/services"
+)
+
+// SessionCookie holds the session token in browsers; API clients can send
+// the same token as "Authorization: Bearer <token>" instead
+const SessionCookie = "rp_session"
+
+type AuthHandler struct {
+	db           *sqlx.DB
+	sessionTTL   time.Duration
+	secureCookie bool
+}
+
+func NewAuthHandler(db *sqlx.DB) *AuthHandler {
+	return &AuthHandler{
+		db:           db,
+		sessionTTL:   time.Duration(config.GlobalConfig.Auth.SessionHours) * time.Hour,
+		secureCookie: config.GlobalConfig.Auth.SecureCookie,
+	}
+}
+
+// sessionToken returns the token a request carries, preferring the
+// Authorization header over the cookie
+func sessionToken(c *gin.Context) string {
+	if header := c.GetHeader("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+	}
+	token, _ := c.Cookie(SessionCookie)
+	return token
+}
+
+// RequireAuth rejects requests without a valid session and makes the
+// logged-in user available to later handlers through currentUser
+func (h *AuthHandler) RequireAuth(c *gin.Context) {
+	user, err := services.SessionUser(h.db, sessionToken(c))
+	if err != nil {
+		if errors.Is(err, services.ErrNoSession) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "login required"})
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Set("user", user)
+	c.Next()
+}
+
+// RequireAdmin rejects requests from users who are not admins; it must run
+// after RequireAuth
+func (h *AuthHandler) RequireAdmin(c *gin.Context) {
+	if user := currentUser(c); user == nil || !user.IsAdmin {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin only"})
+		return
+	}
+	c.Next()
+}
+
+// currentUser returns the user RequireAuth attached to the request
+func currentUser(c *gin.Context) *models.User {
+	if v, ok := c.Get("user"); ok {
+		return v.(*models.User)
+	}
+	return nil
+}
+
+type LoginRequest struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+func (h *AuthHandler) Login(c *gin.Context) {
+	var req LoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := services.Authenticate(h.db, req.Username, req.Password)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidLogin) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	token, expires, ok := h.startSession(c, user.ID)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"user": user, "token": token, "expires_at": expires})
+}
+
+// startSession creates a session and sets its cookie
+func (h *AuthHandler) startSession(c *gin.Context, userID int64) (string, time.Time, bool) {
+	token, expires, err := services.CreateSession(h.db, userID, h.sessionTTL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return "", time.Time{}, false
+	}
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(SessionCookie, token, int(h.sessionTTL.Seconds()), "/", "", h.secureCookie, true)
+	return token, expires, true
+}
+
+func (h *AuthHandler) Logout(c *gin.Context) {
+	if err := services.DeleteSession(h.db, sessionToken(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(SessionCookie, "", -1, "/", "", h.secureCookie, true)
+	c.Status(http.StatusNoContent)
+}
+
+func (h *AuthHandler) Me(c *gin.Context) {
+	c.JSON(http.StatusOK, currentUser(c))
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+// ChangePassword sets the logged-in user's password. Every other session
+// of the user is ended and this one is replaced.
+func (h *AuthHandler) ChangePassword(c *gin.Context) {
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user := currentUser(c)
+	if _, err := services.Authenticate(h.db, user.Username, req.CurrentPassword); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "current password is wrong"})
+		return
+	}
+	if !h.setPassword(c, user.ID, req.NewPassword) {
+		return
+	}
+	if _, _, ok := h.startSession(c, user.ID); !ok {
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// setPassword stores a new password and ends the user's sessions
+func (h *AuthHandler) setPassword(c *gin.Context, userID int64, password string) bool {
+	hash, err := services.HashPassword(password)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	if _, err := h.db.Exec("UPDATE users SET password_hash = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", hash, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if err := services.DeleteUserSessions(h.db, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
+
+func (h *AuthHandler) ListUsers(c *gin.Context) {
+	var users []models.User
+	if err := h.db.Select(&users, "SELECT * FROM users ORDER BY id ASC"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if users == nil {
+		users = []models.User{}
+	}
+	c.JSON(http.StatusOK, users)
+}
+
+type CreateUserRequest struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+	IsAdmin  bool   `json:"is_admin"`
+}
+
+func (h *AuthHandler) CreateUser(c *gin.Context) {
+	var req CreateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user, err := services.CreateUser(h.db, req.Username, req.Password, req.IsAdmin)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, user)
+}
+
+type UpdateUserRequest struct {
+	// Password resets the user's password when set
+	Password *string `json:"password"`
+	IsAdmin  *bool   `json:"is_admin"`
+}
+
+func (h *AuthHandler) UpdateUser(c *gin.Context) {
+	user, ok := h.loadUser(c)
+	if !ok {
+		return
+	}
+	var req UpdateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.IsAdmin != nil && !*req.IsAdmin && user.IsAdmin {
+		if !h.otherAdminExists(c, user.ID) {
+			return
+		}
+	}
+	if req.Password != nil && !h.setPassword(c, user.ID, *req.Password) {
+		return
+	}
+	if req.IsAdmin != nil {
+		if _, err := h.db.Exec("UPDATE users SET is_admin = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", *req.IsAdmin, user.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if err := h.db.Get(user, "SELECT * FROM users WHERE id = ?", user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+func (h *AuthHandler) DeleteUser(c *gin.Context) {
+	user, ok := h.loadUser(c)
+	if !ok {
+		return
+	}
+	if user.ID == currentUser(c).ID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot delete your own account"})
+		return
+	}
+	if user.IsAdmin && !h.otherAdminExists(c, user.ID) {
+		return
+	}
+
+	// Like demoting a room's last owner, deleting them would leave the
+	// room to admins only
+	var orphaned int
+	err := h.db.Get(&orphaned, `
+		SELECT COUNT(*) FROM room_members m
+		WHERE m.user_id = ? AND m.role = ? AND NOT EXISTS (
+			SELECT 1 FROM room_members o
+			WHERE o.room_id = m.room_id AND o.role = ? AND o.user_id != m.user_id
+		)`, user.ID, models.RoomRoleOwner, models.RoomRoleOwner)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if orphaned > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user is the last owner of a room; make someone else an owner first"})
+		return
+	}
+
+	tx, err := h.db.Beginx()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	if err := services.DeleteUserSessions(tx, user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for _, query := range []string{
+		"DELETE FROM room_members WHERE user_id = ?",
+		"UPDATE room_participants SET user_id = 0 WHERE user_id = ?",
+		"DELETE FROM users WHERE id = ?",
+	} {
+		if _, err := tx.Exec(query, user.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *AuthHandler) loadUser(c *gin.Context) (*models.User, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return nil, false
+	}
+	var user models.User
+	err = h.db.Get(&user, "SELECT * FROM users WHERE id = ?", id)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return &user, true
+}
+
+// otherAdminExists guards against removing the last admin, which would
+// leave nobody able to manage accounts or the config
+func (h *AuthHandler) otherAdminExists(c *gin.Context, userID int64) bool {
+	var count int
+	if err := h.db.Get(&count, "SELECT COUNT(*) FROM users WHERE is_admin = 1 AND id != ?", userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if count == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot remove the last admin"})
+		return false
+	}
+	return true
+}
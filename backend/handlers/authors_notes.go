@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"github.com/zucong/rp/models"
+)
+
+type AuthorsNoteHandler struct {
+	db *sqlx.DB
+}
+
+func NewAuthorsNoteHandler(db *sqlx.DB) *AuthorsNoteHandler {
+	return &AuthorsNoteHandler{db: db}
+}
+
+// noteOwner returns the room and character a note route refers to; exactly
+// one of them is set. Room notes are only for the room's owners.
+func noteOwner(c *gin.Context, db *sqlx.DB, byCharacter bool) (roomID, characterID int64, ok bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return 0, 0, false
+	}
+	if byCharacter {
+		return 0, id, true
+	}
+	if _, ok := requireRoomRole(c, db, id, models.RoomRoleOwner); !ok {
+		return 0, 0, false
+	}
+	return id, 0, true
+}
+
+func (h *AuthorsNoteHandler) GetRoom(c *gin.Context)      { h.get(c, false) }
+func (h *AuthorsNoteHandler) GetCharacter(c *gin.Context) { h.get(c, true) }
+
+func (h *AuthorsNoteHandler) get(c *gin.Context, byCharacter bool) {
+	roomID, characterID, ok := noteOwner(c, h.db, byCharacter)
+	if !ok {
+		return
+	}
+
+	var note models.AuthorsNote
+	err := h.db.Get(&note, "SELECT * FROM authors_notes WHERE room_id = ? AND character_id = ?", roomID, characterID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no author's note"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, note)
+}
+
+func (h *AuthorsNoteHandler) UpdateRoom(c *gin.Context)      { h.update(c, false) }
+func (h *AuthorsNoteHandler) UpdateCharacter(c *gin.Context) { h.update(c, true) }
+
+func (h *AuthorsNoteHandler) update(c *gin.Context, byCharacter bool) {
+	roomID, characterID, ok := noteOwner(c, h.db, byCharacter)
+	if !ok {
+		return
+	}
+
+	note := models.AuthorsNote{Depth: 4, Role: "system", Frequency: 1}
+	if err := c.ShouldBindJSON(&note); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateAuthorsNote(&note); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	note.RoomID, note.CharacterID = roomID, characterID
+
+	_, err := h.db.NamedExec(`
+		INSERT INTO authors_notes (room_id, character_id, content, depth, role, frequency)
+		VALUES (:room_id, :character_id, :content, :depth, :role, :frequency)
+		ON CONFLICT(room_id, character_id) DO UPDATE SET
+			content = excluded.content,
+			depth = excluded.depth,
+			role = excluded.role,
+			frequency = excluded.frequency,
+			updated_at = CURRENT_TIMESTAMP`, &note)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.db.Get(&note, "SELECT * FROM authors_notes WHERE room_id = ? AND character_id = ?", roomID, characterID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, note)
+}
+
+func (h *AuthorsNoteHandler) DeleteRoom(c *gin.Context)      { h.delete(c, false) }
+func (h *AuthorsNoteHandler) DeleteCharacter(c *gin.Context) { h.delete(c, true) }
+
+func (h *AuthorsNoteHandler) delete(c *gin.Context, byCharacter bool) {
+	roomID, characterID, ok := noteOwner(c, h.db, byCharacter)
+	if !ok {
+		return
+	}
+	_, err := h.db.Exec("DELETE FROM authors_notes WHERE room_id = ? AND character_id = ?", roomID, characterID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func validateAuthorsNote(note *models.AuthorsNote) error {
+	note.Content = strings.TrimSpace(note.Content)
+	switch {
+	case note.Content == "":
+		return errors.New("content is required")
+	case note.Depth < 0:
+		return errors.New("depth must not be negative")
+	case note.Frequency < 0:
+		return errors.New("frequency must not be negative")
+	case note.Role != "system" && note.Role != "user" && note.Role != "assistant":
+		return errors.New("role must be system, user or assistant")
+	}
+	return nil
+}
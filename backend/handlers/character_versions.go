@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zucong/rp/models"
+	"github.com/zucong/rp/services"
+)
+
+// ListVersions returns every version of a character, newest first
+func (h *CharacterHandler) ListVersions(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	versions := []models.CharacterVersion{}
+	err = h.db.Select(&versions, "SELECT * FROM character_versions WHERE character_id = ? ORDER BY version DESC", id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, versions)
+}
+
+func (h *CharacterHandler) GetVersion(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil || version <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid version"})
+		return
+	}
+
+	v, err := services.GetCharacterVersion(h.db, id, version)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "version not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, v)
+}
+
+// DiffVersions compares two versions of a character. from defaults to the
+// version before to, and to defaults to the latest version.
+func (h *CharacterHandler) DiffVersions(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	var to, from int
+	if v := c.Query("to"); v != "" {
+		if to, err = strconv.Atoi(v); err != nil || to <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to version"})
+			return
+		}
+	}
+	toVersion, err := services.GetCharacterVersion(h.db, id, to)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "version not found"})
+		return
+	}
+
+	from = toVersion.Version - 1
+	if v := c.Query("from"); v != "" {
+		if from, err = strconv.Atoi(v); err != nil || from <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from version"})
+			return
+		}
+	}
+	if from <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "character has only one version"})
+		return
+	}
+	fromVersion, err := services.GetCharacterVersion(h.db, id, from)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "version not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":    fromVersion.Version,
+		"to":      toVersion.Version,
+		"changes": services.DiffCharacterVersions(fromVersion, toVersion),
+	})
+}
+
+// RollbackVersion restores an earlier version's definition. History is kept:
+// the restored definition becomes a new version.
+func (h *CharacterHandler) RollbackVersion(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil || version <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid version"})
+		return
+	}
+
+	v, err := services.GetCharacterVersion(h.db, id, version)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "version not found"})
+		return
+	}
+
+	tx, err := h.db.Beginx()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.NamedExec(
+		`UPDATE characters SET
+			name = :name,
+			avatar = :avatar,
+			prompt = :prompt,
+			description = :description,
+			personality = :personality,
+			scenario = :scenario,
+			example_dialogues = :example_dialogues,
+			greeting = :greeting,
+			post_history_instructions = :post_history_instructions,
+			is_user_playable = :is_user_playable,
+			model_name = :model_name,
+			temperature = :temperature,
+			max_tokens = :max_tokens,
+			sampler_preset_id = :sampler_preset_id,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = :character_id`,
+		v,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := services.SnapshotCharacter(tx, id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var character models.Character
+	if err := h.db.Get(&character, "SELECT * FROM characters WHERE id = ?", id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, character)
+}
@@ -1,12 +1,18 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jmoiron/sqlx"
 	"github.com/zucong/rp/models"
+	"github.com/zucong/rp/services"
 )
 
 type CharacterHandler struct {
@@ -57,8 +63,13 @@ func (h *CharacterHandler) Create(c *gin.Context) {
 	}
 
 	result, err := h.db.NamedExec(
-		`INSERT INTO characters (name, avatar, prompt, is_user_playable, model_name, temperature, max_tokens)
-		VALUES (:name, :avatar, :prompt, :is_user_playable, :model_name, :temperature, :max_tokens)`,
+		`INSERT INTO characters (
+			name, avatar, prompt, description, personality, scenario, example_dialogues,
+			greeting, post_history_instructions, is_user_playable, model_name, temperature, max_tokens, sampler_preset_id
+		) VALUES (
+			:name, :avatar, :prompt, :description, :personality, :scenario, :example_dialogues,
+			:greeting, :post_history_instructions, :is_user_playable, :model_name, :temperature, :max_tokens, :sampler_preset_id
+		)`,
 		&character,
 	)
 	if err != nil {
@@ -68,6 +79,11 @@ func (h *CharacterHandler) Create(c *gin.Context) {
 
 	id, _ := result.LastInsertId()
 	character.ID = id
+	character.Version, err = services.SnapshotCharacter(h.db, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 	c.JSON(http.StatusCreated, character)
 }
 
@@ -84,16 +100,30 @@ func (h *CharacterHandler) Update(c *gin.Context) {
 		return
 	}
 
+	tx, err := h.db.Beginx()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
 	character.ID = id
-	_, err = h.db.NamedExec(
+	_, err = tx.NamedExec(
 		`UPDATE characters SET
 			name = :name,
 			avatar = :avatar,
 			prompt = :prompt,
+			description = :description,
+			personality = :personality,
+			scenario = :scenario,
+			example_dialogues = :example_dialogues,
+			greeting = :greeting,
+			post_history_instructions = :post_history_instructions,
 			is_user_playable = :is_user_playable,
 			model_name = :model_name,
 			temperature = :temperature,
 			max_tokens = :max_tokens,
+			sampler_preset_id = :sampler_preset_id,
 			updated_at = CURRENT_TIMESTAMP
 		WHERE id = :id`,
 		&character,
@@ -103,6 +133,18 @@ func (h *CharacterHandler) Update(c *gin.Context) {
 		return
 	}
 
+	// Rooms following the latest version pick up the change; pinned
+	// participants keep the version they were pinned to
+	character.Version, err = services.SnapshotCharacter(tx, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
 	c.JSON(http.StatusOK, character)
 }
 
@@ -130,6 +172,244 @@ func (h *CharacterHandler) Delete(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	_, _ = h.db.Exec("DELETE FROM character_images WHERE character_id = ?", id)
+	_, _ = h.db.Exec("DELETE FROM character_versions WHERE character_id = ?", id)
+	_, _ = h.db.Exec("DELETE FROM authors_notes WHERE room_id = 0 AND character_id = ?", id)
 
 	c.Status(http.StatusNoContent)
 }
+
+// maxCardSize limits uploaded character cards
+const maxCardSize = 20 << 20
+
+// Import creates a character from a TavernAI / SillyTavern card. The card can
+// be uploaded as a multipart "file" field or sent as the raw request body, as
+// either a PNG with an embedded card or V1/V2 card JSON.
+func (h *CharacterHandler) Import(c *gin.Context) {
+	raw, err := readUpload(c, maxCardSize)
+	if errors.Is(err, errUploadTooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "card file too large"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var image []byte
+	cardJSON := raw
+	if services.IsPNG(raw) {
+		cardJSON, err = services.ReadCardPNG(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		image = raw
+	}
+
+	card, err := services.ParseCard(cardJSON)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	character := cardToCharacter(card)
+	result, err := h.db.NamedExec(
+		`INSERT INTO characters (
+			name, avatar, prompt, description, personality, scenario, example_dialogues,
+			greeting, post_history_instructions, is_user_playable, model_name, temperature, max_tokens, sampler_preset_id
+		) VALUES (
+			:name, :avatar, :prompt, :description, :personality, :scenario, :example_dialogues,
+			:greeting, :post_history_instructions, :is_user_playable, :model_name, :temperature, :max_tokens, :sampler_preset_id
+		)`,
+		&character,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	id, _ := result.LastInsertId()
+	character.ID = id
+	character.Version, err = services.SnapshotCharacter(h.db, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if image != nil {
+		_, err = h.db.Exec("INSERT OR REPLACE INTO character_images (character_id, image) VALUES (?, ?)", id, image)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	c.JSON(http.StatusCreated, character)
+}
+
+// Export writes the character as a V2 card. The default format is a PNG card
+// using the imported avatar image (or a generated placeholder); format=json
+// returns the bare card JSON.
+func (h *CharacterHandler) Export(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	var character models.Character
+	err = h.db.Get(&character, "SELECT * FROM characters WHERE id = ?", id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "character not found"})
+		return
+	}
+
+	card := services.CardV2{
+		Spec:        "chara_card_v2",
+		SpecVersion: "2.0",
+		Data:        characterToCard(&character),
+	}
+	cardJSON, err := json.Marshal(card)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	filename := cardFilename(character.Name)
+	if c.Query("format") == "json" {
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, filename))
+		c.Data(http.StatusOK, "application/json", cardJSON)
+		return
+	}
+
+	var image []byte
+	_ = h.db.Get(&image, "SELECT image FROM character_images WHERE character_id = ?", id)
+
+	pngData, err := services.WriteCardPNG(image, cardJSON, character.Name)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.png"`, filename))
+	c.Data(http.StatusOK, "image/png", pngData)
+}
+
+// cardExtensionKey namespaces our own fields inside a card's extensions so
+// that exported cards round-trip without loss
+const cardExtensionKey = "rp"
+
+func cardToCharacter(card *services.CardData) models.Character {
+	character := models.Character{
+		Name:                    card.Name,
+		Prompt:                  strings.TrimSpace(card.SystemPrompt),
+		Description:             strings.TrimSpace(card.Description),
+		Personality:             strings.TrimSpace(card.Personality),
+		Scenario:                strings.TrimSpace(card.Scenario),
+		ExampleDialogues:        strings.TrimSpace(card.MesExample),
+		Greeting:                strings.TrimSpace(card.FirstMes),
+		PostHistoryInstructions: strings.TrimSpace(card.PostHistoryInstructions),
+		ModelName:               "gpt-3.5-turbo",
+		Temperature:             0.7,
+		MaxTokens:               1000,
+	}
+
+	if ext, ok := card.Extensions[cardExtensionKey].(map[string]interface{}); ok {
+		if v, ok := ext["avatar"].(string); ok {
+			character.Avatar = v
+		}
+		if v, ok := ext["prompt"].(string); ok && v != "" {
+			character.Prompt = v
+		}
+		if v, ok := ext["is_user_playable"].(bool); ok {
+			character.IsUserPlayable = v
+		}
+		if v, ok := ext["model_name"].(string); ok && v != "" {
+			character.ModelName = v
+		}
+		if v, ok := ext["temperature"].(float64); ok {
+			character.Temperature = v
+		}
+		if v, ok := ext["max_tokens"].(float64); ok && v > 0 {
+			character.MaxTokens = int(v)
+		}
+	}
+
+	return character
+}
+
+func characterToCard(character *models.Character) services.CardData {
+	return services.CardData{
+		Name:                    character.Name,
+		Description:             character.Description,
+		Personality:             character.Personality,
+		Scenario:                character.Scenario,
+		FirstMes:                character.Greeting,
+		MesExample:              character.ExampleDialogues,
+		SystemPrompt:            character.Prompt,
+		PostHistoryInstructions: character.PostHistoryInstructions,
+		Tags:                    []string{},
+		Extensions: map[string]interface{}{
+			cardExtensionKey: map[string]interface{}{
+				"avatar":           character.Avatar,
+				"is_user_playable": character.IsUserPlayable,
+				"model_name":       character.ModelName,
+				"temperature":      character.Temperature,
+				"max_tokens":       character.MaxTokens,
+			},
+		},
+	}
+}
+
+// errUploadTooLarge is returned by readUpload for files over the size limit
+var errUploadTooLarge = errors.New("upload too large")
+
+// readUpload returns an uploaded file, sent either as the multipart "file"
+// field or as the raw request body
+func readUpload(c *gin.Context, maxSize int64) ([]byte, error) {
+	if !strings.HasPrefix(c.ContentType(), "multipart/") {
+		return readLimited(c.Request.Body, maxSize)
+	}
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		return nil, fmt.Errorf("file is required")
+	}
+	if file.Size > maxSize {
+		return nil, errUploadTooLarge
+	}
+	f, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readLimited(f, maxSize)
+}
+
+// readLimited reads r whole, failing rather than truncating when it holds
+// more than maxSize bytes
+func readLimited(r io.Reader, maxSize int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, errUploadTooLarge
+	}
+	return data, nil
+}
+
+// cardFilename strips characters that are unsafe in a download filename
+func cardFilename(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < 0x20 {
+			return '_'
+		}
+		return r
+	}, name)
+	if name == "" {
+		return "character"
+	}
+	return name
+}
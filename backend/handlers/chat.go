@@ -1,12 +1,16 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"regexp"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -15,27 +19,37 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/jmoiron/sqlx"
 	"github.com/zucong/rp/config"
+	"github.com/zucong/rp/db"
 	"github.com/zucong/rp/llm"
 	"github.com/zucong/rp/models"
 	"github.com/zucong/rp/services"
 )
 
 type ChatHandler struct {
-	db        *sqlx.DB
-	llmClient *llm.Client
-	cfgStore  *config.Store
+	db       *sqlx.DB
+	cfgStore *config.Store
+	indexer  *services.EmbeddingIndexer
 }
 
-func NewChatHandler(db *sqlx.DB, llmClient *llm.Client, cfgStore *config.Store) *ChatHandler {
+func NewChatHandler(db *sqlx.DB, cfgStore *config.Store, indexer *services.EmbeddingIndexer) *ChatHandler {
 	return &ChatHandler{
-		db:        db,
-		llmClient: llmClient,
-		cfgStore:  cfgStore,
+		db:       db,
+		cfgStore: cfgStore,
+		indexer:  indexer,
 	}
 }
 
+// effectiveModelParams selects a participant's model parameters: its room
+// overrides where set, otherwise those of its character version (cv)
+const effectiveModelParams = `COALESCE(NULLIF(rp.model_override, ''), cv.model_name) as model_name,
+		COALESCE(rp.temperature_override, cv.temperature) as temperature,
+		COALESCE(rp.max_tokens_override, cv.max_tokens) as max_tokens`
+
 type SendMessageRequest struct {
 	Content string `json:"content"`
+	// ParticipantID is the human participant speaking; it may be omitted
+	// in rooms with a single user
+	ParticipantID int64 `json:"participant_id"`
 }
 
 func (h *ChatHandler) SendMessage(c *gin.Context) {
@@ -44,6 +58,10 @@ func (h *ChatHandler) SendMessage(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
 		return
 	}
+	role, ok := requireRoomRole(c, h.db, roomID, models.RoomRolePlayer)
+	if !ok {
+		return
+	}
 
 	var req SendMessageRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -51,34 +69,29 @@ func (h *ChatHandler) SendMessage(c *gin.Context) {
 		return
 	}
 
-	// Get user's participant in this room
-	var userParticipant models.RoomParticipant
-	err = h.db.Get(&userParticipant, `
-		SELECT rp.*, c.name as character_name FROM room_participants rp
-		JOIN characters c ON rp.character_id = c.id
-		WHERE rp.room_id = ? AND rp.is_user = true LIMIT 1`, roomID)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "no user participant found in room"})
+	// Get the speaking user's participant in this room
+	userParticipant, ok := postingParticipant(c, h.db, roomID, req.ParticipantID, role)
+	if !ok {
 		return
 	}
 
 	// Store user message
-	result, err := h.db.Exec(
-		"INSERT INTO messages (room_id, participant_id, content) VALUES (?, ?, ?)",
-		roomID, userParticipant.ID, req.Content)
+	var msgID, seq int64
+	err = h.db.QueryRow(
+		"INSERT INTO messages (room_id, seq, participant_id, content) VALUES (?, "+db.NextMessageSeq+", ?, ?) RETURNING id, seq",
+		roomID, roomID, userParticipant.ID, req.Content).Scan(&msgID, &seq)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	msgID, _ := result.LastInsertId()
-
 	// Broadcast user message to all clients
 	userMessageData := map[string]interface{}{
 		"type": "message",
 		"message": map[string]interface{}{
 			"id":                 msgID,
 			"room_id":            roomID,
+			"seq":                seq,
 			"participant_id":     userParticipant.ID,
 			"participant_name":   userParticipant.CharacterName,
 			"participant_avatar": "",
@@ -91,10 +104,70 @@ func (h *ChatHandler) SendMessage(c *gin.Context) {
 	log.Printf("[Chat] Broadcasting user message: %s", string(userMessageJSON))
 	broadcastToRoom(roomID, string(userMessageJSON))
 
-	// Trigger orchestrator and AI responses in background
-	go h.processAIResponses(roomID, userParticipant.ID, req.Content, msgID)
+	// Queue the orchestrator and AI responses as the room's next turn
+	t := enqueueTurn(roomID, h.roomTurnPolicy(roomID), turnReply, msgID, func(ctx context.Context, t *turn) {
+		h.processAIResponses(ctx, t, roomID, userParticipant.ID, req.Content, msgID)
+	})
 
-	c.JSON(http.StatusOK, gin.H{"status": "message sent"})
+	c.JSON(http.StatusOK, gin.H{"status": "message sent", "turn_id": t.ID})
+}
+
+// speakingParticipant returns the human participant sending a message. With
+// no participantID the room's only user is assumed.
+func speakingParticipant(db *sqlx.DB, roomID, participantID int64) (models.RoomParticipant, error) {
+	var users []models.RoomParticipant
+	err := db.Select(&users, `
+		SELECT rp.*, `+services.ParticipantName+` as character_name FROM room_participants rp
+		JOIN characters c ON rp.character_id = c.id
+		`+services.EffectiveVersionJoin+`
+		WHERE rp.room_id = ? AND rp.is_user = true
+		ORDER BY rp.id ASC`, roomID)
+	if err != nil {
+		return models.RoomParticipant{}, err
+	}
+	if participantID != 0 {
+		for _, u := range users {
+			if u.ID == participantID {
+				return u, nil
+			}
+		}
+		return models.RoomParticipant{}, errors.New("participant is not a user in this room")
+	}
+	switch len(users) {
+	case 0:
+		return models.RoomParticipant{}, errors.New("no user participant found in room")
+	case 1:
+		return users[0], nil
+	}
+	return models.RoomParticipant{}, errors.New("participant_id is required in rooms with several users")
+}
+
+// postingParticipant picks the participant the logged-in user posts as.
+// Without a participantID the user's own participant is preferred. Players
+// may only post as participants assigned to them; owners as any user.
+func postingParticipant(c *gin.Context, db *sqlx.DB, roomID, participantID int64, role string) (models.RoomParticipant, bool) {
+	user := currentUser(c)
+	if participantID == 0 {
+		db.Get(&participantID, `
+			SELECT id FROM room_participants
+			WHERE room_id = ? AND is_user = true AND user_id = ?
+			ORDER BY id ASC LIMIT 1`, roomID, user.ID)
+	}
+	if participantID == 0 && role != models.RoomRoleOwner {
+		c.JSON(http.StatusForbidden, gin.H{"error": "no participant in this room is assigned to you"})
+		return models.RoomParticipant{}, false
+	}
+
+	participant, err := speakingParticipant(db, roomID, participantID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return participant, false
+	}
+	if role != models.RoomRoleOwner && participant.UserID != user.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "you can only post as participants assigned to you"})
+		return participant, false
+	}
+	return participant, true
 }
 
 func min(a, b int) int {
@@ -134,7 +207,10 @@ func isWordInText(text, word string) bool {
 	return false
 }
 
-func (h *ChatHandler) processAIResponses(roomID, userParticipantID int64, userMessage string, userMessageID int64) {
+// processAIResponses runs a turn: it selects the characters that reply to
+// a user message and generates their replies. Once ctx is cancelled no
+// further replies are stored.
+func (h *ChatHandler) processAIResponses(ctx context.Context, t *turn, roomID, userParticipantID int64, userMessage string, userMessageID int64) {
 	log.Printf("[AI] Processing AI responses for room %d", roomID)
 
 	// Initialize decision recorder
@@ -154,9 +230,10 @@ func (h *ChatHandler) processAIResponses(roomID, userParticipantID int64, userMe
 		CharacterPrompt string `db:"character_prompt"`
 	}
 	err := h.db.Select(&aiParticipants, `
-		SELECT rp.*, c.name as character_name, c.prompt as character_prompt
+		SELECT rp.*, `+services.ParticipantName+` as character_name, cv.prompt as character_prompt
 		FROM room_participants rp
 		JOIN characters c ON rp.character_id = c.id
+		`+services.EffectiveVersionJoin+`
 		WHERE rp.room_id = ? AND rp.participant_type = 'ai'`, roomID)
 	if err != nil {
 		log.Printf("[AI] Failed to get AI participants: %v", err)
@@ -216,9 +293,13 @@ func (h *ChatHandler) processAIResponses(roomID, userParticipantID int64, userMe
 		recorder.RecordCharacterSelection(charNames, selectedIDs, []int64{})
 	} else {
 		// 2+ AI participants, use LLM to select
-		selectedIDs = h.selectCharactersWithDecisions(roomID, participants, userMessage, userMessageID, recorder, charNames)
+		selectedIDs = h.selectCharactersWithDecisions(ctx, roomID, participants, userMessage, userMessageID, recorder, charNames)
 		log.Printf("[AI] LLM selected %d characters", len(selectedIDs))
 	}
+	if ctx.Err() != nil {
+		log.Printf("[AI] Turn %d cancelled during selection", t.ID)
+		return
+	}
 
 	// Merge with force include/exclude (again to ensure consistency)
 	finalIDs := mergeSelections(selectedIDs, forceInclude, forceExclude, participants)
@@ -241,16 +322,22 @@ func (h *ChatHandler) processAIResponses(roomID, userParticipantID int64, userMe
 	recorder.RecordCharacterSelection(charNames, finalIDs, excludedIDs)
 
 	// Generate responses in parallel
+	setTurnStage(roomID, t, stageGenerating)
 	var wg sync.WaitGroup
 	for _, pid := range finalIDs {
 		wg.Add(1)
 		go func(participantID int64) {
 			defer wg.Done()
-			h.generateResponse(roomID, participantID, userMessageID, recorder)
+			setGenerating(roomID, t, participantID, true)
+			defer setGenerating(roomID, t, participantID, false)
+			h.generateResponse(ctx, roomID, participantID, userParticipantID, userMessageID, recorder)
 		}(pid)
 	}
 	wg.Wait()
 	log.Printf("[AI] All responses generated")
+
+	// Keep semantic search up to date for rooms that have been indexed
+	h.indexer.Refresh(roomID)
 }
 
 func parseMentions(message string) (include, exclude []string) {
@@ -266,77 +353,41 @@ func parseMentions(message string) (include, exclude []string) {
 	return
 }
 
-func (h *ChatHandler) selectCharactersWithDecisions(roomID int64, participants []models.RoomParticipant, message string, messageID int64, recorder *services.DecisionRecorder, charNames []string) []int64 {
+func (h *ChatHandler) selectCharactersWithDecisions(ctx context.Context, roomID int64, participants []models.RoomParticipant, message string, messageID int64, recorder *services.DecisionRecorder, charNames []string) []int64 {
 	if len(participants) == 0 {
 		return nil
 	}
 
-	// Get fresh config for LLM call
+	// Snapshot the config for this selection's LLM calls
 	cfg, err := h.cfgStore.Get()
 	if err != nil {
 		log.Printf("[Orchestrator] Failed to get config: %v", err)
 		return []int64{participants[0].ID}
 	}
-	h.llmClient.UpdateConfig(cfg)
+	client := llm.NewClient(cfg).WithContext(ctx)
 
-	// Get character details for all participants
-	var participantDetails []struct {
-		ID              int64  `db:"id"`
-		CharacterName   string `db:"character_name"`
-		CharacterPrompt string `db:"character_prompt"`
-	}
-	err = h.db.Select(&participantDetails, `
-		SELECT rp.id, c.name as character_name, c.prompt as character_prompt
-		FROM room_participants rp
-		JOIN characters c ON rp.character_id = c.id
-		WHERE rp.room_id = ? AND rp.participant_type = 'ai'`, roomID)
+	// Gather the room's characters for the orchestrator prompts
+	promptData, err := loadPromptData(h.db, roomID, 0, 0)
 	if err != nil {
-		log.Printf("[Orchestrator] Failed to get participant details: %v", err)
+		log.Printf("[Orchestrator] Failed to load prompt data: %v", err)
 		return []int64{participants[0].ID}
 	}
-
-	// Build character list for LLM
-	var charList strings.Builder
-	for _, p := range participantDetails {
-		charList.WriteString(fmt.Sprintf("- %s (ID: %d)\n", p.CharacterName, p.ID))
-	}
+	promptData.Message = message
 
 	// LLM Intent Analysis: understand who the user is addressing
-	intentPrompt := fmt.Sprintf(`You are analyzing a user's message in a group chat to determine which character(s) they are addressing.
-
-Available characters:
-%s
-
-User message: "%s"
-
-Analyze the user's intent:
-1. Is the user DIRECTLY ADDRESSING a specific character? (e.g., "Alice, how are you?", "What do you think, Bob?")
-2. Is the user asking a QUESTION that implies a specific character should answer?
-3. Is the user speaking to the GROUP in general?
-
-IMPORTANT: Names mentioned as EXAMPLES or REFERENCES (like "you too, like Alice") do NOT mean that character should reply. The user is talking TO someone ABOUT another character.
-
-Reply in this exact format:
-INTENT: direct | question | group
-CHARACTERS: ID1,ID2 (or "none")
-
-Examples:
-- "Alice, how are you?" → INTENT: direct\nCHARACTERS: 3
-- "What do you think?" → INTENT: question\nCHARACTERS: (select 1-2 most relevant)
-- "You are like Alice, aren't you?" → INTENT: question\nCHARACTERS: (current speaker, not Alice)
-- "How is everyone?" → INTENT: group\nCHARACTERS: 3,4`, charList.String(), message)
+	intentPrompt := services.RenderRoomPrompt(h.db, roomID, services.PromptIntent, promptData)
 
 	intentMessages := []llm.Message{
 		{Role: "system", Content: intentPrompt},
 	}
 
 	// Create logged client for intent analysis
-	intentLogger := services.NewLoggedClient(h.llmClient, h.db, &services.LLMCallMetadata{
+	intentLogger := services.NewLoggedClient(client, h.db, &services.LLMCallMetadata{
 		MessageID: messageID,
 		RoomID:    roomID,
 		CallType:  "intent_analysis",
 	})
-	intentResponse, err := intentLogger.Complete(intentMessages, cfg.DefaultModel, 0.1, 100)
+	intentResponse, err := intentLogger.Complete(intentMessages, cfg.DefaultModel, 0.1, 100, models.SamplingParams{})
 	if err != nil {
 		log.Printf("[Orchestrator] Intent analysis failed: %v", err)
 		if recorder != nil {
@@ -380,24 +431,19 @@ Examples:
 	}
 
 	// Fallback: general topic-based selection
-	fallbackPrompt := fmt.Sprintf(`Select 1-2 characters most relevant to reply to: "%s"
-
-Characters:
-%s
-
-Reply with IDs only: 3 or 3,4`, message, charList.String())
+	fallbackPrompt := services.RenderRoomPrompt(h.db, roomID, services.PromptFallback, promptData)
 
 	fallbackMessages := []llm.Message{
 		{Role: "system", Content: fallbackPrompt},
 	}
 
 	// Create logged client for fallback selection
-	fallbackLogger := services.NewLoggedClient(h.llmClient, h.db, &services.LLMCallMetadata{
+	fallbackLogger := services.NewLoggedClient(client, h.db, &services.LLMCallMetadata{
 		MessageID: messageID,
 		RoomID:    roomID,
 		CallType:  "fallback_selection",
 	})
-	response, err := fallbackLogger.Complete(fallbackMessages, cfg.DefaultModel, 0.1, 50)
+	response, err := fallbackLogger.Complete(fallbackMessages, cfg.DefaultModel, 0.1, 50, models.SamplingParams{})
 	if err != nil {
 		log.Printf("[Orchestrator] LLM call failed: %v", err)
 		return []int64{participants[0].ID}
@@ -511,60 +557,17 @@ func mergeSelections(selected []int64, forceInclude, forceExclude []string, part
 	return result
 }
 
-func (h *ChatHandler) generateResponse(roomID, participantID int64, messageID int64, recorder *services.DecisionRecorder) {
+func (h *ChatHandler) generateResponse(ctx context.Context, roomID, participantID, userParticipantID int64, messageID int64, recorder *services.DecisionRecorder) {
 	log.Printf("[AI] Starting response generation for participant %d in room %d", participantID, roomID)
 
-	// Get participant with character details
-	var p struct {
-		models.RoomParticipant
-		CharacterName   string  `db:"character_name"`
-		CharacterAvatar string  `db:"character_avatar"`
-		Prompt          string  `db:"prompt"`
-		ModelName       string  `db:"model_name"`
-		Temperature     float64 `db:"temperature"`
-		MaxTokens       int     `db:"max_tokens"`
-	}
-	err := h.db.Get(&p, `
-		SELECT rp.*, c.name as character_name, c.avatar as character_avatar, c.prompt, c.model_name, c.temperature, c.max_tokens
-		FROM room_participants rp
-		JOIN characters c ON rp.character_id = c.id
-		WHERE rp.id = ?`, participantID)
-	if err != nil {
-		log.Printf("[AI] Failed to get participant: %v", err)
-		return
-	}
-	log.Printf("[AI] Character: %s, Model: %s", p.CharacterName, p.ModelName)
-
-	// Get room info
-	var room models.Room
-	err = h.db.Get(&room, "SELECT id, name, description, setting, created_at, updated_at FROM rooms WHERE id = ?", roomID)
+	p, err := h.buildResponsePrompt(roomID, participantID, userParticipantID, nil)
 	if err != nil {
-		log.Printf("[AI] Failed to get room: %v", err)
+		log.Printf("[AI] Failed to build prompt: %v", err)
 		return
 	}
+	log.Printf("[AI] Character: %s (v%d), Model: %s", p.CharacterName, p.CharacterVersion, p.Model)
 
-	// Get user persona (the human player this AI is responding to)
-	var userPersona string
-	var userChar struct {
-		Name   string `db:"name"`
-		Prompt string `db:"prompt"`
-	}
-	err = h.db.Get(&userChar, `
-		SELECT c.name, c.prompt
-		FROM room_participants rp
-		JOIN characters c ON rp.character_id = c.id
-		WHERE rp.room_id = ? AND rp.participant_type = 'human' AND rp.is_user = true
-		LIMIT 1`, roomID)
-	if err != nil {
-		userPersona = "A user is speaking to you."
-	} else {
-		userPersona = fmt.Sprintf("Name: %s\n%s", userChar.Name, userChar.Prompt)
-	}
-
-	// Build role-aware context - pass current character name
-	contextMessages := h.buildContext(roomID, p.CharacterName)
-
-	// Get fresh config for API call
+	// Snapshot the config for this API call
 	cfg, err := h.cfgStore.Get()
 	if err != nil {
 		log.Printf("[AI] Failed to get config: %v", err)
@@ -573,40 +576,22 @@ func (h *ChatHandler) generateResponse(roomID, participantID int64, messageID in
 		}
 		return
 	}
-	h.llmClient.UpdateConfig(cfg)
-
-	// Build three-section system prompt
-	aiPersona := fmt.Sprintf("You are %s.\n%s", p.CharacterName, p.Prompt)
-	setting := room.Setting
-	if setting == "" {
-		setting = "No specific setting defined."
-	}
-
-	mergedSystem := fmt.Sprintf("[AI Persona]\n%s\n\n[Setting]\n%s\n\n[User Persona]\n%s",
-		aiPersona, setting, userPersona)
-
-	// Extract conversation messages from contextMessages
-	var conversationMessages []contextMessage
-	for _, cm := range contextMessages {
-		if cm.Role != "system" {
-			conversationMessages = append(conversationMessages, cm)
-		}
-	}
-	messages := []llm.Message{
-		{Role: "system", Content: mergedSystem},
-	}
-	for _, cm := range conversationMessages {
-		messages = append(messages, llm.Message{Role: cm.Role, Content: cm.Content})
+	client := llm.NewClient(cfg).WithContext(ctx)
+	log.Printf("[AI] Sending %d messages to LLM", len(p.Messages))
+	if recorder != nil && len(p.AuthorsNotes) > 0 {
+		recorder.RecordAuthorsNotes(p.CharacterID, p.CharacterName, p.AuthorsNotes)
 	}
-	log.Printf("[AI] Sending %d messages to LLM", len(messages))
 
 	// Create logged client for response generation
-	responseLogger := services.NewLoggedClient(h.llmClient, h.db, &services.LLMCallMetadata{
+	responseLogger := services.NewLoggedClient(client, h.db, &services.LLMCallMetadata{
 		MessageID: messageID,
 		RoomID:    roomID,
 		CallType:  "response_generation",
+
+		CharacterID:      p.CharacterID,
+		CharacterVersion: p.CharacterVersion,
 	})
-	response, err := responseLogger.Complete(messages, p.ModelName, p.Temperature, p.MaxTokens)
+	response, err := responseLogger.Complete(p.Messages, p.Model, p.Temperature, p.MaxTokens, p.Sampling)
 	if err != nil {
 		log.Printf("[AI] LLM call failed: %v", err)
 		if recorder != nil {
@@ -615,6 +600,10 @@ func (h *ChatHandler) generateResponse(roomID, participantID int64, messageID in
 		return
 	}
 	log.Printf("[AI] Got response: %s", response[:min(len(response), 50)])
+	if ctx.Err() != nil {
+		log.Printf("[AI] Turn cancelled, discarding response from participant %d", participantID)
+		return
+	}
 
 	// Record successful response generation
 	if recorder != nil {
@@ -622,22 +611,22 @@ func (h *ChatHandler) generateResponse(roomID, participantID int64, messageID in
 	}
 
 	// Store response
-	result, err := h.db.Exec(
-		"INSERT INTO messages (room_id, participant_id, content) VALUES (?, ?, ?)",
-		roomID, participantID, response)
+	var msgID, seq int64
+	err = h.db.QueryRow(
+		"INSERT INTO messages (room_id, seq, participant_id, content) VALUES (?, "+db.NextMessageSeq+", ?, ?) RETURNING id, seq",
+		roomID, roomID, participantID, response).Scan(&msgID, &seq)
 	if err != nil {
 		log.Printf("[AI] Failed to store response: %v", err)
 		return
 	}
 
-	msgID, _ := result.LastInsertId()
-
 	// Broadcast to all connected clients
 	messageData := map[string]interface{}{
 		"type": "message",
 		"message": map[string]interface{}{
 			"id":                 msgID,
 			"room_id":            roomID,
+			"seq":                seq,
 			"participant_id":     participantID,
 			"participant_name":   p.CharacterName,
 			"participant_avatar": p.CharacterAvatar,
@@ -651,12 +640,304 @@ func (h *ChatHandler) generateResponse(roomID, participantID int64, messageID in
 	broadcastToRoom(roomID, string(messageJSON))
 }
 
+// responsePrompt is everything generateResponse sends to the LLM for one
+// character
+type responsePrompt struct {
+	ParticipantID    int64                 `json:"participant_id"`
+	CharacterID      int64                 `json:"character_id"`
+	CharacterName    string                `json:"character_name"`
+	CharacterAvatar  string                `json:"-"`
+	CharacterVersion int                   `json:"character_version"`
+	Model            string                `json:"model"`
+	Temperature      float64               `json:"temperature"`
+	MaxTokens        int                   `json:"max_tokens"`
+	Sampling         models.SamplingParams `json:"sampling"`
+	Messages         []llm.Message         `json:"messages"`
+	// Sections splits Messages into the parts of the prompt, in order
+	Sections []promptSection `json:"sections"`
+	// AuthorsNotes tells where each author's note was inserted
+	AuthorsNotes []services.NoteInsertion `json:"authors_notes"`
+}
+
+// promptSection is a run of consecutive messages in a responsePrompt
+type promptSection struct {
+	Name     string `json:"name"`
+	Messages int    `json:"messages"`
+	Tokens   int    `json:"tokens"`
+}
+
+func (p *responsePrompt) addSection(name string, messages ...llm.Message) {
+	if len(messages) == 0 {
+		return
+	}
+	p.Messages = append(p.Messages, messages...)
+	p.Sections = append(p.Sections, promptSection{
+		Name:     name,
+		Messages: len(messages),
+		Tokens:   llm.EstimateMessageTokens(messages),
+	})
+}
+
+// buildResponsePrompt assembles the request for a participant's reply to
+// the user userParticipantID from the room's current history. pending, if
+// set, is appended after the history as if it had already been posted.
+func (h *ChatHandler) buildResponsePrompt(roomID, participantID, userParticipantID int64, pending *contextMessage) (*responsePrompt, error) {
+	// Get participant with character details
+	var p struct {
+		models.RoomParticipant
+		CharacterName   string  `db:"character_name"`
+		CharacterAvatar string  `db:"character_avatar"`
+		ModelName       string  `db:"model_name"`
+		Temperature     float64 `db:"temperature"`
+		MaxTokens       int     `db:"max_tokens"`
+
+		PostHistoryInstructions string `db:"post_history_instructions"`
+		EffectiveVersion        int    `db:"effective_version"`
+		CharacterPresetID       int64  `db:"character_preset_id"`
+	}
+	err := h.db.Get(&p, `
+		SELECT rp.*, `+services.ParticipantName+` as character_name, cv.avatar as character_avatar,
+			`+effectiveModelParams+`,
+			cv.post_history_instructions,
+			cv.version as effective_version, cv.sampler_preset_id as character_preset_id
+		FROM room_participants rp
+		JOIN characters c ON rp.character_id = c.id
+		`+services.EffectiveVersionJoin+`
+		WHERE rp.id = ? AND rp.room_id = ?`, participantID, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("get participant: %w", err)
+	}
+
+	var room models.Room
+	err = h.db.Get(&room, "SELECT id, name, description, setting, sampler_preset_id, created_at, updated_at FROM rooms WHERE id = ?", roomID)
+	if err != nil {
+		return nil, fmt.Errorf("get room: %w", err)
+	}
+
+	// The room's sampler preset takes precedence over the character's; a
+	// participant's own temperature override beats both
+	sampling := loadSamplingParams(h.db, p.CharacterPresetID).Merge(loadSamplingParams(h.db, room.SamplerPresetID))
+	temperature := p.Temperature
+	if p.TemperatureOverride == nil && sampling.Temperature != nil {
+		temperature = *sampling.Temperature
+	}
+
+	// Gather the variables for the system prompt template
+	promptData, err := loadPromptData(h.db, roomID, participantID, userParticipantID)
+	if err != nil {
+		return nil, fmt.Errorf("load prompt data: %w", err)
+	}
+	userName := promptData.User.Name
+
+	prompt := &responsePrompt{
+		ParticipantID:    participantID,
+		CharacterID:      p.CharacterID,
+		CharacterName:    p.CharacterName,
+		CharacterAvatar:  p.CharacterAvatar,
+		CharacterVersion: p.EffectiveVersion,
+		Model:            p.ModelName,
+		Temperature:      temperature,
+		MaxTokens:        p.MaxTokens,
+		Sampling:         sampling,
+	}
+
+	// Render the system prompt from the room's template
+	mergedSystem := services.RenderRoomPrompt(h.db, roomID, services.PromptSystem, promptData)
+	prompt.addSection("system", llm.Message{Role: "system", Content: mergedSystem})
+
+	// Build role-aware context - pass current character name. Consecutive
+	// messages from the same part of the context form one section.
+	context, notes := h.buildContext(roomID, contextSpeaker{
+		CharacterID: p.CharacterID,
+		Name:        p.CharacterName,
+		UserName:    userName,
+	}, pending)
+	prompt.AuthorsNotes = notes
+	for start := 0; start < len(context); {
+		end := start
+		var run []llm.Message
+		for ; end < len(context) && context[end].Section == context[start].Section; end++ {
+			run = append(run, llm.Message{Role: context[end].Role, Content: context[end].Content})
+		}
+		prompt.addSection(context[start].Section, run...)
+		start = end
+	}
+
+	// Post-history instructions follow the conversation so they carry the
+	// most weight
+	if instructions := expandPlaceholders(p.PostHistoryInstructions, p.CharacterName, userName); instructions != "" {
+		prompt.addSection("post_history_instructions", llm.Message{Role: "system", Content: instructions})
+	}
+	return prompt, nil
+}
+
+// loadPromptData gathers the variables for a room's prompt templates. With a
+// participantID it also fills in that participant's character.
+// userParticipantID is the user being answered; with 0 it is whoever spoke
+// last.
+func loadPromptData(db *sqlx.DB, roomID, participantID, userParticipantID int64) (services.PromptData, error) {
+	var data services.PromptData
+
+	var room models.Room
+	err := db.Get(&room, "SELECT id, name, description, setting FROM rooms WHERE id = ?", roomID)
+	if err != nil {
+		return data, err
+	}
+	data.Room = services.PromptRoom{Name: room.Name, Description: room.Description, Setting: room.Setting}
+
+	// The human players the AI characters are talking to
+	var users []struct {
+		ID     int64  `db:"id"`
+		Name   string `db:"name"`
+		Prompt string `db:"prompt"`
+	}
+	err = db.Select(&users, `
+		SELECT rp.id, `+services.ParticipantName+` as name, cv.prompt
+		FROM room_participants rp
+		JOIN characters c ON rp.character_id = c.id
+		`+services.EffectiveVersionJoin+`
+		WHERE rp.room_id = ? AND rp.participant_type = 'human' AND rp.is_user = true
+		ORDER BY rp.id ASC`, roomID)
+	if err != nil {
+		return data, err
+	}
+	if userParticipantID == 0 && len(users) > 1 {
+		db.Get(&userParticipantID, `
+			SELECT m.participant_id FROM messages m
+			JOIN room_participants rp ON m.participant_id = rp.id
+			WHERE m.room_id = ? AND rp.is_user = true
+			ORDER BY m.seq DESC LIMIT 1`, roomID)
+	}
+	data.User.Name = "User"
+	for _, u := range users {
+		data.Users = append(data.Users, services.PromptUser{
+			Present:  true,
+			Name:     u.Name,
+			Persona:  u.Prompt,
+			Speaking: u.ID == userParticipantID,
+		})
+	}
+	// Without a known speaker the first user is answered
+	speaker := slices.IndexFunc(data.Users, func(u services.PromptUser) bool { return u.Speaking })
+	if speaker < 0 && len(data.Users) > 0 {
+		speaker = 0
+		data.Users[0].Speaking = true
+	}
+	if speaker >= 0 {
+		data.User = data.Users[speaker]
+	}
+
+	var aiParticipants []struct {
+		ID     int64  `db:"id"`
+		Name   string `db:"name"`
+		Prompt string `db:"prompt"`
+	}
+	err = db.Select(&aiParticipants, `
+		SELECT rp.id, `+services.ParticipantName+` as name, cv.prompt
+		FROM room_participants rp
+		JOIN characters c ON rp.character_id = c.id
+		`+services.EffectiveVersionJoin+`
+		WHERE rp.room_id = ? AND rp.participant_type = 'ai'
+		ORDER BY rp.id ASC`, roomID)
+	if err != nil {
+		return data, err
+	}
+	for _, p := range aiParticipants {
+		data.Participants = append(data.Participants, services.PromptParticipant{ID: p.ID, Name: p.Name, Prompt: p.Prompt})
+	}
+
+	err = db.Select(&data.Summaries, "SELECT content FROM summaries WHERE room_id = ? ORDER BY id ASC", roomID)
+	if err != nil {
+		return data, err
+	}
+
+	if participantID == 0 {
+		return data, nil
+	}
+	var char struct {
+		models.Character
+		ExtraPrompt string `db:"extra_prompt"`
+	}
+	err = db.Get(&char, `
+		SELECT `+services.ParticipantName+` as name, cv.prompt, cv.description, cv.personality,
+			cv.scenario, cv.example_dialogues, rp.extra_prompt
+		FROM room_participants rp
+		JOIN characters c ON rp.character_id = c.id
+		`+services.EffectiveVersionJoin+`
+		WHERE rp.id = ?`, participantID)
+	if err != nil {
+		return data, err
+	}
+	name, userName := char.Name, data.User.Name
+	data.Character = services.PromptCharacter{
+		Name:             name,
+		Prompt:           expandPlaceholders(char.Prompt, name, userName),
+		Description:      expandPlaceholders(char.Description, name, userName),
+		Personality:      expandPlaceholders(char.Personality, name, userName),
+		Scenario:         expandPlaceholders(char.Scenario, name, userName),
+		ExampleDialogues: expandPlaceholders(char.ExampleDialogues, name, userName),
+		ExtraPrompt:      expandPlaceholders(char.ExtraPrompt, name, userName),
+		Persona:          buildAIPersona(&char.Character, userName),
+	}
+	if data.Character.ExtraPrompt != "" {
+		data.Character.Persona += "\n\n" + data.Character.ExtraPrompt
+	}
+	return data, nil
+}
+
+// buildAIPersona assembles the [AI Persona] section from the character's
+// structured fields, skipping empty ones
+func buildAIPersona(c *models.Character, userName string) string {
+	sections := []string{fmt.Sprintf("You are %s.", c.Name)}
+	if prompt := expandPlaceholders(c.Prompt, c.Name, userName); prompt != "" {
+		sections[0] += "\n" + prompt
+	}
+	for _, field := range []struct{ title, text string }{
+		{"Description", c.Description},
+		{"Personality", c.Personality},
+		{"Scenario", c.Scenario},
+		{"Example dialogues", c.ExampleDialogues},
+	} {
+		if text := expandPlaceholders(field.text, c.Name, userName); text != "" {
+			sections = append(sections, field.title+":\n"+text)
+		}
+	}
+	return strings.Join(sections, "\n\n")
+}
+
+// expandPlaceholders substitutes the {{char}} / {{user}} macros (and their
+// legacy <BOT> / <USER> forms) used by character cards
+func expandPlaceholders(s, charName, userName string) string {
+	return strings.TrimSpace(strings.NewReplacer(
+		"{{char}}", charName,
+		"{{Char}}", charName,
+		"<BOT>", charName,
+		"{{user}}", userName,
+		"{{User}}", userName,
+		"<USER>", userName,
+	).Replace(s))
+}
+
 type contextMessage struct {
 	Role    string
 	Content string
+	// Section names the part of the prompt the message belongs to:
+	// history, pending_message or authors_note
+	Section string
 }
 
-func (h *ChatHandler) buildContext(roomID int64, characterName string) []contextMessage {
+// contextSpeaker identifies the character a context is built for
+type contextSpeaker struct {
+	CharacterID int64
+	Name        string
+	UserName    string
+}
+
+// buildContext returns the recent conversation as seen by the speaker, with
+// pending appended and the author's notes that apply spliced in
+func (h *ChatHandler) buildContext(roomID int64, speaker contextSpeaker, pending *contextMessage) ([]contextMessage, []services.NoteInsertion) {
+	characterName := speaker.Name
+
 	// Get recent messages with participant type
 	var messages []struct {
 		Name            string `db:"character_name"`
@@ -664,18 +945,19 @@ func (h *ChatHandler) buildContext(roomID int64, characterName string) []context
 		ParticipantType string `db:"participant_type"`
 	}
 	err := h.db.Select(&messages, `
-		SELECT c.name as character_name, m.content, rp.participant_type
+		SELECT `+services.ParticipantName+` as character_name, m.content, rp.participant_type
 		FROM messages m
 		JOIN room_participants rp ON m.participant_id = rp.id
 		JOIN characters c ON rp.character_id = c.id
+		`+services.EffectiveVersionJoin+`
 		WHERE m.room_id = ?
-		ORDER BY m.created_at DESC
+		ORDER BY m.seq DESC
 		LIMIT 20`, roomID)
 	var result []contextMessage
 
 	if err != nil {
 		log.Printf("[Context] Failed to get messages: %v", err)
-		return result
+		return result, nil
 	}
 
 	// Reverse to get chronological order
@@ -693,18 +975,96 @@ func (h *ChatHandler) buildContext(roomID int64, characterName string) []context
 				if strings.HasPrefix(content, prefix) {
 					content = strings.TrimPrefix(content, prefix)
 				}
-				result = append(result, contextMessage{Role: "assistant", Content: content})
+				result = append(result, contextMessage{Role: "assistant", Content: content, Section: "history"})
 			} else {
 				// Other AI character's message - user role with name prefix
-				result = append(result, contextMessage{Role: "user", Content: fmt.Sprintf("%s: %s", m.Name, m.Content)})
+				result = append(result, contextMessage{Role: "user", Content: fmt.Sprintf("%s: %s", m.Name, m.Content), Section: "history"})
 			}
 		} else {
 			// Human user message - user role with name prefix
-			result = append(result, contextMessage{Role: "user", Content: fmt.Sprintf("%s: %s", m.Name, m.Content)})
+			result = append(result, contextMessage{Role: "user", Content: fmt.Sprintf("%s: %s", m.Name, m.Content), Section: "history"})
 		}
 	}
+	if pending != nil {
+		result = append(result, *pending)
+	}
 
-	return result
+	return h.spliceAuthorsNotes(roomID, speaker, result, pending != nil)
+}
+
+// spliceAuthorsNotes inserts the room's and the speaker's author's notes
+// into the conversation, each depth messages from the end. A note with
+// frequency N is only inserted on every Nth user turn.
+func (h *ChatHandler) spliceAuthorsNotes(roomID int64, speaker contextSpeaker, conversation []contextMessage, pendingTurn bool) ([]contextMessage, []services.NoteInsertion) {
+	var notes []models.AuthorsNote
+	err := h.db.Select(&notes, `
+		SELECT * FROM authors_notes
+		WHERE (room_id = ? AND character_id = 0) OR (room_id = 0 AND character_id = ?)
+		ORDER BY character_id ASC`, roomID, speaker.CharacterID)
+	if err != nil {
+		log.Printf("[Context] Failed to get author's notes: %v", err)
+		return conversation, nil
+	}
+	if len(notes) == 0 {
+		return conversation, nil
+	}
+
+	var turn int
+	err = h.db.Get(&turn, `
+		SELECT COUNT(*) FROM messages m
+		JOIN room_participants rp ON m.participant_id = rp.id
+		WHERE m.room_id = ? AND rp.participant_type = 'human'`, roomID)
+	if err != nil {
+		log.Printf("[Context] Failed to count turns: %v", err)
+		return conversation, nil
+	}
+	if pendingTurn {
+		turn++
+	}
+
+	// Work out each note's position in the original conversation; notes at
+	// the same position keep room-before-character order
+	type placement struct {
+		at    int
+		note  models.AuthorsNote
+		index int
+	}
+	var placed []placement
+	insertions := make([]services.NoteInsertion, len(notes))
+	for i, n := range notes {
+		scope := "room"
+		if n.CharacterID != 0 {
+			scope = "character"
+		}
+		insertions[i] = services.NoteInsertion{
+			NoteID: n.ID, Scope: scope, Depth: n.Depth, Role: n.Role,
+			Index: -1, Turn: turn, Frequency: n.Frequency,
+		}
+		if n.Frequency <= 0 || turn%n.Frequency != 0 {
+			continue
+		}
+		placed = append(placed, placement{at: max(len(conversation)-n.Depth, 0), note: n, index: i})
+	}
+	sort.SliceStable(placed, func(a, b int) bool { return placed[a].at < placed[b].at })
+
+	result := make([]contextMessage, 0, len(conversation)+len(placed))
+	next := 0
+	for i := 0; i <= len(conversation); i++ {
+		for next < len(placed) && placed[next].at == i {
+			n := placed[next].note
+			insertions[placed[next].index].Index = len(result)
+			result = append(result, contextMessage{
+				Role:    n.Role,
+				Content: expandPlaceholders(n.Content, speaker.Name, speaker.UserName),
+				Section: "authors_note",
+			})
+			next++
+		}
+		if i < len(conversation) {
+			result = append(result, conversation[i])
+		}
+	}
+	return result, insertions
 }
 
 // SSE events for real-time updates
@@ -730,7 +1090,16 @@ func (h *ChatHandler) Events(c *gin.Context) {
 		c.Status(http.StatusBadRequest)
 		return
 	}
+	if _, ok := requireRoomRole(c, h.db, roomID, models.RoomRoleSpectator); !ok {
+		return
+	}
+	streamRoomEvents(c, roomID, nil)
+}
 
+// streamRoomEvents relays a room's events to the client until it
+// disconnects. When allowed is set it is checked before each event and
+// ends the stream once it reports false.
+func streamRoomEvents(c *gin.Context, roomID int64, allowed func() bool) {
 	c.Header("Content-Type", "text/event-stream")
 	c.Header("Cache-Control", "no-cache")
 	c.Header("Connection", "keep-alive")
@@ -756,7 +1125,7 @@ func (h *ChatHandler) Events(c *gin.Context) {
 	c.Stream(func(w io.Writer) bool {
 		select {
 		case msg, ok := <-ch:
-			if !ok {
+			if !ok || (allowed != nil && !allowed()) {
 				return false
 			}
 			// Write raw SSE data without event type for default onmessage handler
@@ -786,19 +1155,8 @@ func (h *ChatHandler) EditMessage(c *gin.Context) {
 		return
 	}
 
-	// Get message to check if it's from a user (not AI)
-	var msg struct {
-		ParticipantID   int64  `db:"participant_id"`
-		RoomID          int64  `db:"room_id"`
-		ParticipantType string `db:"participant_type"`
-	}
-	err = h.db.Get(&msg, `
-		SELECT m.participant_id, m.room_id, rp.participant_type
-		FROM messages m
-		JOIN room_participants rp ON m.participant_id = rp.id
-		WHERE m.id = ?`, msgID)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+	msg, ok := requireMessageAuthor(c, h.db, msgID)
+	if !ok {
 		return
 	}
 
@@ -820,6 +1178,10 @@ func (h *ChatHandler) EditMessage(c *gin.Context) {
 	editJSON, _ := json.Marshal(editData)
 	broadcastToRoom(msg.RoomID, string(editJSON))
 
+	// Re-embed the edited content
+	h.indexer.ForgetMessage(msg.RoomID, msgID)
+	go h.indexer.Refresh(msg.RoomID)
+
 	c.Status(http.StatusNoContent)
 }
 
@@ -829,21 +1191,55 @@ func (h *ChatHandler) Regenerate(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid room id"})
 		return
 	}
+	role, ok := requireRoomRole(c, h.db, roomID, models.RoomRolePlayer)
+	if !ok {
+		return
+	}
 
 	// Get user's last message in this room
 	var lastUserMsg struct {
 		ID            int64 `db:"id"`
 		ParticipantID int64 `db:"participant_id"`
+		UserID        int64 `db:"user_id"`
 	}
 	err = h.db.Get(&lastUserMsg, `
-		SELECT m.id, m.participant_id FROM messages m
+		SELECT m.id, m.participant_id, COALESCE(rp.user_id, 0) as user_id FROM messages m
 		JOIN room_participants rp ON m.participant_id = rp.id
 		WHERE m.room_id = ? AND rp.is_user = true
-		ORDER BY m.created_at DESC LIMIT 1`, roomID)
+		ORDER BY m.seq DESC LIMIT 1`, roomID)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "no user message found"})
 		return
 	}
+	// Players can only ask for new replies to their own message
+	if role != models.RoomRoleOwner && lastUserMsg.UserID != currentUser(c).ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only room owners can regenerate replies to other players"})
+		return
+	}
+
+	// The replies are deleted when the turn starts, so a turn still
+	// generating them finishes (or is preempted) first
+	t := enqueueTurn(roomID, h.roomTurnPolicy(roomID), turnRegenerate, lastUserMsg.ID, func(ctx context.Context, t *turn) {
+		h.regenerateTurn(ctx, t, roomID, lastUserMsg.ID, lastUserMsg.ParticipantID)
+	})
+
+	c.JSON(http.StatusOK, gin.H{"status": "regenerating", "turn_id": t.ID})
+}
+
+// regenerateTurn deletes the AI replies to a user message and generates
+// new ones. It does nothing if another user message has been posted since
+// the regeneration was requested.
+func (h *ChatHandler) regenerateTurn(ctx context.Context, t *turn, roomID, userMsgID, userParticipantID int64) {
+	var latestUserMsgID int64
+	err := h.db.Get(&latestUserMsgID, `
+		SELECT m.id FROM messages m
+		JOIN room_participants rp ON m.participant_id = rp.id
+		WHERE m.room_id = ? AND rp.is_user = true
+		ORDER BY m.seq DESC LIMIT 1`, roomID)
+	if err != nil || latestUserMsgID != userMsgID {
+		log.Printf("[Regenerate] Message %d is no longer the last user message, skipping", userMsgID)
+		return
+	}
 
 	// Get all AI messages after user's last message
 	var aiMessages []struct {
@@ -852,9 +1248,9 @@ func (h *ChatHandler) Regenerate(c *gin.Context) {
 	err = h.db.Select(&aiMessages, `
 		SELECT m.id FROM messages m
 		JOIN room_participants rp ON m.participant_id = rp.id
-		WHERE m.room_id = ? AND rp.participant_type = 'ai' AND m.created_at > (
-			SELECT created_at FROM messages WHERE id = ?
-		)`, roomID, lastUserMsg.ID)
+		WHERE m.room_id = ? AND rp.participant_type = 'ai' AND m.seq > (
+			SELECT seq FROM messages WHERE id = ?
+		)`, roomID, userMsgID)
 	if err != nil {
 		log.Printf("[Regenerate] Failed to get AI messages: %v", err)
 	}
@@ -866,6 +1262,8 @@ func (h *ChatHandler) Regenerate(c *gin.Context) {
 			log.Printf("[Regenerate] Failed to delete message %d: %v", msg.ID, err)
 			continue
 		}
+		_, _ = h.db.Exec("DELETE FROM message_swipes WHERE message_id = ?", msg.ID)
+		h.indexer.ForgetMessage(roomID, msg.ID)
 		// Broadcast delete event
 		deleteData := map[string]interface{}{
 			"type":       "message_deleted",
@@ -879,15 +1277,12 @@ func (h *ChatHandler) Regenerate(c *gin.Context) {
 
 	// Get user's last message content
 	var userContent string
-	err = h.db.Get(&userContent, "SELECT content FROM messages WHERE id = ?", lastUserMsg.ID)
+	err = h.db.Get(&userContent, "SELECT content FROM messages WHERE id = ?", userMsgID)
 	if err != nil {
 		userContent = ""
 	}
 
-	// Trigger AI responses in background
-	go h.processAIResponses(roomID, lastUserMsg.ParticipantID, userContent, lastUserMsg.ID)
-
-	c.JSON(http.StatusOK, gin.H{"status": "regenerating", "deleted_count": len(aiMessages)})
+	h.processAIResponses(ctx, t, roomID, userParticipantID, userContent, userMsgID)
 }
 
 func (h *ChatHandler) DeleteMessage(c *gin.Context) {
@@ -898,18 +1293,8 @@ func (h *ChatHandler) DeleteMessage(c *gin.Context) {
 	}
 
 	// Get message info before deleting
-	var msg struct {
-		ParticipantID   int64  `db:"participant_id"`
-		RoomID          int64  `db:"room_id"`
-		ParticipantType string `db:"participant_type"`
-	}
-	err = h.db.Get(&msg, `
-		SELECT m.participant_id, m.room_id, rp.participant_type
-		FROM messages m
-		JOIN room_participants rp ON m.participant_id = rp.id
-		WHERE m.id = ?`, msgID)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+	msg, ok := requireMessageAuthor(c, h.db, msgID)
+	if !ok {
 		return
 	}
 
@@ -919,6 +1304,8 @@ func (h *ChatHandler) DeleteMessage(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	_, _ = h.db.Exec("DELETE FROM message_swipes WHERE message_id = ?", msgID)
+	h.indexer.ForgetMessage(msg.RoomID, msgID)
 
 	// Broadcast delete event
 	deleteData := map[string]interface{}{
@@ -937,6 +1324,9 @@ func (h *ChatHandler) GetLLMLogs(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid message id"})
 		return
 	}
+	if _, _, ok := requireMessageRole(c, h.db, msgID, models.RoomRoleOwner); !ok {
+		return
+	}
 
 	logs, err := services.GetLogsForMessage(h.db, msgID)
 	if err != nil {
@@ -953,6 +1343,9 @@ func (h *ChatHandler) GetDecisions(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid message id"})
 		return
 	}
+	if _, _, ok := requireMessageRole(c, h.db, msgID, models.RoomRoleOwner); !ok {
+		return
+	}
 
 	decisions, err := services.GetDecisionsForMessage(h.db, msgID)
 	if err != nil {
@@ -962,3 +1355,24 @@ func (h *ChatHandler) GetDecisions(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"decisions": decisions})
 }
+
+// GetSwipes returns the alternative responses stored for a message
+func (h *ChatHandler) GetSwipes(c *gin.Context) {
+	msgID, err := strconv.ParseInt(c.Param("msgId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid message id"})
+		return
+	}
+	if _, _, ok := requireMessageRole(c, h.db, msgID, models.RoomRoleSpectator); !ok {
+		return
+	}
+
+	swipes := []models.MessageSwipe{}
+	err = h.db.Select(&swipes, "SELECT * FROM message_swipes WHERE message_id = ? ORDER BY swipe_index ASC", msgID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"swipes": swipes})
+}
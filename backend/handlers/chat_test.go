@@ -0,0 +1,262 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/zucong/rp/config"
+	"github.com/zucong/rp/db"
+	"github.com/zucong/rp/llm"
+	"github.com/zucong/rp/models"
+	"github.com/zucong/rp/services"
+)
+
+// llmCall is one request received by fakeLLM
+type llmCall struct {
+	Endpoint string
+	Key      string
+	Model    string
+}
+
+// fakeLLM is an OpenAI-compatible endpoint that records every request. A
+// request for a held model blocks until the hold is released.
+type fakeLLM struct {
+	mu    sync.Mutex
+	calls []llmCall
+	holds map[string]*llmHold
+}
+
+type llmHold struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func newFakeLLM() *fakeLLM {
+	return &fakeLLM{holds: make(map[string]*llmHold)}
+}
+
+// hold makes the first request for model wait until release is closed
+func (f *fakeLLM) hold(model string) *llmHold {
+	h := &llmHold{started: make(chan struct{}), release: make(chan struct{})}
+	f.mu.Lock()
+	f.holds[model] = h
+	f.mu.Unlock()
+	return h
+}
+
+func (f *fakeLLM) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Model    string        `json:"model"`
+		Messages []llm.Message `json:"messages"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	call := llmCall{
+		Endpoint: strings.TrimSuffix(r.URL.Path, "/chat/completions"),
+		Key:      strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "),
+		Model:    body.Model,
+	}
+
+	f.mu.Lock()
+	f.calls = append(f.calls, call)
+	h := f.holds[call.Model]
+	delete(f.holds, call.Model)
+	f.mu.Unlock()
+	if h != nil {
+		close(h.started)
+		<-h.release
+	}
+
+	// Selects nobody, so the orchestrator also makes its fallback call
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"choices": []map[string]interface{}{{
+			"message":       llm.Message{Role: "assistant", Content: "intent: group\ncharacters: none"},
+			"finish_reason": "stop",
+		}},
+	})
+}
+
+func (f *fakeLLM) callsTo(endpoint string) []llmCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var calls []llmCall
+	for _, c := range f.calls {
+		if c.Endpoint == endpoint {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// configTestRoom is a room in its own database, so each room runs with its
+// own saved LLM settings
+type configTestRoom struct {
+	name          string
+	db            *sqlx.DB
+	store         *config.Store
+	handler       *ChatHandler
+	roomID        int64
+	userID        int64
+	aiID          int64
+	messageID     int64
+	participants  []models.RoomParticipant
+	characterName string
+}
+
+func newConfigTestRoom(t *testing.T, llmURL, name string) *configTestRoom {
+	t.Helper()
+	if err := db.Init(filepath.Join(t.TempDir(), "app.db")); err != nil {
+		t.Fatal(err)
+	}
+	r := &configTestRoom{name: name, db: db.DB, store: config.NewStore(db.DB)}
+	t.Cleanup(func() { r.db.Close() })
+	r.handler = NewChatHandler(r.db, r.store, services.NewEmbeddingIndexer(r.db, r.store))
+	r.setConfig(t, llmURL, name)
+
+	exec := func(query string, args ...interface{}) int64 {
+		t.Helper()
+		res, err := r.db.Exec(query, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, _ := res.LastInsertId()
+		return id
+	}
+	character := func(name string) int64 {
+		t.Helper()
+		id := exec("INSERT INTO characters (name, prompt, model_name) VALUES (?, ?, ?)", name, "You are "+name+".", "char-"+r.name)
+		if _, err := services.SnapshotCharacter(r.db, id); err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	r.characterName = "Ai " + name
+	r.roomID = exec("INSERT INTO rooms (name) VALUES (?)", name)
+	r.userID = exec("INSERT INTO room_participants (room_id, character_id, participant_type, is_user) VALUES (?, ?, 'human', true)",
+		r.roomID, character("User "+name))
+	r.aiID = exec("INSERT INTO room_participants (room_id, character_id, participant_type) VALUES (?, ?, 'ai')",
+		r.roomID, character(r.characterName))
+	r.messageID = exec("INSERT INTO messages (room_id, seq, participant_id, content) VALUES (?, "+db.NextMessageSeq+", ?, 'hello')",
+		r.roomID, r.roomID, r.userID)
+	r.participants = []models.RoomParticipant{{ID: r.aiID, RoomID: r.roomID, CharacterName: r.characterName}}
+	return r
+}
+
+// setConfig saves settings that identify the room: the endpoint path, the
+// API key and the default model are all named after label
+func (r *configTestRoom) setConfig(t *testing.T, llmURL, label string) {
+	t.Helper()
+	endpoint, key, model := llmURL+"/"+label, "key-"+label, "model-"+label
+	err := r.store.Update(&config.Overrides{APIEndpoint: &endpoint, APIKey: &key, DefaultModel: &model})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func setupConfigTest(t *testing.T, llmURL string) {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	yaml := fmt.Sprintf("database:\n  path: %s\nllm:\n  api_endpoint: %s/base\n  default_model: model-base\n",
+		filepath.Join(dir, "app.db"), llmURL)
+	if err := os.WriteFile(path, []byte(yaml), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.LoadConfig(path); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(config.SecretKeyEnv, "test key")
+	if err := config.InitSecrets(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Rooms with different settings run their LLM calls concurrently; each call
+// must go out with its own room's settings, and a settings change made
+// while a call is in flight must only apply to calls started after it.
+func TestLLMCallsUseTheirConfigSnapshot(t *testing.T) {
+	fake := newFakeLLM()
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	setupConfigTest(t, srv.URL)
+
+	rooms := make([]*configTestRoom, 4)
+	for i := range rooms {
+		rooms[i] = newConfigTestRoom(t, srv.URL, fmt.Sprintf("room%d", i))
+	}
+	changed := rooms[0]
+
+	// The changed room's intent analysis is held until its settings have
+	// been replaced; its fallback call follows with the same snapshot
+	held := fake.hold("model-" + changed.name)
+
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for _, r := range rooms {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.handler.selectCharactersWithDecisions(ctx, r.roomID, r.participants, "hello", r.messageID, nil, nil)
+		}()
+		if r == changed {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.handler.generateResponse(ctx, r.roomID, r.aiID, r.userID, r.messageID, nil)
+		}()
+	}
+
+	<-held.started
+	changed.setConfig(t, srv.URL, changed.name+"-new")
+	close(held.release)
+	wg.Wait()
+
+	// A reply started after the change uses the new settings
+	changed.handler.generateResponse(ctx, changed.roomID, changed.aiID, changed.userID, changed.messageID, nil)
+
+	for _, r := range rooms {
+		want := map[string]int{"model-" + r.name: 2}
+		if r != changed {
+			want["char-"+r.name] = 1
+		}
+		checkCalls(t, fake.callsTo("/"+r.name), "key-"+r.name, want)
+	}
+	checkCalls(t, fake.callsTo("/"+changed.name+"-new"), "key-"+changed.name+"-new", map[string]int{"char-" + changed.name: 1})
+}
+
+// checkCalls checks that every call used key and that the calls per model
+// match want
+func checkCalls(t *testing.T, calls []llmCall, key string, want map[string]int) {
+	t.Helper()
+	got := make(map[string]int)
+	for _, c := range calls {
+		if c.Key != key {
+			t.Errorf("call to %s for %s used key %q, want %q", c.Endpoint, c.Model, c.Key, key)
+		}
+		got[c.Model]++
+	}
+	if len(got) != len(want) {
+		t.Errorf("calls per model = %v, want %v", got, want)
+		return
+	}
+	for model, n := range want {
+		if got[model] != n {
+			t.Errorf("calls per model = %v, want %v", got, want)
+			return
+		}
+	}
+}
@@ -0,0 +1,514 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"github.com/zucong/rp/db"
+	"github.com/zucong/rp/models"
+	"github.com/zucong/rp/services"
+)
+
+type CheckpointHandler struct {
+	db      *sqlx.DB
+	indexer *services.EmbeddingIndexer
+}
+
+func NewCheckpointHandler(db *sqlx.DB, indexer *services.EmbeddingIndexer) *CheckpointHandler {
+	return &CheckpointHandler{db: db, indexer: indexer}
+}
+
+// checkpointSnapshot is the serialized room state stored with a checkpoint
+type checkpointSnapshot struct {
+	Messages     []snapshotMessage     `json:"messages"`
+	Participants []snapshotParticipant `json:"participants"`
+	Summaries    []models.Summary      `json:"summaries"`
+}
+
+type snapshotMessage struct {
+	ID            int64     `json:"id" db:"id"`
+	ParticipantID int64     `json:"participant_id" db:"participant_id"`
+	Content       string    `json:"content" db:"content"`
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at" db:"updated_at"`
+	Seq           int64     `json:"seq" db:"seq"`
+}
+
+type snapshotParticipant struct {
+	ID              int64  `json:"id" db:"id"`
+	CharacterID     int64  `json:"character_id" db:"character_id"`
+	ParticipantType string `json:"participant_type" db:"participant_type"`
+	IsUser          bool   `json:"is_user" db:"is_user"`
+	// CharacterVersion is the pinned version; 0 follows the latest
+	CharacterVersion    int       `json:"character_version" db:"character_version"`
+	ModelOverride       string    `json:"model_override" db:"model_override"`
+	TemperatureOverride *float64  `json:"temperature_override" db:"temperature_override"`
+	MaxTokensOverride   *int      `json:"max_tokens_override" db:"max_tokens_override"`
+	ExtraPrompt         string    `json:"extra_prompt" db:"extra_prompt"`
+	Nickname            string    `json:"nickname" db:"nickname"`
+	UserID              int64     `json:"user_id" db:"user_id"`
+	CreatedAt           time.Time `json:"created_at" db:"created_at"`
+}
+
+func (h *CheckpointHandler) List(c *gin.Context) {
+	roomID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if _, ok := requireRoomRole(c, h.db, roomID, models.RoomRoleSpectator); !ok {
+		return
+	}
+
+	var checkpoints []models.RoomCheckpoint
+	err = h.db.Select(&checkpoints, `
+		SELECT * FROM room_checkpoints
+		WHERE room_id = ?
+		ORDER BY created_at DESC, id DESC`, roomID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, checkpoints)
+}
+
+func (h *CheckpointHandler) Create(c *gin.Context) {
+	roomID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if _, ok := requireRoomRole(c, h.db, roomID, models.RoomRoleOwner); !ok {
+		return
+	}
+
+	var input struct {
+		Name string `json:"name"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
+
+	var room models.Room
+	err = h.db.Get(&room, "SELECT id, name, description, setting, created_at, updated_at FROM rooms WHERE id = ?", roomID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
+		return
+	}
+
+	var snapshot checkpointSnapshot
+	err = h.db.Select(&snapshot.Messages, `
+		SELECT id, seq, participant_id, content, created_at, updated_at
+		FROM messages
+		WHERE room_id = ?
+		ORDER BY seq ASC`, roomID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	err = h.db.Select(&snapshot.Participants, `
+		SELECT id, character_id, participant_type, is_user, character_version,
+			model_override, temperature_override, max_tokens_override, extra_prompt, nickname, user_id, created_at
+		FROM room_participants
+		WHERE room_id = ?`, roomID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	err = h.db.Select(&snapshot.Summaries, "SELECT * FROM summaries WHERE room_id = ? ORDER BY id ASC", roomID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	snapshotJSON, err := json.Marshal(snapshot)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	checkpoint := models.RoomCheckpoint{
+		RoomID:       roomID,
+		Name:         input.Name,
+		Setting:      room.Setting,
+		MessageCount: len(snapshot.Messages),
+		Snapshot:     string(snapshotJSON),
+		CreatedAt:    time.Now(),
+	}
+	if len(snapshot.Messages) > 0 {
+		checkpoint.LastMessageID = snapshot.Messages[len(snapshot.Messages)-1].ID
+	}
+
+	result, err := h.db.NamedExec(
+		`INSERT INTO room_checkpoints (room_id, name, setting, last_message_id, message_count, snapshot)
+		VALUES (:room_id, :name, :setting, :last_message_id, :message_count, :snapshot)`,
+		&checkpoint,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	id, _ := result.LastInsertId()
+	checkpoint.ID = id
+	c.JSON(http.StatusCreated, checkpoint)
+}
+
+func (h *CheckpointHandler) Delete(c *gin.Context) {
+	roomID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if _, ok := requireRoomRole(c, h.db, roomID, models.RoomRoleOwner); !ok {
+		return
+	}
+	checkpointID, err := strconv.ParseInt(c.Param("cid"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid checkpoint id"})
+		return
+	}
+
+	_, err = h.db.Exec("DELETE FROM room_checkpoints WHERE id = ? AND room_id = ?", checkpointID, roomID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *CheckpointHandler) ListRewinds(c *gin.Context) {
+	roomID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if _, ok := requireRoomRole(c, h.db, roomID, models.RoomRoleSpectator); !ok {
+		return
+	}
+
+	var rewinds []models.RoomRewind
+	err = h.db.Select(&rewinds, `
+		SELECT * FROM room_rewinds
+		WHERE room_id = ?
+		ORDER BY created_at DESC, id DESC`, roomID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, rewinds)
+}
+
+type RewindRequest struct {
+	CheckpointID int64 `json:"checkpoint_id"`
+	MessageID    int64 `json:"message_id"`
+}
+
+// Rewind rolls a room back either to a checkpoint or to just after a message.
+// Removed messages are broadcast as message_deleted events and the full
+// rollback is recorded in room_rewinds.
+func (h *CheckpointHandler) Rewind(c *gin.Context) {
+	roomID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if _, ok := requireRoomRole(c, h.db, roomID, models.RoomRoleOwner); !ok {
+		return
+	}
+
+	var req RewindRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if (req.CheckpointID == 0) == (req.MessageID == 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "exactly one of checkpoint_id or message_id is required"})
+		return
+	}
+
+	// Turns in progress reply to history the rewind replaces
+	cancelTurns(roomID)
+
+	tx, err := h.db.Beginx()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	var removed, restored []snapshotMessage
+	if req.CheckpointID != 0 {
+		removed, restored, err = rewindToCheckpoint(tx, roomID, req.CheckpointID)
+	} else {
+		removed, err = rewindToMessage(tx, roomID, req.MessageID)
+	}
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "checkpoint or message not found in room"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if removed == nil {
+		removed = []snapshotMessage{}
+	}
+	if restored == nil {
+		restored = []snapshotMessage{}
+	}
+	removedJSON, _ := json.Marshal(removed)
+	restoredJSON, _ := json.Marshal(restored)
+	result, err := tx.Exec(`
+		INSERT INTO room_rewinds (room_id, checkpoint_id, message_id, removed_messages, restored_messages)
+		VALUES (?, ?, ?, ?, ?)`,
+		roomID, req.CheckpointID, req.MessageID, string(removedJSON), string(restoredJSON))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	rewindID, _ := result.LastInsertId()
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	log.Printf("[Rewind] Room %d: removed %d messages, restored %d", roomID, len(removed), len(restored))
+
+	// Restored messages are re-embedded with their checkpoint content
+	for _, msg := range append(removed, restored...) {
+		h.indexer.ForgetMessage(roomID, msg.ID)
+	}
+	if len(restored) > 0 {
+		go h.indexer.Refresh(roomID)
+	}
+
+	// Broadcast delete events
+	for _, msg := range removed {
+		deleteData := map[string]interface{}{
+			"type":       "message_deleted",
+			"message_id": msg.ID,
+		}
+		deleteJSON, _ := json.Marshal(deleteData)
+		broadcastToRoom(roomID, string(deleteJSON))
+	}
+
+	// Restored messages and setting changes require clients to reload the room
+	rewindData := map[string]interface{}{
+		"type":           "room_rewound",
+		"rewind_id":      rewindID,
+		"removed_count":  len(removed),
+		"restored_count": len(restored),
+	}
+	rewindJSON, _ := json.Marshal(rewindData)
+	broadcastToRoom(roomID, string(rewindJSON))
+
+	c.JSON(http.StatusOK, gin.H{
+		"rewind_id":         rewindID,
+		"removed_messages":  removed,
+		"restored_messages": restored,
+	})
+}
+
+// rewindToMessage deletes every message in the room that comes after messageID
+func rewindToMessage(tx *sqlx.Tx, roomID, messageID int64) ([]snapshotMessage, error) {
+	var target snapshotMessage
+	err := tx.Get(&target, "SELECT id, seq, participant_id, content, created_at, updated_at FROM messages WHERE id = ? AND room_id = ?", messageID, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []snapshotMessage
+	err = tx.Select(&removed, `
+		SELECT id, seq, participant_id, content, created_at, updated_at
+		FROM messages
+		WHERE room_id = ? AND seq > ?
+		ORDER BY seq ASC`,
+		roomID, target.Seq)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, msg := range removed {
+		if _, err := tx.Exec("DELETE FROM messages WHERE id = ?", msg.ID); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec("DELETE FROM message_swipes WHERE message_id = ?", msg.ID); err != nil {
+			return nil, err
+		}
+	}
+	return removed, nil
+}
+
+// rewindToCheckpoint restores the room setting, participants, messages and
+// summaries captured by a checkpoint. It returns the messages that were
+// removed and the messages that were re-inserted or had their content reverted.
+func rewindToCheckpoint(tx *sqlx.Tx, roomID, checkpointID int64) (removed, restored []snapshotMessage, err error) {
+	var checkpoint models.RoomCheckpoint
+	err = tx.Get(&checkpoint, "SELECT * FROM room_checkpoints WHERE id = ? AND room_id = ?", checkpointID, roomID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var snapshot checkpointSnapshot
+	if err := json.Unmarshal([]byte(checkpoint.Snapshot), &snapshot); err != nil {
+		return nil, nil, err
+	}
+
+	// Restore room setting
+	_, err = tx.Exec("UPDATE rooms SET setting = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", checkpoint.Setting, roomID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Restore participant set, mapping snapshot participant IDs onto current rows
+	keep := make(map[int64]bool)
+	participantMap := make(map[int64]int64)
+	for _, p := range snapshot.Participants {
+		var exists int
+		if err := tx.Get(&exists, "SELECT COUNT(*) FROM characters WHERE id = ?", p.CharacterID); err != nil {
+			return nil, nil, err
+		}
+		if exists == 0 {
+			// Character was deleted since the checkpoint; its messages can't be restored
+			continue
+		}
+		_, err = tx.Exec(`
+			INSERT OR IGNORE INTO room_participants (id, room_id, character_id, participant_type, is_user, character_version,
+				model_override, temperature_override, max_tokens_override, extra_prompt, nickname, user_id, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			p.ID, roomID, p.CharacterID, p.ParticipantType, p.IsUser, p.CharacterVersion,
+			p.ModelOverride, p.TemperatureOverride, p.MaxTokensOverride, p.ExtraPrompt, p.Nickname, p.UserID,
+			db.FormatTime(p.CreatedAt))
+		if err != nil {
+			return nil, nil, err
+		}
+		var currentID int64
+		err = tx.Get(&currentID, "SELECT id FROM room_participants WHERE room_id = ? AND character_id = ?", roomID, p.CharacterID)
+		if err != nil {
+			return nil, nil, err
+		}
+		_, err = tx.Exec(`
+			UPDATE room_participants SET participant_type = ?, is_user = ?, character_version = ?,
+				model_override = ?, temperature_override = ?, max_tokens_override = ?, extra_prompt = ?, nickname = ?, user_id = ?
+			WHERE id = ?`,
+			p.ParticipantType, p.IsUser, p.CharacterVersion,
+			p.ModelOverride, p.TemperatureOverride, p.MaxTokensOverride, p.ExtraPrompt, p.Nickname, p.UserID,
+			currentID)
+		if err != nil {
+			return nil, nil, err
+		}
+		participantMap[p.ID] = currentID
+		keep[currentID] = true
+	}
+
+	// Remove messages that are not part of the checkpoint
+	snapshotMessages := make(map[int64]snapshotMessage)
+	for _, m := range snapshot.Messages {
+		snapshotMessages[m.ID] = m
+	}
+	var current []snapshotMessage
+	err = tx.Select(&current, `
+		SELECT id, seq, participant_id, content, created_at, updated_at
+		FROM messages
+		WHERE room_id = ?
+		ORDER BY seq ASC`, roomID)
+	if err != nil {
+		return nil, nil, err
+	}
+	currentMessages := make(map[int64]snapshotMessage)
+	for _, m := range current {
+		snap, ok := snapshotMessages[m.ID]
+		if !ok || participantMap[snap.ParticipantID] != m.ParticipantID {
+			if _, err := tx.Exec("DELETE FROM messages WHERE id = ?", m.ID); err != nil {
+				return nil, nil, err
+			}
+			if _, err := tx.Exec("DELETE FROM message_swipes WHERE message_id = ?", m.ID); err != nil {
+				return nil, nil, err
+			}
+			removed = append(removed, m)
+			continue
+		}
+		currentMessages[m.ID] = m
+	}
+
+	// Remove participants that joined after the checkpoint
+	var currentParticipants []int64
+	if err := tx.Select(&currentParticipants, "SELECT id FROM room_participants WHERE room_id = ?", roomID); err != nil {
+		return nil, nil, err
+	}
+	for _, pid := range currentParticipants {
+		if keep[pid] {
+			continue
+		}
+		if _, err := tx.Exec("DELETE FROM message_swipes WHERE message_id IN (SELECT id FROM messages WHERE participant_id = ?)", pid); err != nil {
+			return nil, nil, err
+		}
+		if _, err := tx.Exec("DELETE FROM messages WHERE participant_id = ?", pid); err != nil {
+			return nil, nil, err
+		}
+		if _, err := tx.Exec("DELETE FROM room_participants WHERE id = ?", pid); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Re-insert deleted messages with their sequence numbers, which are
+	// never handed out again, and revert edited ones
+	for _, m := range snapshot.Messages {
+		pid, ok := participantMap[m.ParticipantID]
+		if !ok {
+			continue
+		}
+		existing, ok := currentMessages[m.ID]
+		if !ok {
+			_, err = tx.Exec(`
+				INSERT INTO messages (id, room_id, seq, participant_id, content, created_at, updated_at)
+				VALUES (?, ?, ?, ?, ?, ?, ?)`,
+				m.ID, roomID, m.Seq, pid, m.Content, db.FormatTime(m.CreatedAt), db.FormatTime(m.UpdatedAt))
+			if err != nil {
+				return nil, nil, err
+			}
+			restored = append(restored, m)
+			continue
+		}
+		if existing.Content != m.Content {
+			_, err = tx.Exec("UPDATE messages SET content = ?, updated_at = ? WHERE id = ?", m.Content, db.FormatTime(m.UpdatedAt), m.ID)
+			if err != nil {
+				return nil, nil, err
+			}
+			restored = append(restored, m)
+		}
+	}
+
+	// Replace summaries
+	if _, err := tx.Exec("DELETE FROM summaries WHERE room_id = ?", roomID); err != nil {
+		return nil, nil, err
+	}
+	for _, s := range snapshot.Summaries {
+		_, err = tx.Exec(`
+			INSERT INTO summaries (room_id, content, message_from, message_to, created_at)
+			VALUES (?, ?, ?, ?, ?)`,
+			roomID, s.Content, s.MsgFrom, s.MsgTo, db.FormatTime(s.CreatedAt))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return removed, restored, nil
+}
@@ -1,46 +1,108 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jmoiron/sqlx"
+	"github.com/zucong/rp/config"
 	"github.com/zucong/rp/models"
 )
 
 type ConfigHandler struct {
-	db *sqlx.DB
+	db    *sqlx.DB
+	store *config.Store
 }
 
 func NewConfigHandler(db *sqlx.DB) *ConfigHandler {
-	return &ConfigHandler{db: db}
+	return &ConfigHandler{db: db, store: config.NewStore(db)}
 }
 
-func (h *ConfigHandler) Get(c *gin.Context) {
-	var cfg models.Config
-	err := h.db.Get(&cfg, "SELECT api_endpoint, api_key, default_model FROM config WHERE id = 1")
+// ConfigResponse is the effective LLM config as shown to clients. The API
+// key is never sent back, only enough of it to recognise. Overridden lists
+// the fields saved in the database rather than inherited from config.yaml
+// or the environment.
+type ConfigResponse struct {
+	models.Config
+	Overridden []string `json:"overridden"`
+}
+
+func (h *ConfigHandler) respond(c *gin.Context) {
+	overrides, err := h.store.Overrides()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	cfg, err := h.store.Get()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, cfg)
+	cfg.APIKey = config.RedactSecret(cfg.APIKey)
+	c.JSON(http.StatusOK, ConfigResponse{Config: *cfg, Overridden: overrides.Overridden()})
 }
 
+func (h *ConfigHandler) Get(c *gin.Context) {
+	h.respond(c)
+}
+
+// Update replaces the database overrides. Fields left out, null or empty
+// inherit from config.yaml and the environment. The API key is the
+// exception so that saving other settings doesn't require re-sending it:
+// left out, or sent back in its redacted form, the saved key is kept; an
+// empty string clears it.
 func (h *ConfigHandler) Update(c *gin.Context) {
-	var cfg models.Config
-	if err := c.ShouldBindJSON(&cfg); err != nil {
+	var req config.Overrides
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	_, err := h.db.Exec(
-		"UPDATE config SET api_endpoint = ?, api_key = ?, default_model = ? WHERE id = 1",
-		cfg.APIEndpoint, cfg.APIKey, cfg.DefaultModel,
-	)
+	saved, err := h.store.Overrides()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	effective, err := h.store.Get()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	if req.APIKey == nil || *req.APIKey == config.RedactSecret(effective.APIKey) {
+		req.APIKey = saved.APIKey
+	}
+	for _, field := range []**string{&req.APIEndpoint, &req.APIKey, &req.DefaultModel, &req.EmbeddingModel,
+		&req.Provider, &req.APIMode, &req.InstructTemplate} {
+		if *field != nil && strings.TrimSpace(**field) == "" {
+			*field = nil
+		} else if *field != nil {
+			trimmed := strings.TrimSpace(**field)
+			*field = &trimmed
+		}
+	}
+
+	if err := h.store.Update(&req); err != nil {
+		var invalid *config.ValidationError
+		if errors.As(err, &invalid) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
-	c.JSON(http.StatusOK, cfg)
+	h.respond(c)
+}
+
+// Effective lists every setting with the layer it came from: default,
+// yaml, env or db. Secrets are redacted.
+func (h *ConfigHandler) Effective(c *gin.Context) {
+	settings, err := h.store.Effective()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, settings)
 }
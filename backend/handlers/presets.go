@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"github.com/zucong/rp/models"
+)
+
+type PresetHandler struct {
+	db *sqlx.DB
+}
+
+func NewPresetHandler(db *sqlx.DB) *PresetHandler {
+	return &PresetHandler{db: db}
+}
+
+func (h *PresetHandler) List(c *gin.Context) {
+	presets := []models.SamplerPreset{}
+	err := h.db.Select(&presets, "SELECT * FROM sampler_presets ORDER BY name ASC")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, presets)
+}
+
+func (h *PresetHandler) Get(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	var preset models.SamplerPreset
+	err = h.db.Get(&preset, "SELECT * FROM sampler_presets WHERE id = ?", id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "preset not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, preset)
+}
+
+func (h *PresetHandler) Create(c *gin.Context) {
+	var preset models.SamplerPreset
+	if err := c.ShouldBindJSON(&preset); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validatePreset(&preset); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := h.db.NamedExec(
+		`INSERT INTO sampler_presets (name, description, params) VALUES (:name, :description, :params)`,
+		&preset,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	preset.ID, _ = result.LastInsertId()
+	c.JSON(http.StatusCreated, preset)
+}
+
+func (h *PresetHandler) Update(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	var preset models.SamplerPreset
+	if err := c.ShouldBindJSON(&preset); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validatePreset(&preset); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	preset.ID = id
+	_, err = h.db.NamedExec(
+		`UPDATE sampler_presets SET
+			name = :name,
+			description = :description,
+			params = :params,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = :id`,
+		&preset,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, preset)
+}
+
+func (h *PresetHandler) Delete(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	// Check if preset is in use; pinned character versions may still
+	// reference it, so those count as well
+	var count int
+	err = h.db.Get(&count, `
+		SELECT
+			(SELECT COUNT(*) FROM character_versions WHERE sampler_preset_id = ?) +
+			(SELECT COUNT(*) FROM rooms WHERE sampler_preset_id = ?)`, id, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if count > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "preset is used by characters or rooms"})
+		return
+	}
+
+	_, err = h.db.Exec("DELETE FROM sampler_presets WHERE id = ?", id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func validatePreset(preset *models.SamplerPreset) error {
+	preset.Name = strings.TrimSpace(preset.Name)
+	if preset.Name == "" {
+		return errors.New("name is required")
+	}
+	return preset.Params.Validate()
+}
+
+// loadSamplingParams returns the params of a sampler preset, or none when
+// presetID is 0 or the preset no longer exists
+func loadSamplingParams(db *sqlx.DB, presetID int64) models.SamplingParams {
+	if presetID == 0 {
+		return models.SamplingParams{}
+	}
+	var params models.SamplingParams
+	if err := db.Get(&params, "SELECT params FROM sampler_presets WHERE id = ?", presetID); err != nil {
+		return models.SamplingParams{}
+	}
+	return params
+}
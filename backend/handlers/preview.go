@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zucong/rp/llm"
+	"github.com/zucong/rp/models"
+	"github.com/zucong/rp/services"
+)
+
+type PreviewRequest struct {
+	// Content is the user message to preview replies to; empty previews
+	// the next replies to the history as it stands
+	Content string `json:"content"`
+	// Orchestrate runs the orchestrator's character selection, which calls
+	// the LLM. Without it every AI character the mentions don't exclude
+	// is previewed.
+	Orchestrate bool `json:"orchestrate"`
+	// ParticipantID is the user sending Content, as in a chat request
+	ParticipantID int64 `json:"participant_id"`
+}
+
+type previewCharacter struct {
+	*responsePrompt
+	TotalTokens int `json:"total_tokens"`
+	// Prompt is the rendered text sent in text-completion mode
+	Prompt string `json:"prompt,omitempty"`
+}
+
+// Preview shows the requests generateResponse would send for a message
+// without posting it or calling the generation model
+func (h *ChatHandler) Preview(c *gin.Context) {
+	roomID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if _, ok := requireRoomRole(c, h.db, roomID, models.RoomRoleOwner); !ok {
+		return
+	}
+
+	var req PreviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var participants []models.RoomParticipant
+	err = h.db.Select(&participants, `
+		SELECT rp.*, `+services.ParticipantName+` as character_name
+		FROM room_participants rp
+		JOIN characters c ON rp.character_id = c.id
+		`+services.EffectiveVersionJoin+`
+		WHERE rp.room_id = ? AND rp.participant_type = 'ai'
+		ORDER BY rp.id ASC`, roomID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The pending message is shown as the user would post it
+	var pending *contextMessage
+	if req.Content != "" {
+		user, err := speakingParticipant(h.db, roomID, req.ParticipantID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		req.ParticipantID = user.ID
+		pending = &contextMessage{Role: "user", Content: fmt.Sprintf("%s: %s", user.CharacterName, req.Content), Section: "pending_message"}
+	}
+
+	forceInclude, forceExclude := parseMentions(req.Content)
+	selectedIDs := mergeSelections(nil, forceInclude, forceExclude, participants)
+	orchestrated := false
+	if req.Orchestrate && req.Content != "" && len(participants) >= 2 {
+		charNames := make([]string, len(participants))
+		for i, p := range participants {
+			charNames[i] = p.CharacterName
+		}
+		chosen := h.selectCharactersWithDecisions(c.Request.Context(), roomID, participants, req.Content, 0, nil, charNames)
+		selectedIDs = mergeSelections(chosen, forceInclude, forceExclude, participants)
+		orchestrated = true
+	}
+	slices.Sort(selectedIDs)
+
+	cfg, err := h.cfgStore.Get()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	client := llm.NewClient(cfg)
+
+	characters := []previewCharacter{}
+	for _, pid := range selectedIDs {
+		p, err := h.buildResponsePrompt(roomID, pid, req.ParticipantID, pending)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		preview := previewCharacter{responsePrompt: p, TotalTokens: llm.EstimateMessageTokens(p.Messages)}
+		if client.CompletionMode() {
+			prompt, _, err := client.BuildPrompt(p.Messages, nil)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			preview.Prompt = prompt
+		}
+		characters = append(characters, preview)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"force_include": forceInclude,
+		"force_exclude": forceExclude,
+		"orchestrated":  orchestrated,
+		"api_mode":      cfg.APIMode,
+		"characters":    characters,
+	})
+}
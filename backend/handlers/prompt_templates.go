@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"github.com/zucong/rp/models"
+	"github.com/zucong/rp/services"
+)
+
+type PromptTemplateHandler struct {
+	db *sqlx.DB
+}
+
+func NewPromptTemplateHandler(db *sqlx.DB) *PromptTemplateHandler {
+	return &PromptTemplateHandler{db: db}
+}
+
+type promptTemplateResponse struct {
+	Kind    string `json:"kind"`
+	Content string `json:"content"`
+	// Source is where the template comes from: room, global or default
+	Source  string `json:"source"`
+	Default string `json:"default"`
+}
+
+// List returns the global templates along with the documented variables
+func (h *PromptTemplateHandler) List(c *gin.Context) {
+	h.list(c, 0)
+}
+
+// ListRoom returns the templates that apply in a room
+func (h *PromptTemplateHandler) ListRoom(c *gin.Context) {
+	roomID, ok := parsePromptRoomID(c, h.db)
+	if !ok {
+		return
+	}
+	h.list(c, roomID)
+}
+
+func (h *PromptTemplateHandler) list(c *gin.Context, roomID int64) {
+	templates := []promptTemplateResponse{}
+	for _, kind := range services.PromptKinds {
+		content, source, err := services.LoadPromptTemplate(h.db, roomID, kind)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		templates = append(templates, promptTemplateResponse{
+			Kind:    kind,
+			Content: content,
+			Source:  source,
+			Default: services.DefaultPromptTemplates[kind],
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"templates": templates,
+		"variables": services.PromptVariables,
+	})
+}
+
+type promptTemplateRequest struct {
+	Content string `json:"content"`
+}
+
+// Update replaces a global template
+func (h *PromptTemplateHandler) Update(c *gin.Context) {
+	h.update(c, 0)
+}
+
+// UpdateRoom sets a room's own template
+func (h *PromptTemplateHandler) UpdateRoom(c *gin.Context) {
+	roomID, ok := parsePromptRoomID(c, h.db)
+	if !ok {
+		return
+	}
+	h.update(c, roomID)
+}
+
+func (h *PromptTemplateHandler) update(c *gin.Context, roomID int64) {
+	kind, ok := parsePromptKind(c)
+	if !ok {
+		return
+	}
+	var req promptTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := services.ValidatePromptTemplate(kind, req.Content); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid template: " + err.Error()})
+		return
+	}
+
+	_, err := h.db.Exec(`
+		INSERT INTO prompt_templates (room_id, kind, content) VALUES (?, ?, ?)
+		ON CONFLICT(room_id, kind) DO UPDATE SET content = excluded.content, updated_at = CURRENT_TIMESTAMP`,
+		roomID, kind, req.Content)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	source := "global"
+	if roomID != 0 {
+		source = "room"
+	}
+	c.JSON(http.StatusOK, promptTemplateResponse{
+		Kind:    kind,
+		Content: req.Content,
+		Source:  source,
+		Default: services.DefaultPromptTemplates[kind],
+	})
+}
+
+// Delete reverts a global template to the built-in default
+func (h *PromptTemplateHandler) Delete(c *gin.Context) {
+	h.delete(c, 0)
+}
+
+// DeleteRoom removes a room's own template so the global one applies again
+func (h *PromptTemplateHandler) DeleteRoom(c *gin.Context) {
+	roomID, ok := parsePromptRoomID(c, h.db)
+	if !ok {
+		return
+	}
+	h.delete(c, roomID)
+}
+
+func (h *PromptTemplateHandler) delete(c *gin.Context, roomID int64) {
+	kind, ok := parsePromptKind(c)
+	if !ok {
+		return
+	}
+	_, err := h.db.Exec("DELETE FROM prompt_templates WHERE room_id = ? AND kind = ?", roomID, kind)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+type previewPromptRequest struct {
+	Kind string `json:"kind"`
+	// Content is the template to render; empty renders the one in effect
+	Content       string `json:"content"`
+	RoomID        int64  `json:"room_id"`
+	ParticipantID int64  `json:"participant_id"`
+	Message       string `json:"message"`
+}
+
+// Preview renders a template with a room's data, or with sample data when
+// no room is given
+func (h *PromptTemplateHandler) Preview(c *gin.Context) {
+	var req previewPromptRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !slices.Contains(services.PromptKinds, req.Kind) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "kind must be one of " + strings.Join(services.PromptKinds, ", ")})
+		return
+	}
+
+	data := services.SamplePromptData()
+	if req.RoomID != 0 {
+		if _, ok := requireRoomRole(c, h.db, req.RoomID, models.RoomRoleOwner); !ok {
+			return
+		}
+		var err error
+		data, err = loadPromptData(h.db, req.RoomID, req.ParticipantID, 0)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "room or participant not found"})
+			return
+		}
+	}
+	if req.Message != "" {
+		data.Message = req.Message
+	}
+
+	content := req.Content
+	if content == "" {
+		var err error
+		content, _, err = services.LoadPromptTemplate(h.db, req.RoomID, req.Kind)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	rendered, err := services.RenderPromptTemplate(req.Kind, content, data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid template: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"kind": req.Kind, "rendered": rendered})
+}
+
+// parsePromptRoomID parses the room of a room template route; only room
+// owners see and change its templates
+func parsePromptRoomID(c *gin.Context, db *sqlx.DB) (int64, bool) {
+	roomID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid room id"})
+		return 0, false
+	}
+	if _, ok := requireRoomRole(c, db, roomID, models.RoomRoleOwner); !ok {
+		return 0, false
+	}
+	return roomID, true
+}
+
+func parsePromptKind(c *gin.Context) (string, bool) {
+	kind := c.Param("kind")
+	if !slices.Contains(services.PromptKinds, kind) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "kind must be one of " + strings.Join(services.PromptKinds, ", ")})
+		return "", false
+	}
+	return kind, true
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"github.com/zucong/rp/models"
+)
+
+// roomRoleRank orders the room roles; a role grants everything the roles
+// below it do
+var roomRoleRank = map[string]int{
+	models.RoomRoleSpectator: 1,
+	models.RoomRolePlayer:    2,
+	models.RoomRoleOwner:     3,
+}
+
+// roomRole returns a user's role in a room, or "" when the user is not a
+// member. Admins are owners of every room.
+func roomRole(db *sqlx.DB, user *models.User, roomID int64) (string, error) {
+	if user == nil {
+		return "", nil
+	}
+	if user.IsAdmin {
+		return models.RoomRoleOwner, nil
+	}
+	var role string
+	err := db.Get(&role, "SELECT role FROM room_members WHERE room_id = ? AND user_id = ?", roomID, user.ID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return role, err
+}
+
+// requireRoomRole checks that the logged-in user has at least the given role
+// in a room and returns the role they have. Rooms the user is not a member
+// of are reported as not found so their existence isn't revealed.
+func requireRoomRole(c *gin.Context, db *sqlx.DB, roomID int64, min string) (string, bool) {
+	role, err := roomRole(db, currentUser(c), roomID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return "", false
+	}
+	if role == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
+		return "", false
+	}
+	if roomRoleRank[role] < roomRoleRank[min] {
+		c.JSON(http.StatusForbidden, gin.H{"error": "requires the " + min + " role in this room"})
+		return "", false
+	}
+	return role, true
+}
+
+// messageRoom holds what the access checks need to know about a message
+type messageRoom struct {
+	RoomID          int64  `db:"room_id"`
+	ParticipantID   int64  `db:"participant_id"`
+	ParticipantType string `db:"participant_type"`
+	// UserID is the account the message's participant is assigned to
+	UserID int64 `db:"user_id"`
+}
+
+// requireMessageRole looks up a message's room and checks the logged-in
+// user's role there, like requireRoomRole
+func requireMessageRole(c *gin.Context, db *sqlx.DB, msgID int64, min string) (messageRoom, string, bool) {
+	var msg messageRoom
+	err := db.Get(&msg, `
+		SELECT m.room_id, m.participant_id, rp.participant_type, COALESCE(rp.user_id, 0) as user_id
+		FROM messages m
+		JOIN room_participants rp ON m.participant_id = rp.id
+		WHERE m.id = ?`, msgID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+		return msg, "", false
+	}
+	role, ok := requireRoomRole(c, db, msg.RoomID, min)
+	return msg, role, ok
+}
+
+// requireMessageAuthor allows owners to change any message and players to
+// change only the messages of participants assigned to them
+func requireMessageAuthor(c *gin.Context, db *sqlx.DB, msgID int64) (messageRoom, bool) {
+	msg, role, ok := requireMessageRole(c, db, msgID, models.RoomRolePlayer)
+	if !ok {
+		return msg, false
+	}
+	if role != models.RoomRoleOwner && (msg.ParticipantType == "ai" || msg.UserID != currentUser(c).ID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only room owners can change other participants' messages"})
+		return msg, false
+	}
+	return msg, true
+}
+
+// memberRoomsFilter returns a condition limiting a room ID column to the
+// rooms the user belongs to, or "" for admins who see every room
+func memberRoomsFilter(user *models.User, column string) (string, []interface{}) {
+	if user.IsAdmin {
+		return "", nil
+	}
+	return column + " IN (SELECT room_id FROM room_members WHERE user_id = ?)", []interface{}{user.ID}
+}
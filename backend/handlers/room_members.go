@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"github.com/zucong/rp/models"
+)
+
+// addRoomOwner makes the user who created or imported a room its owner
+func addRoomOwner(db *sqlx.DB, roomID int64, user *models.User) error {
+	_, err := db.Exec(`
+		INSERT INTO room_members (room_id, user_id, role) VALUES (?, ?, ?)
+		ON CONFLICT(room_id, user_id) DO UPDATE SET role = excluded.role`,
+		roomID, user.ID, models.RoomRoleOwner)
+	return err
+}
+
+func (h *RoomHandler) ListMembers(c *gin.Context) {
+	roomID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if _, ok := requireRoomRole(c, h.db, roomID, models.RoomRoleSpectator); !ok {
+		return
+	}
+
+	members := []models.RoomMember{}
+	err = h.db.Select(&members, `
+		SELECT rm.id, rm.room_id, rm.user_id, u.username, rm.role, rm.created_at
+		FROM room_members rm
+		JOIN users u ON rm.user_id = u.id
+		WHERE rm.room_id = ?
+		ORDER BY rm.id ASC`, roomID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, members)
+}
+
+type SetMemberRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+// SetMember adds a user to the room or changes their role
+func (h *RoomHandler) SetMember(c *gin.Context) {
+	roomID, userID, ok := h.memberParams(c)
+	if !ok {
+		return
+	}
+
+	var req SetMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, valid := roomRoleRank[req.Role]; !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "role must be owner, player or spectator"})
+		return
+	}
+
+	var exists bool
+	if err := h.db.Get(&exists, "SELECT EXISTS(SELECT 1 FROM users WHERE id = ?)", userID); err != nil || !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	if req.Role != models.RoomRoleOwner && !h.otherOwnerExists(c, roomID, userID) {
+		return
+	}
+
+	_, err := h.db.Exec(`
+		INSERT INTO room_members (room_id, user_id, role) VALUES (?, ?, ?)
+		ON CONFLICT(room_id, user_id) DO UPDATE SET role = excluded.role`,
+		roomID, userID, req.Role)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Spectators can't post, so they keep no participants
+	if req.Role == models.RoomRoleSpectator {
+		_, _ = h.db.Exec("UPDATE room_participants SET user_id = 0 WHERE room_id = ? AND user_id = ?", roomID, userID)
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// RemoveMember takes a user out of the room and unassigns their participants
+func (h *RoomHandler) RemoveMember(c *gin.Context) {
+	roomID, userID, ok := h.memberParams(c)
+	if !ok {
+		return
+	}
+	if !h.otherOwnerExists(c, roomID, userID) {
+		return
+	}
+
+	if _, err := h.db.Exec("DELETE FROM room_members WHERE room_id = ? AND user_id = ?", roomID, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	_, _ = h.db.Exec("UPDATE room_participants SET user_id = 0 WHERE room_id = ? AND user_id = ?", roomID, userID)
+
+	c.Status(http.StatusNoContent)
+}
+
+// memberParams parses a member route and checks the caller owns the room
+func (h *RoomHandler) memberParams(c *gin.Context) (roomID, userID int64, ok bool) {
+	roomID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return 0, 0, false
+	}
+	if _, ok := requireRoomRole(c, h.db, roomID, models.RoomRoleOwner); !ok {
+		return 0, 0, false
+	}
+	userID, err = strconv.ParseInt(c.Param("userId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return 0, 0, false
+	}
+	return roomID, userID, true
+}
+
+// otherOwnerExists guards against a room losing its last owner when a
+// member is demoted or removed; admins can still manage such a room, but
+// nobody else could
+func (h *RoomHandler) otherOwnerExists(c *gin.Context, roomID, userID int64) bool {
+	var role string
+	_ = h.db.Get(&role, "SELECT role FROM room_members WHERE room_id = ? AND user_id = ?", roomID, userID)
+	if role != models.RoomRoleOwner {
+		return true
+	}
+	var count int
+	err := h.db.Get(&count, "SELECT COUNT(*) FROM room_members WHERE room_id = ? AND role = ? AND user_id != ?",
+		roomID, models.RoomRoleOwner, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if count == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot remove the room's last owner"})
+		return false
+	}
+	return true
+}
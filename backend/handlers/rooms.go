@@ -1,40 +1,65 @@
 package handlers
 
 import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jmoiron/sqlx"
+	"github.com/zucong/rp/db"
 	"github.com/zucong/rp/models"
+	"github.com/zucong/rp/services"
 )
 
 type RoomHandler struct {
-	db *sqlx.DB
+	db      *sqlx.DB
+	indexer *services.EmbeddingIndexer
 }
 
-func NewRoomHandler(db *sqlx.DB) *RoomHandler {
-	return &RoomHandler{db: db}
+func NewRoomHandler(db *sqlx.DB, indexer *services.EmbeddingIndexer) *RoomHandler {
+	return &RoomHandler{db: db, indexer: indexer}
 }
 
+// List returns the rooms the user is a member of, or every room for admins,
+// along with the user's role in each
 func (h *RoomHandler) List(c *gin.Context) {
+	user := currentUser(c)
+	where, args := memberRoomsFilter(user, "r.id")
+	if where != "" {
+		where = "WHERE " + where
+	}
 	query := `
-		SELECT r.id, r.name, r.description, r.setting, r.created_at, r.updated_at,
+		SELECT r.id, r.name, r.description, r.setting, r.sampler_preset_id, r.turn_policy, r.created_at, r.updated_at,
 			(SELECT COUNT(*) FROM room_participants WHERE room_id = r.id) as participant_count,
-			(SELECT MAX(created_at) FROM messages WHERE room_id = r.id) as last_activity
+			(SELECT MAX(created_at) FROM messages WHERE room_id = r.id) as last_activity,
+			COALESCE((SELECT role FROM room_members WHERE room_id = r.id AND user_id = ?), '') as role
 		FROM rooms r
+		` + where + `
 		ORDER BY r.created_at DESC
 	`
-	var rooms []struct {
+	rooms := []struct {
 		models.Room
 		ParticipantCount int     `json:"participant_count" db:"participant_count"`
 		LastActivity     *string `json:"last_activity" db:"last_activity"`
-	}
-	err := h.db.Select(&rooms, query)
+		Role             string  `json:"role" db:"role"`
+	}{}
+	err := h.db.Select(&rooms, query, append([]interface{}{user.ID}, args...)...)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	for i := range rooms {
+		if user.IsAdmin {
+			rooms[i].Role = models.RoomRoleOwner
+		}
+	}
 	c.JSON(http.StatusOK, rooms)
 }
 
@@ -44,13 +69,22 @@ func (h *RoomHandler) Get(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
 		return
 	}
+	role, ok := requireRoomRole(c, h.db, id, models.RoomRoleSpectator)
+	if !ok {
+		return
+	}
 
-	var room models.Room
-	err = h.db.Get(&room, "SELECT id, name, description, setting, created_at, updated_at FROM rooms WHERE id = ?", id)
+	var room struct {
+		models.Room
+		// Role is the user's role in the room
+		Role string `json:"role"`
+	}
+	err = h.db.Get(&room, "SELECT id, name, description, setting, sampler_preset_id, turn_policy, created_at, updated_at FROM rooms WHERE id = ?", id)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
 		return
 	}
+	room.Role = role
 
 	c.JSON(http.StatusOK, room)
 }
@@ -66,10 +100,13 @@ func (h *RoomHandler) Create(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
 		return
 	}
+	if !validTurnPolicy(c, &room) {
+		return
+	}
 
 	result, err := h.db.NamedExec(
-		`INSERT INTO rooms (name, description, setting)
-		VALUES (:name, :description, :setting)`,
+		`INSERT INTO rooms (name, description, setting, sampler_preset_id, turn_policy)
+		VALUES (:name, :description, :setting, :sampler_preset_id, :turn_policy)`,
 		&room,
 	)
 	if err != nil {
@@ -79,6 +116,10 @@ func (h *RoomHandler) Create(c *gin.Context) {
 
 	id, _ := result.LastInsertId()
 	room.ID = id
+	if err := addRoomOwner(h.db, id, currentUser(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 	c.JSON(http.StatusCreated, room)
 }
 
@@ -88,6 +129,9 @@ func (h *RoomHandler) Update(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
 		return
 	}
+	if _, ok := requireRoomRole(c, h.db, id, models.RoomRoleOwner); !ok {
+		return
+	}
 
 	var room models.Room
 	if err := c.ShouldBindJSON(&room); err != nil {
@@ -95,12 +139,18 @@ func (h *RoomHandler) Update(c *gin.Context) {
 		return
 	}
 
+	if !validTurnPolicy(c, &room) {
+		return
+	}
+
 	room.ID = id
 	_, err = h.db.NamedExec(
 		`UPDATE rooms SET
 			name = :name,
 			description = :description,
 			setting = :setting,
+			sampler_preset_id = :sampler_preset_id,
+			turn_policy = :turn_policy,
 			updated_at = CURRENT_TIMESTAMP
 		WHERE id = :id`,
 		&room,
@@ -113,18 +163,43 @@ func (h *RoomHandler) Update(c *gin.Context) {
 	c.JSON(http.StatusOK, room)
 }
 
+// validTurnPolicy defaults a room's turn policy to queue and rejects
+// unknown ones
+func validTurnPolicy(c *gin.Context, room *models.Room) bool {
+	if room.TurnPolicy == "" {
+		room.TurnPolicy = models.TurnPolicyQueue
+	}
+	if room.TurnPolicy != models.TurnPolicyQueue && room.TurnPolicy != models.TurnPolicyPreempt {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "turn_policy must be queue or preempt"})
+		return false
+	}
+	return true
+}
+
 func (h *RoomHandler) Delete(c *gin.Context) {
 	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
 		return
 	}
+	if _, ok := requireRoomRole(c, h.db, id, models.RoomRoleOwner); !ok {
+		return
+	}
 
+	cancelTurns(id)
 	_, err = h.db.Exec("DELETE FROM rooms WHERE id = ?", id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	_, _ = h.db.Exec("DELETE FROM room_members WHERE room_id = ?", id)
+	_, _ = h.db.Exec("DELETE FROM share_links WHERE room_id = ?", id)
+	_, _ = h.db.Exec("DELETE FROM room_checkpoints WHERE room_id = ?", id)
+	_, _ = h.db.Exec("DELETE FROM room_rewinds WHERE room_id = ?", id)
+	_, _ = h.db.Exec("DELETE FROM prompt_templates WHERE room_id = ?", id)
+	_, _ = h.db.Exec("DELETE FROM authors_notes WHERE room_id = ?", id)
+	h.indexer.ForgetRoom(id)
+	_, _ = h.db.Exec("DELETE FROM embedding_jobs WHERE room_id = ?", id)
 
 	c.Status(http.StatusNoContent)
 }
@@ -136,23 +211,23 @@ func (h *RoomHandler) ListParticipants(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
 		return
 	}
+	if _, ok := requireRoomRole(c, h.db, roomID, models.RoomRoleSpectator); !ok {
+		return
+	}
 
-	query := `
+	participants := []models.ParticipantDetails{}
+	err = h.db.Select(&participants, `
 		SELECT
-			rp.id,
-			rp.room_id,
-			rp.character_id,
-			c.name as character_name,
-			c.avatar as character_avatar,
-			rp.participant_type,
-			rp.is_user,
-			rp.created_at
+			rp.*,
+			cv.name as character_name,
+			cv.avatar as character_avatar,
+			`+services.ParticipantName+` as display_name,
+			`+effectiveModelParams+`
 		FROM room_participants rp
 		JOIN characters c ON rp.character_id = c.id
+		`+services.EffectiveVersionJoin+`
 		WHERE rp.room_id = ?
-	`
-	var participants []models.RoomParticipant
-	err = h.db.Select(&participants, query, roomID)
+		ORDER BY rp.id ASC`, roomID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -167,37 +242,185 @@ func (h *RoomHandler) AddParticipant(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
 		return
 	}
+	if _, ok := requireRoomRole(c, h.db, roomID, models.RoomRoleOwner); !ok {
+		return
+	}
 
 	var input struct {
 		CharacterID     int64  `json:"character_id"`
 		ParticipantType string `json:"participant_type"`
 		IsUser          bool   `json:"is_user"`
+		// CharacterVersion pins the participant to a version; 0 follows the latest
+		CharacterVersion int `json:"character_version"`
+		// UserID assigns a human participant to an account
+		UserID int64 `json:"user_id"`
 	}
 	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	_, err = h.db.Exec(
-		"INSERT INTO room_participants (room_id, character_id, participant_type, is_user) VALUES (?, ?, ?, ?)",
-		roomID, input.CharacterID, input.ParticipantType, input.IsUser,
+	if input.CharacterVersion > 0 {
+		if _, err := services.GetCharacterVersion(h.db, input.CharacterID, input.CharacterVersion); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "character version not found"})
+			return
+		}
+	}
+	if !h.checkAssignee(c, roomID, input.UserID) {
+		return
+	}
+
+	result, err := h.db.Exec(
+		"INSERT INTO room_participants (room_id, character_id, participant_type, is_user, character_version, user_id) VALUES (?, ?, ?, ?, ?, ?)",
+		roomID, input.CharacterID, input.ParticipantType, input.IsUser, input.CharacterVersion, input.UserID,
 	)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	if input.ParticipantType == "ai" {
+		participantID, _ := result.LastInsertId()
+		h.postGreeting(roomID, participantID)
+	}
+
 	c.Status(http.StatusCreated)
 }
 
-func (h *RoomHandler) RemoveParticipant(c *gin.Context) {
+// postGreeting opens an empty room with the greeting of the character
+// version the participant uses
+func (h *RoomHandler) postGreeting(roomID, participantID int64) {
+	var count int
+	if err := h.db.Get(&count, "SELECT COUNT(*) FROM messages WHERE room_id = ?", roomID); err != nil || count > 0 {
+		return
+	}
+
+	var character struct {
+		Name     string `db:"name"`
+		Avatar   string `db:"avatar"`
+		Greeting string `db:"greeting"`
+	}
+	err := h.db.Get(&character, `
+		SELECT `+services.ParticipantName+` as name, cv.avatar, cv.greeting
+		FROM room_participants rp
+		JOIN characters c ON rp.character_id = c.id
+		`+services.EffectiveVersionJoin+`
+		WHERE rp.id = ?`, participantID)
+	if err != nil || character.Greeting == "" {
+		return
+	}
+
+	userName := "User"
+	h.db.Get(&userName, `
+		SELECT `+services.ParticipantName+` FROM room_participants rp
+		JOIN characters c ON rp.character_id = c.id
+		`+services.EffectiveVersionJoin+`
+		WHERE rp.room_id = ? AND rp.participant_type = 'human' AND rp.is_user = true
+		LIMIT 1`, roomID)
+
+	greeting := expandPlaceholders(character.Greeting, character.Name, userName)
+	var msgID, seq int64
+	err = h.db.QueryRow(
+		"INSERT INTO messages (room_id, seq, participant_id, content) VALUES (?, "+db.NextMessageSeq+", ?, ?) RETURNING id, seq",
+		roomID, roomID, participantID, greeting).Scan(&msgID, &seq)
+	if err != nil {
+		log.Printf("[Room] Failed to post greeting for %s: %v", character.Name, err)
+		return
+	}
+
+	messageJSON, _ := json.Marshal(map[string]interface{}{
+		"type": "message",
+		"message": map[string]interface{}{
+			"id":                 msgID,
+			"room_id":            roomID,
+			"seq":                seq,
+			"participant_id":     participantID,
+			"participant_name":   character.Name,
+			"participant_avatar": character.Avatar,
+			"content":            greeting,
+			"is_ai":              true,
+			"created_at":         time.Now().Format(time.RFC3339),
+		},
+	})
+	broadcastToRoom(roomID, string(messageJSON))
+}
+
+// UpdateParticipant replaces a participant's room settings: its pinned
+// character version (0 follows the latest) and its overrides of the
+// character's model, temperature, max tokens, extra prompt and nickname.
+// The assigned account is only changed when user_id is sent.
+func (h *RoomHandler) UpdateParticipant(c *gin.Context) {
+	roomID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if _, ok := requireRoomRole(c, h.db, roomID, models.RoomRoleOwner); !ok {
+		return
+	}
 	participantID, err := strconv.ParseInt(c.Param("pid"), 10, 64)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid participant id"})
 		return
 	}
 
-	_, err = h.db.Exec("DELETE FROM room_participants WHERE id = ?", participantID)
+	var input struct {
+		CharacterVersion    int      `json:"character_version"`
+		ModelOverride       string   `json:"model_override"`
+		TemperatureOverride *float64 `json:"temperature_override"`
+		MaxTokensOverride   *int     `json:"max_tokens_override"`
+		ExtraPrompt         string   `json:"extra_prompt"`
+		Nickname            string   `json:"nickname"`
+		UserID              *int64   `json:"user_id"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if t := input.TemperatureOverride; t != nil && (*t < 0 || *t > 2) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "temperature_override must be between 0 and 2"})
+		return
+	}
+	if n := input.MaxTokensOverride; n != nil && *n <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "max_tokens_override must be positive"})
+		return
+	}
+
+	var current struct {
+		CharacterID int64 `db:"character_id"`
+		UserID      int64 `db:"user_id"`
+	}
+	err = h.db.Get(&current, "SELECT character_id, COALESCE(user_id, 0) as user_id FROM room_participants WHERE id = ? AND room_id = ?", participantID, roomID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "participant not found"})
+		return
+	}
+	if input.CharacterVersion > 0 {
+		if _, err := services.GetCharacterVersion(h.db, current.CharacterID, input.CharacterVersion); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "character version not found"})
+			return
+		}
+	}
+	userID := current.UserID
+	if input.UserID != nil {
+		userID = *input.UserID
+		if !h.checkAssignee(c, roomID, userID) {
+			return
+		}
+	}
+
+	_, err = h.db.Exec(`
+		UPDATE room_participants SET
+			character_version = ?,
+			model_override = ?,
+			temperature_override = ?,
+			max_tokens_override = ?,
+			extra_prompt = ?,
+			nickname = ?,
+			user_id = ?
+		WHERE id = ?`,
+		input.CharacterVersion, strings.TrimSpace(input.ModelOverride), input.TemperatureOverride,
+		input.MaxTokensOverride, input.ExtraPrompt, strings.TrimSpace(input.Nickname), userID, participantID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -206,37 +429,215 @@ func (h *RoomHandler) RemoveParticipant(c *gin.Context) {
 	c.Status(http.StatusNoContent)
 }
 
-func (h *RoomHandler) ListMessages(c *gin.Context) {
+// checkAssignee checks that a participant can be assigned to an account:
+// the account must be allowed to post in the room. 0 unassigns.
+func (h *RoomHandler) checkAssignee(c *gin.Context, roomID, userID int64) bool {
+	if userID == 0 {
+		return true
+	}
+	var user models.User
+	if err := h.db.Get(&user, "SELECT * FROM users WHERE id = ?", userID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user not found"})
+		return false
+	}
+	role, err := roomRole(h.db, &user, roomID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if roomRoleRank[role] < roomRoleRank[models.RoomRolePlayer] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "participants can only be assigned to players or owners of the room"})
+		return false
+	}
+	return true
+}
+
+func (h *RoomHandler) RemoveParticipant(c *gin.Context) {
 	roomID, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
 		return
 	}
+	if _, ok := requireRoomRole(c, h.db, roomID, models.RoomRoleOwner); !ok {
+		return
+	}
+	participantID, err := strconv.ParseInt(c.Param("pid"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid participant id"})
+		return
+	}
 
-	query := `
+	_, err = h.db.Exec("DELETE FROM room_participants WHERE id = ? AND room_id = ?", participantID, roomID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+const (
+	defaultMessagePageSize = 50
+	maxMessagePageSize     = 200
+)
+
+const messageSelect = `
 		SELECT
 			m.id,
 			m.room_id,
+			m.seq,
 			m.participant_id,
-			c.name as participant_name,
-			c.avatar as participant_avatar,
+			` + services.ParticipantName + ` as participant_name,
+			cv.avatar as participant_avatar,
 			m.content,
 			rp.participant_type = 'ai' as is_ai,
 			m.created_at
 		FROM messages m
 		JOIN room_participants rp ON m.participant_id = rp.id
 		JOIN characters c ON rp.character_id = c.id
-		WHERE m.room_id = ?
-		ORDER BY m.created_at ASC
-	`
+		` + services.EffectiveVersionJoin + `
+`
+
+// MessagePage is a window of room messages plus the cursors needed to fetch
+// the neighbouring windows
+type MessagePage struct {
+	Messages      []models.Message `json:"messages"`
+	HasMoreBefore bool             `json:"has_more_before"`
+	HasMoreAfter  bool             `json:"has_more_after"`
+	BeforeCursor  int64            `json:"before_cursor"`
+	AfterCursor   int64            `json:"after_cursor"`
+}
+
+// ListMessages returns a page of room messages in sequence order. Without a
+// cursor it returns the most recent page. "before" and "after" take a message
+// ID and return the page immediately preceding or following it, and "around"
+// returns a page centred on the given message.
+func (h *RoomHandler) ListMessages(c *gin.Context) {
+	roomID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if _, ok := requireRoomRole(c, h.db, roomID, models.RoomRoleSpectator); !ok {
+		return
+	}
+
+	page, ok := loadMessagePage(c, h.db, roomID)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+// loadMessagePage reads the page of room messages selected by the request's
+// limit and cursor parameters, as described on ListMessages
+func loadMessagePage(c *gin.Context, db *sqlx.DB, roomID int64) (MessagePage, bool) {
+	var err error
+	limit := defaultMessagePageSize
+	if v := c.Query("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return MessagePage{}, false
+		}
+		if limit > maxMessagePageSize {
+			limit = maxMessagePageSize
+		}
+	}
+
+	var cursors [3]int64
+	for i, name := range []string{"before", "after", "around"} {
+		v := c.Query(name)
+		if v == "" {
+			continue
+		}
+		cursors[i], err = strconv.ParseInt(v, 10, 64)
+		if err != nil || cursors[i] <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + name + " cursor"})
+			return MessagePage{}, false
+		}
+	}
+	before, after, around := cursors[0], cursors[1], cursors[2]
+
+	// Cursors are message IDs, but pages are cut by sequence number
+	cursorSeq := func(id int64) (int64, bool) {
+		var seq int64
+		if err := db.Get(&seq, "SELECT seq FROM messages WHERE id = ? AND room_id = ?", id, roomID); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "cursor message not found"})
+			return 0, false
+		}
+		return seq, true
+	}
+
 	var messages []models.Message
-	err = h.db.Select(&messages, query, roomID)
+	switch {
+	case around > 0:
+		seq, ok := cursorSeq(around)
+		if !ok {
+			return MessagePage{}, false
+		}
+		var older, newer []models.Message
+		err = db.Select(&older, messageSelect+`
+			WHERE m.room_id = ? AND m.seq <= ?
+			ORDER BY m.seq DESC
+			LIMIT ?`, roomID, seq, limit/2+1)
+		if err == nil {
+			err = db.Select(&newer, messageSelect+`
+				WHERE m.room_id = ? AND m.seq > ?
+				ORDER BY m.seq ASC
+				LIMIT ?`, roomID, seq, limit-len(older))
+		}
+		reverseMessages(older)
+		messages = append(older, newer...)
+	case after > 0:
+		seq, ok := cursorSeq(after)
+		if !ok {
+			return MessagePage{}, false
+		}
+		err = db.Select(&messages, messageSelect+`
+			WHERE m.room_id = ? AND m.seq > ?
+			ORDER BY m.seq ASC
+			LIMIT ?`, roomID, seq, limit)
+	case before > 0:
+		seq, ok := cursorSeq(before)
+		if !ok {
+			return MessagePage{}, false
+		}
+		err = db.Select(&messages, messageSelect+`
+			WHERE m.room_id = ? AND m.seq < ?
+			ORDER BY m.seq DESC
+			LIMIT ?`, roomID, seq, limit)
+		reverseMessages(messages)
+	default:
+		err = db.Select(&messages, messageSelect+`
+			WHERE m.room_id = ?
+			ORDER BY m.seq DESC
+			LIMIT ?`, roomID, limit)
+		reverseMessages(messages)
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+		return MessagePage{}, false
 	}
 
-	c.JSON(http.StatusOK, messages)
+	page := MessagePage{Messages: messages}
+	if page.Messages == nil {
+		page.Messages = []models.Message{}
+	}
+	if len(messages) > 0 {
+		first, last := messages[0], messages[len(messages)-1]
+		page.BeforeCursor = first.ID
+		page.AfterCursor = last.ID
+		db.Get(&page.HasMoreBefore, "SELECT EXISTS(SELECT 1 FROM messages WHERE room_id = ? AND seq < ?)", roomID, first.Seq)
+		db.Get(&page.HasMoreAfter, "SELECT EXISTS(SELECT 1 FROM messages WHERE room_id = ? AND seq > ?)", roomID, last.Seq)
+	}
+	return page, true
+}
+
+func reverseMessages(messages []models.Message) {
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
 }
 
 func (h *RoomHandler) ResetChat(c *gin.Context) {
@@ -245,12 +646,173 @@ func (h *RoomHandler) ResetChat(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
 		return
 	}
+	if _, ok := requireRoomRole(c, h.db, roomID, models.RoomRoleOwner); !ok {
+		return
+	}
 
+	// Replies being generated would land in the cleared chat
+	cancelTurns(roomID)
+	_, err = h.db.Exec("DELETE FROM message_swipes WHERE message_id IN (SELECT id FROM messages WHERE room_id = ?)", roomID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 	_, err = h.db.Exec("DELETE FROM messages WHERE room_id = ?", roomID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	h.indexer.ForgetRoom(roomID)
 
 	c.Status(http.StatusNoContent)
 }
+
+// Export renders the room's full history as md, html, json or fountain.
+// Pass decisions=true to include orchestrator decision metadata.
+func (h *RoomHandler) Export(c *gin.Context) {
+	roomID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	role, ok := requireRoomRole(c, h.db, roomID, models.RoomRoleSpectator)
+	if !ok {
+		return
+	}
+
+	format := c.DefaultQuery("format", "md")
+	withDecisions := c.Query("decisions") == "true"
+	if withDecisions && role != models.RoomRoleOwner {
+		c.JSON(http.StatusForbidden, gin.H{"error": "requires the owner role in this room"})
+		return
+	}
+
+	transcript, err := services.LoadTranscript(h.db, roomID, withDecisions)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var body []byte
+	var contentType string
+	switch format {
+	case "md":
+		body, contentType = transcript.Markdown(), "text/markdown; charset=utf-8"
+	case "fountain":
+		body, contentType = transcript.Fountain(), "text/plain; charset=utf-8"
+	case "json":
+		body, err = transcript.JSON()
+		contentType = "application/json"
+	case "html":
+		body, err = transcript.HTML()
+		contentType = "text/html; charset=utf-8"
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be md, html, json or fountain"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, cardFilename(transcript.Room.Name), format))
+	c.Data(http.StatusOK, contentType, body)
+}
+
+// maxBundleSize limits uploaded room bundles
+const maxBundleSize = 200 << 20
+
+// ExportBundle downloads the room as a zip bundle for import on another
+// instance. Pass logs=true to include LLM call logs and orchestrator decisions.
+func (h *RoomHandler) ExportBundle(c *gin.Context) {
+	roomID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if _, ok := requireRoomRole(c, h.db, roomID, models.RoomRoleOwner); !ok {
+		return
+	}
+
+	var name string
+	err = h.db.Get(&name, "SELECT name FROM rooms WHERE id = ?", roomID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
+		return
+	}
+
+	bundle, err := services.ExportBundle(h.db, roomID, c.Query("logs") == "true")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.rpbundle.zip"`, cardFilename(name)))
+	c.Data(http.StatusOK, "application/zip", bundle)
+}
+
+// ImportBundle creates a new room from an uploaded bundle
+func (h *RoomHandler) ImportBundle(c *gin.Context) {
+	data, err := readUpload(c, maxBundleSize)
+	if errors.Is(err, errUploadTooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "bundle too large"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := services.ImportBundle(h.db, data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := addRoomOwner(h.db, result.RoomID, currentUser(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, result)
+}
+
+// maxChatImportSize limits uploaded chat exports
+const maxChatImportSize = 50 << 20
+
+// ImportChat creates a new room from a SillyTavern, Agnai or RisuAI chat
+// export. The optional format query parameter skips detection and name
+// overrides the room name.
+func (h *RoomHandler) ImportChat(c *gin.Context) {
+	data, err := readUpload(c, maxChatImportSize)
+	if errors.Is(err, errUploadTooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "chat file too large"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	chat, err := services.ParseChat(data, c.Query("format"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := services.ImportChat(h.db, chat, c.Query("name"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := addRoomOwner(h.db, result.RoomID, currentUser(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, result)
+}
@@ -0,0 +1,381 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"github.com/zucong/rp/config"
+	"github.com/zucong/rp/db"
+	"github.com/zucong/rp/models"
+	"github.com/zucong/rp/services"
+)
+
+type SearchHandler struct {
+	db      *sqlx.DB
+	indexer *services.EmbeddingIndexer
+}
+
+func NewSearchHandler(db *sqlx.DB, indexer *services.EmbeddingIndexer) *SearchHandler {
+	return &SearchHandler{db: db, indexer: indexer}
+}
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+type MessageSearchResult struct {
+	ID              int64     `json:"id" db:"id"`
+	RoomID          int64     `json:"room_id" db:"room_id"`
+	RoomName        string    `json:"room_name" db:"room_name"`
+	ParticipantID   int64     `json:"participant_id" db:"participant_id"`
+	ParticipantName string    `json:"participant_name" db:"participant_name"`
+	IsAI            bool      `json:"is_ai" db:"is_ai"`
+	Snippet         string    `json:"snippet" db:"snippet"`
+	CreatedAt       time.Time `json:"created_at" db:"created_at"`
+}
+
+type CharacterSearchResult struct {
+	ID      int64  `json:"id" db:"id"`
+	Name    string `json:"name" db:"name"`
+	Avatar  string `json:"avatar" db:"avatar"`
+	Snippet string `json:"snippet" db:"snippet"`
+}
+
+type RoomSearchResult struct {
+	ID      int64  `json:"id" db:"id"`
+	Name    string `json:"name" db:"name"`
+	Snippet string `json:"snippet" db:"snippet"`
+}
+
+// Search runs a full-text query over messages, characters and rooms.
+// Messages and rooms are limited to the rooms the user is a member of.
+//
+// Query parameters:
+//   - q: search text (required)
+//   - type: messages, characters or rooms (default: all)
+//   - room_id: only messages from this room
+//   - character_id: only messages spoken by this character
+//   - from, to: message date range (RFC3339 or YYYY-MM-DD)
+//   - is_ai: true for AI messages only, false for human messages only
+//   - limit: maximum results per type
+func (h *SearchHandler) Search(c *gin.Context) {
+	if !db.FTSEnabled {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "full-text search is unavailable; build with -tags sqlite_fts5"})
+		return
+	}
+
+	match := ftsQuery(c.Query("q"))
+	if match == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q is required"})
+		return
+	}
+
+	limit := defaultSearchLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return
+		}
+		limit = min(n, maxSearchLimit)
+	}
+
+	searchType := c.Query("type")
+	if searchType != "" && searchType != "messages" && searchType != "characters" && searchType != "rooms" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "type must be messages, characters or rooms"})
+		return
+	}
+
+	result := gin.H{}
+	user := currentUser(c)
+
+	if searchType == "" || searchType == "messages" {
+		where := []string{"messages_fts MATCH ?"}
+		args := []interface{}{match}
+		if filter, filterArgs := memberRoomsFilter(user, "m.room_id"); filter != "" {
+			where = append(where, filter)
+			args = append(args, filterArgs...)
+		}
+
+		for _, f := range []struct{ param, column string }{
+			{"room_id", "m.room_id"},
+			{"character_id", "rp.character_id"},
+		} {
+			v := c.Query(f.param)
+			if v == "" {
+				continue
+			}
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + f.param})
+				return
+			}
+			where = append(where, f.column+" = ?")
+			args = append(args, id)
+		}
+
+		for _, f := range []struct{ param, op string }{
+			{"from", ">="},
+			{"to", "<="},
+		} {
+			v := c.Query(f.param)
+			if v == "" {
+				continue
+			}
+			t, err := parseSearchDate(v, f.param == "to")
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + f.param + " date"})
+				return
+			}
+			where = append(where, "m.created_at "+f.op+" ?")
+			args = append(args, db.FormatTime(t))
+		}
+
+		if v := c.Query("is_ai"); v != "" {
+			isAI, err := strconv.ParseBool(v)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid is_ai"})
+				return
+			}
+			if isAI {
+				where = append(where, "rp.participant_type = 'ai'")
+			} else {
+				where = append(where, "rp.participant_type != 'ai'")
+			}
+		}
+
+		args = append(args, limit)
+		messages := []MessageSearchResult{}
+		err := h.db.Select(&messages, `
+			SELECT
+				m.id,
+				m.room_id,
+				r.name as room_name,
+				m.participant_id,
+				`+services.ParticipantName+` as participant_name,
+				rp.participant_type = 'ai' as is_ai,
+				snippet(messages_fts, 0, '<mark>', '</mark>', '…', 16) as snippet,
+				m.created_at
+			FROM messages_fts
+			JOIN messages m ON m.id = messages_fts.rowid
+			JOIN rooms r ON m.room_id = r.id
+			JOIN room_participants rp ON m.participant_id = rp.id
+			JOIN characters c ON rp.character_id = c.id
+			`+services.EffectiveVersionJoin+`
+			WHERE `+strings.Join(where, " AND ")+`
+			ORDER BY rank
+			LIMIT ?`, args...)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		result["messages"] = messages
+	}
+
+	if searchType == "" || searchType == "characters" {
+		characters := []CharacterSearchResult{}
+		err := h.db.Select(&characters, `
+			SELECT
+				c.id,
+				c.name,
+				c.avatar,
+				snippet(characters_fts, -1, '<mark>', '</mark>', '…', 16) as snippet
+			FROM characters_fts
+			JOIN characters c ON c.id = characters_fts.rowid
+			WHERE characters_fts MATCH ?
+			ORDER BY rank
+			LIMIT ?`, match, limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		result["characters"] = characters
+	}
+
+	if searchType == "" || searchType == "rooms" {
+		where := "rooms_fts MATCH ?"
+		args := []interface{}{match}
+		if filter, filterArgs := memberRoomsFilter(user, "r.id"); filter != "" {
+			where += " AND " + filter
+			args = append(args, filterArgs...)
+		}
+		rooms := []RoomSearchResult{}
+		err := h.db.Select(&rooms, `
+			SELECT
+				r.id,
+				r.name,
+				snippet(rooms_fts, -1, '<mark>', '</mark>', '…', 16) as snippet
+			FROM rooms_fts
+			JOIN rooms r ON r.id = rooms_fts.rowid
+			WHERE `+where+`
+			ORDER BY rank
+			LIMIT ?`, append(args, limit)...)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		result["rooms"] = rooms
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+type SemanticSearchResult struct {
+	models.Message
+	Score float64 `json:"score"`
+	Chunk string  `json:"chunk"`
+}
+
+// SemanticSearch ranks the room's messages by embedding similarity to q
+func (h *SearchHandler) SemanticSearch(c *gin.Context) {
+	roomID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if _, ok := requireRoomRole(c, h.db, roomID, models.RoomRoleSpectator); !ok {
+		return
+	}
+
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q is required"})
+		return
+	}
+
+	limit := defaultSearchLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return
+		}
+		limit = min(n, maxSearchLimit)
+	}
+
+	matches, err := h.indexer.Search(roomID, query, limit)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	results := []SemanticSearchResult{}
+	if len(matches) > 0 {
+		ids := make([]int64, len(matches))
+		for i, m := range matches {
+			ids[i] = m.MessageID
+		}
+		q, args, err := sqlx.In(messageSelect+"WHERE m.id IN (?)", ids)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		var messages []models.Message
+		if err := h.db.Select(&messages, h.db.Rebind(q), args...); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		byID := make(map[int64]models.Message, len(messages))
+		for _, m := range messages {
+			byID[m.ID] = m
+		}
+		for _, m := range matches {
+			if msg, ok := byID[m.MessageID]; ok {
+				results = append(results, SemanticSearchResult{Message: msg, Score: m.Score, Chunk: m.Chunk})
+			}
+		}
+	}
+
+	var unindexed int
+	if cfg, err := config.NewStore(h.db).Get(); err == nil {
+		h.db.Get(&unindexed, `
+			SELECT COUNT(*) FROM messages m
+			WHERE m.room_id = ? AND NOT EXISTS (
+				SELECT 1 FROM message_embeddings e
+				WHERE e.message_id = m.id AND e.model = ?
+			)`, roomID, cfg.EmbeddingModel)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"results": results, "unindexed_count": unindexed})
+}
+
+// StartEmbeddingBackfill starts (or reports the already running) background
+// job that embeds the room's messages
+func (h *SearchHandler) StartEmbeddingBackfill(c *gin.Context) {
+	roomID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if _, ok := requireRoomRole(c, h.db, roomID, models.RoomRoleOwner); !ok {
+		return
+	}
+
+	job, err := h.indexer.Start(roomID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, job)
+}
+
+func (h *SearchHandler) GetEmbeddingBackfill(c *gin.Context) {
+	roomID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if _, ok := requireRoomRole(c, h.db, roomID, models.RoomRoleSpectator); !ok {
+		return
+	}
+
+	job, err := h.indexer.GetJob(roomID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no embedding job for room"})
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// ftsQuery turns free text into an FTS5 query that matches all terms.
+// Each term is quoted so punctuation can't be parsed as FTS5 syntax; a
+// trailing * is kept as a prefix match.
+func ftsQuery(q string) string {
+	var terms []string
+	for _, term := range strings.Fields(q) {
+		prefix := strings.HasSuffix(term, "*")
+		term = strings.TrimRight(term, "*")
+		if term == "" {
+			continue
+		}
+		quoted := `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+		if prefix {
+			quoted += "*"
+		}
+		terms = append(terms, quoted)
+	}
+	return strings.Join(terms, " ")
+}
+
+// parseSearchDate accepts RFC3339 timestamps or plain dates. A plain date used
+// as an upper bound covers the whole day.
+func parseSearchDate(v string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		return t, err
+	}
+	if endOfDay {
+		t = t.Add(24*time.Hour - time.Second)
+	}
+	return t, nil
+}
@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"github.com/zucong/rp/db"
+	"github.com/zucong/rp/models"
+	"github.com/zucong/rp/services"
+)
+
+// Share links let people without an account read a room. The public routes
+// under /share/:token only ever return the room's description, participants
+// and messages; LLM logs, orchestrator decisions and the config are never
+// reachable through them.
+
+type ShareHandler struct {
+	db *sqlx.DB
+}
+
+func NewShareHandler(db *sqlx.DB) *ShareHandler {
+	return &ShareHandler{db: db}
+}
+
+// List returns the room's share links, expired ones included
+func (h *ShareHandler) List(c *gin.Context) {
+	roomID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if _, ok := requireRoomRole(c, h.db, roomID, models.RoomRoleOwner); !ok {
+		return
+	}
+
+	links := []models.ShareLink{}
+	if err := h.db.Select(&links, "SELECT * FROM share_links WHERE room_id = ? ORDER BY id ASC", roomID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, links)
+}
+
+type CreateShareRequest struct {
+	// ExpiresInHours is how long the link works; 0 never expires
+	ExpiresInHours int  `json:"expires_in_hours"`
+	Live           bool `json:"live"`
+}
+
+func (h *ShareHandler) Create(c *gin.Context) {
+	roomID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if _, ok := requireRoomRole(c, h.db, roomID, models.RoomRoleOwner); !ok {
+		return
+	}
+
+	var req CreateShareRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.ExpiresInHours < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_in_hours must not be negative"})
+		return
+	}
+
+	token, err := services.RandomToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var expiresAt interface{}
+	if req.ExpiresInHours > 0 {
+		expiresAt = db.FormatTime(time.Now().Add(time.Duration(req.ExpiresInHours) * time.Hour))
+	}
+
+	result, err := h.db.Exec(
+		"INSERT INTO share_links (room_id, token, live, expires_at, created_by) VALUES (?, ?, ?, ?, ?)",
+		roomID, token, req.Live, expiresAt, currentUser(c).ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	id, _ := result.LastInsertId()
+
+	var link models.ShareLink
+	if err := h.db.Get(&link, "SELECT * FROM share_links WHERE id = ?", id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, link)
+}
+
+// Revoke deletes a share link; anyone holding it loses access, including
+// open live feeds at their next event
+func (h *ShareHandler) Revoke(c *gin.Context) {
+	roomID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if _, ok := requireRoomRole(c, h.db, roomID, models.RoomRoleOwner); !ok {
+		return
+	}
+	shareID, err := strconv.ParseInt(c.Param("sid"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid share id"})
+		return
+	}
+
+	if _, err := h.db.Exec("DELETE FROM share_links WHERE id = ? AND room_id = ?", shareID, roomID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// activeLink returns the share link for a token if it hasn't expired or
+// been revoked
+func (h *ShareHandler) activeLink(token string) (*models.ShareLink, error) {
+	var link models.ShareLink
+	err := h.db.Get(&link, `
+		SELECT * FROM share_links
+		WHERE token = ? AND (expires_at IS NULL OR expires_at > ?)`,
+		token, db.FormatTime(time.Now()))
+	if err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+func (h *ShareHandler) loadLink(c *gin.Context) (*models.ShareLink, bool) {
+	link, err := h.activeLink(c.Param("token"))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "share link not found or expired"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return link, true
+}
+
+type sharedRoom struct {
+	Name        string `json:"name" db:"name"`
+	Description string `json:"description" db:"description"`
+	Setting     string `json:"setting" db:"setting"`
+}
+
+// Transcript returns a page of the shared room's messages, paginated like
+// ListMessages. format=html renders the page for reading in a browser.
+func (h *ShareHandler) Transcript(c *gin.Context) {
+	link, ok := h.loadLink(c)
+	if !ok {
+		return
+	}
+
+	var room sharedRoom
+	if err := h.db.Get(&room, "SELECT name, description, setting FROM rooms WHERE id = ?", link.RoomID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
+		return
+	}
+	participants, err := services.LoadTranscriptParticipants(h.db, link.RoomID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	page, ok := loadMessagePage(c, h.db, link.RoomID)
+	if !ok {
+		return
+	}
+
+	if c.Query("format") == "html" {
+		h.renderHTML(c, room, participants, page)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"room":            room,
+		"participants":    participants,
+		"live":            link.Live,
+		"expires_at":      link.ExpiresAt,
+		"messages":        page.Messages,
+		"has_more_before": page.HasMoreBefore,
+		"has_more_after":  page.HasMoreAfter,
+		"before_cursor":   page.BeforeCursor,
+		"after_cursor":    page.AfterCursor,
+	})
+}
+
+func (h *ShareHandler) renderHTML(c *gin.Context, room sharedRoom, participants []services.TranscriptParticipant, page MessagePage) {
+	t := &services.Transcript{
+		Room:         models.Room{Name: room.Name, Description: room.Description, Setting: room.Setting},
+		Participants: participants,
+		Messages:     make([]services.TranscriptMessage, len(page.Messages)),
+		ExportedAt:   time.Now(),
+	}
+	for i, m := range page.Messages {
+		t.Messages[i] = services.TranscriptMessage{
+			ID:            m.ID,
+			ParticipantID: m.ParticipantID,
+			Speaker:       m.ParticipantName,
+			IsAI:          m.IsAI,
+			Content:       m.Content,
+			CreatedAt:     m.CreatedAt,
+		}
+	}
+
+	pageURL := func(cursor string, id int64) string {
+		q := url.Values{"format": {"html"}, cursor: {fmt.Sprint(id)}}
+		if limit := c.Query("limit"); limit != "" {
+			q.Set("limit", limit)
+		}
+		return "?" + q.Encode()
+	}
+	var earlier, later string
+	if page.HasMoreBefore {
+		earlier = pageURL("before", page.BeforeCursor)
+	}
+	if page.HasMoreAfter {
+		later = pageURL("after", page.AfterCursor)
+	}
+
+	body, err := t.HTMLPage(earlier, later)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", body)
+}
+
+// Events streams the shared room's new messages, edits and deletions when
+// the link allows it. The stream ends once the link expires or is revoked.
+func (h *ShareHandler) Events(c *gin.Context) {
+	link, ok := h.loadLink(c)
+	if !ok {
+		return
+	}
+	if !link.Live {
+		c.JSON(http.StatusForbidden, gin.H{"error": "this share link has no live feed"})
+		return
+	}
+
+	if link.ExpiresAt != nil {
+		ctx, cancel := context.WithDeadline(c.Request.Context(), *link.ExpiresAt)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+	}
+	streamRoomEvents(c, link.RoomID, func() bool {
+		_, err := h.activeLink(link.Token)
+		return err == nil
+	})
+}
@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zucong/rp/models"
+)
+
+// Each room processes one turn at a time: a turn is the orchestrator's
+// selection and the AI replies to one user message, or a regeneration of
+// them. Turns wait in a per-room queue with a single worker goroutine, so
+// replies to different messages never interleave and a regeneration can't
+// delete messages another turn is still writing. The room's turn policy
+// decides whether a new user message queues behind the current turn or
+// preempts it.
+
+// Turn kinds
+const (
+	turnReply      = "reply"
+	turnRegenerate = "regenerate"
+)
+
+// Turn stages, reported in the room status
+const (
+	stageQueued     = "queued"
+	stageSelecting  = "selecting"
+	stageGenerating = "generating"
+)
+
+type turn struct {
+	ID            int64      `json:"id"`
+	Kind          string     `json:"kind"`
+	UserMessageID int64      `json:"user_message_id"`
+	Stage         string     `json:"stage"`
+	QueuedAt      time.Time  `json:"queued_at"`
+	StartedAt     *time.Time `json:"started_at,omitempty"`
+	// Generating lists the AI participants whose replies are in progress
+	Generating []int64 `json:"generating"`
+
+	run func(ctx context.Context, t *turn)
+	// done is closed once run has returned
+	done chan struct{}
+}
+
+type roomTurns struct {
+	current *turn
+	cancel  context.CancelFunc
+	queue   []*turn
+}
+
+// RoomStatus is a room's turn processing state
+type RoomStatus struct {
+	RoomID int64  `json:"room_id"`
+	Policy string `json:"policy,omitempty"`
+	// State is idle or processing
+	State   string `json:"state"`
+	Current *turn  `json:"current"`
+	Queued  []turn `json:"queued"`
+}
+
+var (
+	turnsMu    sync.Mutex
+	roomQueues = make(map[int64]*roomTurns)
+	lastTurnID int64
+)
+
+// enqueueTurn adds a turn to the room's queue under the given policy and
+// starts the room's worker if it is idle. With the preempt policy the
+// current turn is cancelled and queued turns are dropped first.
+func enqueueTurn(roomID int64, policy, kind string, userMessageID int64, run func(ctx context.Context, t *turn)) *turn {
+	turnsMu.Lock()
+	lastTurnID++
+	t := &turn{
+		ID:            lastTurnID,
+		Kind:          kind,
+		UserMessageID: userMessageID,
+		Stage:         stageQueued,
+		QueuedAt:      time.Now(),
+		Generating:    []int64{},
+		run:           run,
+		done:          make(chan struct{}),
+	}
+
+	rt, active := roomQueues[roomID]
+	if !active {
+		rt = &roomTurns{}
+		roomQueues[roomID] = rt
+	}
+	if policy == models.TurnPolicyPreempt {
+		if rt.cancel != nil {
+			log.Printf("[Turns] Room %d: turn %d preempted by turn %d", roomID, rt.current.ID, t.ID)
+			rt.cancel()
+		}
+		rt.queue = nil
+	}
+	rt.queue = append(rt.queue, t)
+	turnsMu.Unlock()
+
+	if !active {
+		go runTurns(roomID)
+	}
+	broadcastRoomStatus(roomID)
+	return t
+}
+
+// runTurns is a room's worker: it runs queued turns one by one and exits
+// once the queue is empty
+func runTurns(roomID int64) {
+	for {
+		turnsMu.Lock()
+		rt := roomQueues[roomID]
+		if len(rt.queue) == 0 {
+			delete(roomQueues, roomID)
+			turnsMu.Unlock()
+			broadcastRoomStatus(roomID)
+			return
+		}
+		t := rt.queue[0]
+		rt.queue = rt.queue[1:]
+		ctx, cancel := context.WithCancel(context.Background())
+		now := time.Now()
+		t.StartedAt = &now
+		t.Stage = stageSelecting
+		rt.current, rt.cancel = t, cancel
+		turnsMu.Unlock()
+		broadcastRoomStatus(roomID)
+
+		log.Printf("[Turns] Room %d: starting %s turn %d", roomID, t.Kind, t.ID)
+		t.run(ctx, t)
+		cancel()
+		close(t.done)
+
+		turnsMu.Lock()
+		rt.current, rt.cancel = nil, nil
+		turnsMu.Unlock()
+	}
+}
+
+// cancelTurns stops the room's current turn and drops the queued ones, for
+// changes such as a reset or rewind that invalidate them. It returns once
+// the current turn has finished, so the caller's changes can't be followed
+// by a reply the turn was about to store.
+func cancelTurns(roomID int64) {
+	var done chan struct{}
+	turnsMu.Lock()
+	rt, ok := roomQueues[roomID]
+	if ok {
+		rt.queue = nil
+		if rt.cancel != nil {
+			rt.cancel()
+			done = rt.current.done
+		}
+	}
+	turnsMu.Unlock()
+	if ok {
+		broadcastRoomStatus(roomID)
+	}
+	if done != nil {
+		<-done
+	}
+}
+
+// updateTurn changes a running turn's progress and broadcasts the new status
+func updateTurn(roomID int64, t *turn, change func(t *turn)) {
+	turnsMu.Lock()
+	change(t)
+	turnsMu.Unlock()
+	broadcastRoomStatus(roomID)
+}
+
+func setTurnStage(roomID int64, t *turn, stage string) {
+	updateTurn(roomID, t, func(t *turn) { t.Stage = stage })
+}
+
+func setGenerating(roomID int64, t *turn, participantID int64, generating bool) {
+	updateTurn(roomID, t, func(t *turn) {
+		if generating {
+			t.Generating = append(t.Generating, participantID)
+		} else {
+			t.Generating = slices.DeleteFunc(t.Generating, func(id int64) bool { return id == participantID })
+		}
+	})
+}
+
+// roomStatus returns a snapshot of the room's turns
+func roomStatus(roomID int64) RoomStatus {
+	status := RoomStatus{RoomID: roomID, State: "idle", Queued: []turn{}}
+	turnsMu.Lock()
+	defer turnsMu.Unlock()
+	rt, ok := roomQueues[roomID]
+	if !ok {
+		return status
+	}
+	status.State = "processing"
+	if rt.current != nil {
+		current := *rt.current
+		current.Generating = slices.Clone(current.Generating)
+		status.Current = &current
+	}
+	for _, t := range rt.queue {
+		status.Queued = append(status.Queued, *t)
+	}
+	return status
+}
+
+func broadcastRoomStatus(roomID int64) {
+	data, _ := json.Marshal(map[string]interface{}{
+		"type":   "turn_status",
+		"status": roomStatus(roomID),
+	})
+	broadcastToRoom(roomID, string(data))
+}
+
+// Status reports whether the room is generating replies, what the current
+// turn is doing and which turns are queued
+func (h *ChatHandler) Status(c *gin.Context) {
+	roomID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if _, ok := requireRoomRole(c, h.db, roomID, models.RoomRoleSpectator); !ok {
+		return
+	}
+
+	var policy string
+	if err := h.db.Get(&policy, "SELECT turn_policy FROM rooms WHERE id = ?", roomID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
+		return
+	}
+	status := roomStatus(roomID)
+	status.Policy = policy
+	c.JSON(http.StatusOK, status)
+}
+
+// roomTurnPolicy returns the room's turn policy, defaulting to queue
+func (h *ChatHandler) roomTurnPolicy(roomID int64) string {
+	var policy string
+	_ = h.db.Get(&policy, "SELECT turn_policy FROM rooms WHERE id = ?", roomID)
+	if policy == "" {
+		return models.TurnPolicyQueue
+	}
+	return policy
+}
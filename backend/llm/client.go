@@ -3,25 +3,50 @@ package llm
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"slices"
 	"strings"
 
 	"github.com/zucong/rp/models"
 )
 
+// Client is bound to the config it was created with. It keeps its own copy,
+// so one client can serve concurrent calls; build a new client to pick up
+// config changes rather than sharing one across them.
 type Client struct {
 	config *models.Config
+	ctx    context.Context
 }
 
 func NewClient(cfg *models.Config) *Client {
-	return &Client{config: cfg}
+	snapshot := *cfg
+	snapshot.CustomTemplate.StopSequences = slices.Clone(cfg.CustomTemplate.StopSequences)
+	return &Client{config: &snapshot, ctx: context.Background()}
 }
 
-func (c *Client) UpdateConfig(cfg *models.Config) {
-	c.config = cfg
+// WithContext returns a client whose requests are aborted when ctx ends
+func (c *Client) WithContext(ctx context.Context) *Client {
+	clone := *c
+	clone.ctx = ctx
+	return &clone
+}
+
+// Scrub removes the API key from text that is logged or shown to users,
+// such as provider error bodies that echo the request
+func (c *Client) Scrub(s string) string {
+	if len(c.config.APIKey) < 8 {
+		return s
+	}
+	return strings.ReplaceAll(s, c.config.APIKey, "[redacted]")
+}
+
+func (c *Client) scrubError(err error) error {
+	return errors.New(c.Scrub(err.Error()))
 }
 
 type Message struct {
@@ -51,23 +76,92 @@ type ChatRequest struct {
 	Temperature float64   `json:"temperature,omitempty"`
 	MaxTokens   int       `json:"max_tokens,omitempty"`
 	Stream      bool      `json:"stream,omitempty"`
+	samplingFields
+}
+
+// CompletionRequest is the body sent to /completions in completion mode
+type CompletionRequest struct {
+	Model       string  `json:"model"`
+	Prompt      string  `json:"prompt"`
+	Temperature float64 `json:"temperature,omitempty"`
+	MaxTokens   int     `json:"max_tokens,omitempty"`
+	Stream      bool    `json:"stream,omitempty"`
+	samplingFields
+}
+
+type samplingFields struct {
+	TopP              *float64           `json:"top_p,omitempty"`
+	TopK              *int               `json:"top_k,omitempty"`
+	MinP              *float64           `json:"min_p,omitempty"`
+	FrequencyPenalty  *float64           `json:"frequency_penalty,omitempty"`
+	PresencePenalty   *float64           `json:"presence_penalty,omitempty"`
+	RepetitionPenalty *float64           `json:"repetition_penalty,omitempty"`
+	Seed              *int64             `json:"seed,omitempty"`
+	Stop              []string           `json:"stop,omitempty"`
+	LogitBias         map[string]float64 `json:"logit_bias,omitempty"`
+}
+
+// newSamplingFields keeps the sampling params the configured provider
+// accepts. params.Temperature is ignored in favour of the temperature
+// callers resolve first.
+func (c *Client) newSamplingFields(params models.SamplingParams) samplingFields {
+	params, _ = c.FilterParams(params)
+	return samplingFields{
+		TopP:              params.TopP,
+		TopK:              params.TopK,
+		MinP:              params.MinP,
+		FrequencyPenalty:  params.FrequencyPenalty,
+		PresencePenalty:   params.PresencePenalty,
+		RepetitionPenalty: params.RepetitionPenalty,
+		Seed:              params.Seed,
+		Stop:              params.Stop,
+		LogitBias:         params.LogitBias,
+	}
+}
+
+// newRequest builds the request body and endpoint path for the configured
+// API mode
+func (c *Client) newRequest(messages []Message, model string, temperature float64, maxTokens int, params models.SamplingParams, stream bool) (interface{}, string, error) {
+	sampling := c.newSamplingFields(params)
+	if !c.CompletionMode() {
+		return ChatRequest{
+			Model:          model,
+			Messages:       messages,
+			Temperature:    temperature,
+			MaxTokens:      maxTokens,
+			Stream:         stream,
+			samplingFields: sampling,
+		}, "/chat/completions", nil
+	}
+
+	prompt, stop, err := c.BuildPrompt(messages, sampling.Stop)
+	if err != nil {
+		return nil, "", err
+	}
+	sampling.Stop = stop
+	return CompletionRequest{
+		Model:          model,
+		Prompt:         prompt,
+		Temperature:    temperature,
+		MaxTokens:      maxTokens,
+		Stream:         stream,
+		samplingFields: sampling,
+	}, "/completions", nil
 }
 
 type ChatResponse struct {
 	Choices []struct {
 		Message      *Message `json:"message,omitempty"`
 		Delta        *Message `json:"delta,omitempty"`
+		Text         *string  `json:"text,omitempty"`
 		FinishReason string   `json:"finish_reason"`
 	} `json:"choices"`
 }
 
-func (c *Client) Complete(messages []Message, model string, temperature float64, maxTokens int) (string, error) {
-	reqBody := ChatRequest{
-		Model:       model,
-		Messages:    messages,
-		Temperature: temperature,
-		MaxTokens:   maxTokens,
-		Stream:      false,
+func (c *Client) Complete(messages []Message, model string, temperature float64, maxTokens int, params models.SamplingParams) (string, error) {
+	reqBody, path, err := c.newRequest(messages, model, temperature, maxTokens, params, false)
+	if err != nil {
+		return "", err
 	}
 
 	jsonBody, err := json.Marshal(reqBody)
@@ -75,7 +169,7 @@ func (c *Client) Complete(messages []Message, model string, temperature float64,
 		return "", err
 	}
 
-	req, err := http.NewRequest("POST", c.config.APIEndpoint+"/chat/completions", bytes.NewBuffer(jsonBody))
+	req, err := http.NewRequestWithContext(c.ctx, "POST", c.config.APIEndpoint+path, bytes.NewBuffer(jsonBody))
 	if err != nil {
 		return "", err
 	}
@@ -85,13 +179,13 @@ func (c *Client) Complete(messages []Message, model string, temperature float64,
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return "", err
+		return "", c.scrubError(err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("API error: %s", string(body))
+		return "", fmt.Errorf("API error: %s", c.Scrub(string(body)))
 	}
 
 	var result ChatResponse
@@ -99,20 +193,23 @@ func (c *Client) Complete(messages []Message, model string, temperature float64,
 		return "", err
 	}
 
-	if len(result.Choices) == 0 || result.Choices[0].Message == nil {
+	if len(result.Choices) == 0 {
 		return "", fmt.Errorf("no response from API")
 	}
-
-	return result.Choices[0].Message.Content, nil
+	choice := result.Choices[0]
+	switch {
+	case choice.Message != nil:
+		return choice.Message.Content, nil
+	case choice.Text != nil:
+		return strings.TrimSpace(*choice.Text), nil
+	}
+	return "", fmt.Errorf("no response from API")
 }
 
-func (c *Client) StreamComplete(messages []Message, model string, temperature float64, maxTokens int, onToken func(string)) error {
-	reqBody := ChatRequest{
-		Model:       model,
-		Messages:    messages,
-		Temperature: temperature,
-		MaxTokens:   maxTokens,
-		Stream:      true,
+func (c *Client) StreamComplete(messages []Message, model string, temperature float64, maxTokens int, params models.SamplingParams, onToken func(string)) error {
+	reqBody, path, err := c.newRequest(messages, model, temperature, maxTokens, params, true)
+	if err != nil {
+		return err
 	}
 
 	jsonBody, err := json.Marshal(reqBody)
@@ -120,7 +217,7 @@ func (c *Client) StreamComplete(messages []Message, model string, temperature fl
 		return err
 	}
 
-	req, err := http.NewRequest("POST", c.config.APIEndpoint+"/chat/completions", bytes.NewBuffer(jsonBody))
+	req, err := http.NewRequestWithContext(c.ctx, "POST", c.config.APIEndpoint+path, bytes.NewBuffer(jsonBody))
 	if err != nil {
 		return err
 	}
@@ -130,13 +227,13 @@ func (c *Client) StreamComplete(messages []Message, model string, temperature fl
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return err
+		return c.scrubError(err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("API error: %s", string(body))
+		return fmt.Errorf("API error: %s", c.Scrub(string(body)))
 	}
 
 	scanner := bufio.NewScanner(resp.Body)
@@ -155,10 +252,72 @@ func (c *Client) StreamComplete(messages []Message, model string, temperature fl
 			continue
 		}
 
-		if len(streamResp.Choices) > 0 && streamResp.Choices[0].Delta != nil {
-			onToken(streamResp.Choices[0].Delta.Content)
+		if len(streamResp.Choices) == 0 {
+			continue
+		}
+		if choice := streamResp.Choices[0]; choice.Delta != nil {
+			onToken(choice.Delta.Content)
+		} else if choice.Text != nil {
+			onToken(*choice.Text)
 		}
 	}
 
 	return scanner.Err()
 }
+
+type EmbeddingRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type EmbeddingResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float64 `json:"embedding"`
+	} `json:"data"`
+}
+
+// Embed returns one embedding vector per input, in input order
+func (c *Client) Embed(inputs []string, model string) ([][]float64, error) {
+	jsonBody, err := json.Marshal(EmbeddingRequest{Model: model, Input: inputs})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(c.ctx, "POST", c.config.APIEndpoint+"/embeddings", bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.config.APIKey)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, c.scrubError(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("API error: %s", c.Scrub(string(body)))
+	}
+
+	var result EmbeddingResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	if len(result.Data) != len(inputs) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(inputs), len(result.Data))
+	}
+
+	embeddings := make([][]float64, len(inputs))
+	for _, d := range result.Data {
+		if d.Index < 0 || d.Index >= len(inputs) {
+			return nil, fmt.Errorf("embedding index %d out of range", d.Index)
+		}
+		embeddings[d.Index] = d.Embedding
+	}
+	return embeddings, nil
+}
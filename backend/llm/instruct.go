@@ -0,0 +1,151 @@
+package llm
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/zucong/rp/models"
+)
+
+// Local backends such as llama.cpp server, KoboldCpp or vLLM serving a base
+// model are often driven through /completions instead of /chat/completions.
+// In that mode the role-tagged messages are rendered into one prompt using
+// the instruct template the model was trained with.
+
+const (
+	ModeChat       = "chat"
+	ModeCompletion = "completion"
+)
+
+// Modes lists the API modes accepted in the config
+var Modes = []string{ModeChat, ModeCompletion}
+
+// TemplateCustom selects the custom template stored in the config
+const TemplateCustom = "custom"
+
+// InstructTemplates are the built-in templates, by name
+var InstructTemplates = map[string]models.InstructTemplate{
+	"chatml": {
+		SystemPrefix:    "<|im_start|>system\n",
+		SystemSuffix:    "<|im_end|>\n",
+		UserPrefix:      "<|im_start|>user\n",
+		UserSuffix:      "<|im_end|>\n",
+		AssistantPrefix: "<|im_start|>assistant\n",
+		AssistantSuffix: "<|im_end|>\n",
+		StopSequences:   []string{"<|im_end|>", "<|im_start|>"},
+	},
+	"llama3": {
+		SystemPrefix:    "<|start_header_id|>system<|end_header_id|>\n\n",
+		SystemSuffix:    "<|eot_id|>",
+		UserPrefix:      "<|start_header_id|>user<|end_header_id|>\n\n",
+		UserSuffix:      "<|eot_id|>",
+		AssistantPrefix: "<|start_header_id|>assistant<|end_header_id|>\n\n",
+		AssistantSuffix: "<|eot_id|>",
+		StopSequences:   []string{"<|eot_id|>", "<|end_of_text|>", "<|start_header_id|>"},
+	},
+	"mistral": {
+		UserPrefix:      "[INST] ",
+		UserSuffix:      " [/INST]",
+		AssistantSuffix: "</s>",
+		SystemAsUser:    true,
+		StopSequences:   []string{"</s>", "[INST]"},
+	},
+	"alpaca": {
+		SystemSuffix:    "\n\n",
+		UserPrefix:      "### Instruction:\n",
+		UserSuffix:      "\n\n",
+		AssistantPrefix: "### Response:\n",
+		AssistantSuffix: "\n\n",
+		StopSequences:   []string{"### Instruction:", "### Response:"},
+	},
+	"gemma": {
+		UserPrefix:      "<start_of_turn>user\n",
+		UserSuffix:      "<end_of_turn>\n",
+		AssistantPrefix: "<start_of_turn>model\n",
+		AssistantSuffix: "<end_of_turn>\n",
+		SystemAsUser:    true,
+		StopSequences:   []string{"<end_of_turn>", "<start_of_turn>"},
+	},
+}
+
+// TemplateNames lists the template names accepted in the config
+func TemplateNames() []string {
+	return []string{"chatml", "llama3", "mistral", "alpaca", "gemma", TemplateCustom}
+}
+
+// CompletionMode reports whether requests go through /completions
+func (c *Client) CompletionMode() bool {
+	return c.config.APIMode == ModeCompletion
+}
+
+// Template returns the configured instruct template
+func (c *Client) Template() (models.InstructTemplate, error) {
+	name := c.config.InstructTemplate
+	if name == TemplateCustom {
+		return c.config.CustomTemplate, nil
+	}
+	tpl, ok := InstructTemplates[name]
+	if !ok {
+		return models.InstructTemplate{}, fmt.Errorf("unknown instruct template: %s", name)
+	}
+	return tpl, nil
+}
+
+// BuildPrompt renders messages through the configured template and returns
+// the prompt along with the stop sequences to send: the template's own
+// followed by any extra ones from the sampling params
+func (c *Client) BuildPrompt(messages []Message, extraStop []string) (string, []string, error) {
+	tpl, err := c.Template()
+	if err != nil {
+		return "", nil, err
+	}
+
+	stop := append([]string{}, tpl.StopSequences...)
+	for _, s := range extraStop {
+		if s != "" && !slices.Contains(stop, s) {
+			stop = append(stop, s)
+		}
+	}
+	return RenderPrompt(tpl, messages), stop, nil
+}
+
+// RenderPrompt lays out messages with the template and leaves the prompt
+// open at the start of an assistant turn
+func RenderPrompt(tpl models.InstructTemplate, messages []Message) string {
+	var b strings.Builder
+	var pendingSystem []string
+
+	for _, m := range messages {
+		switch m.Role {
+		case "system":
+			if tpl.SystemAsUser {
+				pendingSystem = append(pendingSystem, m.Content)
+				continue
+			}
+			b.WriteString(tpl.SystemPrefix + m.Content + tpl.SystemSuffix)
+		case "assistant":
+			if len(pendingSystem) > 0 {
+				b.WriteString(tpl.UserPrefix + strings.Join(pendingSystem, "\n\n") + tpl.UserSuffix)
+				pendingSystem = nil
+			}
+			b.WriteString(tpl.AssistantPrefix + m.Content + tpl.AssistantSuffix)
+		default:
+			content := m.Content
+			if len(pendingSystem) > 0 {
+				content = strings.Join(append(pendingSystem, content), "\n\n")
+				pendingSystem = nil
+			}
+			b.WriteString(tpl.UserPrefix + content + tpl.UserSuffix)
+		}
+	}
+
+	// System text after the last user turn, such as post-history
+	// instructions, still needs a turn of its own
+	if len(pendingSystem) > 0 {
+		b.WriteString(tpl.UserPrefix + strings.Join(pendingSystem, "\n\n") + tpl.UserSuffix)
+	}
+
+	b.WriteString(tpl.AssistantPrefix)
+	return b.String()
+}
@@ -0,0 +1,101 @@
+package llm
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/zucong/rp/models"
+)
+
+// Providers speak the OpenAI chat completions API but differ in which
+// sampling parameters they accept; some reject requests carrying unknown
+// fields. Parameters a provider doesn't support are dropped before sending.
+
+const (
+	ProviderAuto       = "auto"
+	ProviderOpenAI     = "openai"
+	ProviderAzure      = "azure"
+	ProviderOpenRouter = "openrouter"
+	ProviderGroq       = "groq"
+	ProviderMistral    = "mistral"
+	ProviderOllama     = "ollama"
+	// ProviderGeneric covers llama.cpp, vLLM, KoboldCpp and other local
+	// servers, which accept every parameter
+	ProviderGeneric = "generic"
+)
+
+// Providers lists the provider names accepted in the config
+var Providers = []string{
+	ProviderAuto, ProviderOpenAI, ProviderAzure, ProviderOpenRouter,
+	ProviderGroq, ProviderMistral, ProviderOllama, ProviderGeneric,
+}
+
+// unsupportedParams lists, per provider, the sampling parameters it rejects
+// or ignores
+var unsupportedParams = map[string][]string{
+	ProviderOpenAI:  {"top_k", "min_p", "repetition_penalty"},
+	ProviderAzure:   {"top_k", "min_p", "repetition_penalty"},
+	ProviderGroq:    {"top_k", "min_p", "repetition_penalty", "logit_bias"},
+	ProviderMistral: {"top_k", "min_p", "repetition_penalty", "logit_bias", "seed", "frequency_penalty"},
+	ProviderOllama:  {"top_k", "min_p", "repetition_penalty", "logit_bias"},
+}
+
+// DetectProvider guesses the provider from the API endpoint
+func DetectProvider(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return ProviderGeneric
+	}
+	host := u.Hostname()
+	switch {
+	case host == "api.openai.com":
+		return ProviderOpenAI
+	case strings.HasSuffix(host, ".openai.azure.com"):
+		return ProviderAzure
+	case strings.HasSuffix(host, "openrouter.ai"):
+		return ProviderOpenRouter
+	case host == "api.groq.com":
+		return ProviderGroq
+	case host == "api.mistral.ai":
+		return ProviderMistral
+	case u.Port() == "11434":
+		return ProviderOllama
+	}
+	return ProviderGeneric
+}
+
+// Provider returns the configured provider, detecting it from the endpoint
+// when set to auto
+func (c *Client) Provider() string {
+	if c.config.Provider != "" && c.config.Provider != ProviderAuto {
+		return c.config.Provider
+	}
+	return DetectProvider(c.config.APIEndpoint)
+}
+
+// FilterParams removes the parameters the provider doesn't support and
+// returns the names of those removed
+func (c *Client) FilterParams(p models.SamplingParams) (models.SamplingParams, []string) {
+	var dropped []string
+	for _, name := range unsupportedParams[c.Provider()] {
+		set := false
+		switch name {
+		case "top_k":
+			set, p.TopK = p.TopK != nil, nil
+		case "min_p":
+			set, p.MinP = p.MinP != nil, nil
+		case "repetition_penalty":
+			set, p.RepetitionPenalty = p.RepetitionPenalty != nil, nil
+		case "logit_bias":
+			set, p.LogitBias = p.LogitBias != nil, nil
+		case "seed":
+			set, p.Seed = p.Seed != nil, nil
+		case "frequency_penalty":
+			set, p.FrequencyPenalty = p.FrequencyPenalty != nil, nil
+		}
+		if set {
+			dropped = append(dropped, name)
+		}
+	}
+	return p, dropped
+}
@@ -0,0 +1,33 @@
+package llm
+
+// Token counts are estimated without a tokenizer, which differs per model.
+// The estimates are meant for comparing the parts of a prompt, not for
+// exact budgeting.
+
+// messageOverhead is the approximate number of tokens chat formatting adds
+// around each message
+const messageOverhead = 4
+
+// EstimateTokens approximates the token count of text: about four
+// characters per token for alphabetic scripts and one per character for
+// CJK and other wide scripts
+func EstimateTokens(text string) int {
+	var narrow, wide int
+	for _, r := range text {
+		if r < 0x2E80 {
+			narrow++
+		} else {
+			wide++
+		}
+	}
+	return (narrow+3)/4 + wide
+}
+
+// EstimateMessageTokens approximates the prompt tokens of messages
+func EstimateMessageTokens(messages []Message) int {
+	total := 0
+	for _, m := range messages {
+		total += messageOverhead + EstimateTokens(m.Content)
+	}
+	return total
+}
@@ -4,13 +4,14 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/zucong/rp/config"
 	"github.com/zucong/rp/db"
 	"github.com/zucong/rp/handlers"
-	"github.com/zucong/rp/llm"
+	"github.com/zucong/rp/services"
 )
 
 func main() {
@@ -23,37 +24,81 @@ func main() {
 		log.Fatal("Failed to load config:", err)
 	}
 
+	// Reload config.yaml when it changes
+	go config.Watch(*configPath, 2*time.Second)
+
 	// Initialize database with config path
 	if err := db.Init(config.GlobalConfig.Database.Path); err != nil {
 		log.Fatal("Failed to initialize database:", err)
 	}
 	defer db.Close()
 
-	// Initialize config store
+	// Create the first admin account from the config if there are none
+	if err := services.BootstrapAdmin(db.DB, config.GlobalConfig.Auth.AdminUsername, config.GlobalConfig.Auth.AdminPassword); err != nil {
+		log.Fatal("Failed to set up accounts:", err)
+	}
+
+	// Initialize config store; the API key is stored encrypted
+	if err := config.InitSecrets(); err != nil {
+		log.Fatal("Failed to load encryption key:", err)
+	}
 	cfgStore := config.NewStore(db.DB)
+	if err := cfgStore.EncryptStoredKey(); err != nil {
+		log.Fatal("Failed to encrypt stored API key:", err)
+	}
 
-	// Get initial config for LLM client
-	cfg, err := cfgStore.Get()
-	if err != nil {
+	// Check the saved LLM settings load; each LLM call then builds its
+	// client from a fresh snapshot of them
+	if _, err := cfgStore.Get(); err != nil {
 		log.Fatal("Failed to get config:", err)
 	}
 
-	// Initialize LLM client
-	llmClient := llm.NewClient(cfg)
+	// Resume embedding backfills interrupted by the last shutdown
+	indexer := services.NewEmbeddingIndexer(db.DB, cfgStore)
+	indexer.ResumeAll()
 
 	// Setup router
 	r := gin.Default()
 
-	// CORS
-	r.Use(cors.Default())
+	// CORS: the bundled frontend is same-origin, so other origins are only
+	// allowed when listed in the config. Credentials are allowed for them
+	// so the session cookie works.
+	if origins := config.GlobalConfig.Server.AllowedOrigins; len(origins) > 0 {
+		r.Use(cors.New(cors.Config{
+			AllowOrigins:     origins,
+			AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+			AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
+			AllowCredentials: true,
+			MaxAge:           12 * time.Hour,
+		}))
+	}
 
 	// Static files (frontend)
 	r.Static("/static", "./frontend/dist/assets")
 	r.StaticFile("/", "./frontend/dist/index.html")
 
+	// Login is the only API route open without a session
+	authHandler := handlers.NewAuthHandler(db.DB)
+	r.POST("/api/auth/login", authHandler.Login)
+
+	// Public read-only share links
+	shareHandler := handlers.NewShareHandler(db.DB)
+	r.GET("/share/:token", shareHandler.Transcript)
+	r.GET("/share/:token/events", shareHandler.Events)
+
 	// API routes
-	api := r.Group("/api")
+	api := r.Group("/api", authHandler.RequireAuth)
+	admin := api.Group("", authHandler.RequireAdmin)
 	{
+		// Accounts
+		api.POST("/auth/logout", authHandler.Logout)
+		api.GET("/auth/me", authHandler.Me)
+		api.PUT("/auth/password", authHandler.ChangePassword)
+		api.GET("/users", authHandler.ListUsers)
+		admin.POST("/users", authHandler.CreateUser)
+		admin.PUT("/users/:id", authHandler.UpdateUser)
+		admin.DELETE("/users/:id", authHandler.DeleteUser)
+
 		// Characters
 		charHandler := handlers.NewCharacterHandler(db.DB)
 		api.GET("/characters", charHandler.List)
@@ -61,9 +106,15 @@ func main() {
 		api.POST("/characters", charHandler.Create)
 		api.PUT("/characters/:id", charHandler.Update)
 		api.DELETE("/characters/:id", charHandler.Delete)
+		api.POST("/characters/import", charHandler.Import)
+		api.GET("/characters/:id/export", charHandler.Export)
+		api.GET("/characters/:id/versions", charHandler.ListVersions)
+		api.GET("/characters/:id/versions/diff", charHandler.DiffVersions)
+		api.GET("/characters/:id/versions/:version", charHandler.GetVersion)
+		api.POST("/characters/:id/versions/:version/rollback", charHandler.RollbackVersion)
 
 		// Rooms
-		roomHandler := handlers.NewRoomHandler(db.DB)
+		roomHandler := handlers.NewRoomHandler(db.DB, indexer)
 		api.GET("/rooms", roomHandler.List)
 		api.GET("/rooms/:id", roomHandler.Get)
 		api.POST("/rooms", roomHandler.Create)
@@ -71,24 +122,83 @@ func main() {
 		api.DELETE("/rooms/:id", roomHandler.Delete)
 		api.GET("/rooms/:id/participants", roomHandler.ListParticipants)
 		api.POST("/rooms/:id/participants", roomHandler.AddParticipant)
+		api.PUT("/rooms/:id/participants/:pid", roomHandler.UpdateParticipant)
 		api.DELETE("/rooms/:id/participants/:pid", roomHandler.RemoveParticipant)
+		api.GET("/rooms/:id/members", roomHandler.ListMembers)
+		api.PUT("/rooms/:id/members/:userId", roomHandler.SetMember)
+		api.DELETE("/rooms/:id/members/:userId", roomHandler.RemoveMember)
 		api.GET("/rooms/:id/messages", roomHandler.ListMessages)
 		api.DELETE("/rooms/:id/messages", roomHandler.ResetChat)
+		api.GET("/rooms/:id/export", roomHandler.Export)
+		api.GET("/rooms/:id/bundle", roomHandler.ExportBundle)
+		api.POST("/rooms/import", roomHandler.ImportBundle)
+		api.POST("/rooms/import-chat", roomHandler.ImportChat)
+
+		// Share links
+		api.GET("/rooms/:id/shares", shareHandler.List)
+		api.POST("/rooms/:id/shares", shareHandler.Create)
+		api.DELETE("/rooms/:id/shares/:sid", shareHandler.Revoke)
+
+		// Checkpoints
+		checkpointHandler := handlers.NewCheckpointHandler(db.DB, indexer)
+		api.GET("/rooms/:id/checkpoints", checkpointHandler.List)
+		api.POST("/rooms/:id/checkpoints", checkpointHandler.Create)
+		api.DELETE("/rooms/:id/checkpoints/:cid", checkpointHandler.Delete)
+		api.POST("/rooms/:id/rewind", checkpointHandler.Rewind)
+		api.GET("/rooms/:id/rewinds", checkpointHandler.ListRewinds)
+
+		// Search
+		searchHandler := handlers.NewSearchHandler(db.DB, indexer)
+		api.GET("/search", searchHandler.Search)
+		api.GET("/rooms/:id/semantic-search", searchHandler.SemanticSearch)
+		api.GET("/rooms/:id/embeddings", searchHandler.GetEmbeddingBackfill)
+		api.POST("/rooms/:id/embeddings", searchHandler.StartEmbeddingBackfill)
 
 		// Config
 		configHandler := handlers.NewConfigHandler(db.DB)
-		api.GET("/config", configHandler.Get)
-		api.PUT("/config", configHandler.Update)
+		admin.GET("/config", configHandler.Get)
+		admin.GET("/config/effective", configHandler.Effective)
+		admin.PUT("/config", configHandler.Update)
+
+		// Sampler presets
+		presetHandler := handlers.NewPresetHandler(db.DB)
+		api.GET("/presets", presetHandler.List)
+		api.POST("/presets", presetHandler.Create)
+		api.GET("/presets/:id", presetHandler.Get)
+		api.PUT("/presets/:id", presetHandler.Update)
+		api.DELETE("/presets/:id", presetHandler.Delete)
+
+		// Prompt templates
+		promptHandler := handlers.NewPromptTemplateHandler(db.DB)
+		api.GET("/prompt-templates", promptHandler.List)
+		api.POST("/prompt-templates/preview", promptHandler.Preview)
+		admin.PUT("/prompt-templates/:kind", promptHandler.Update)
+		admin.DELETE("/prompt-templates/:kind", promptHandler.Delete)
+		api.GET("/rooms/:id/prompt-templates", promptHandler.ListRoom)
+		api.PUT("/rooms/:id/prompt-templates/:kind", promptHandler.UpdateRoom)
+		api.DELETE("/rooms/:id/prompt-templates/:kind", promptHandler.DeleteRoom)
+
+		// Author's notes
+		noteHandler := handlers.NewAuthorsNoteHandler(db.DB)
+		api.GET("/rooms/:id/authors-note", noteHandler.GetRoom)
+		api.PUT("/rooms/:id/authors-note", noteHandler.UpdateRoom)
+		api.DELETE("/rooms/:id/authors-note", noteHandler.DeleteRoom)
+		api.GET("/characters/:id/authors-note", noteHandler.GetCharacter)
+		api.PUT("/characters/:id/authors-note", noteHandler.UpdateCharacter)
+		api.DELETE("/characters/:id/authors-note", noteHandler.DeleteCharacter)
 
 		// Chat
-		chatHandler := handlers.NewChatHandler(db.DB, llmClient, cfgStore)
+		chatHandler := handlers.NewChatHandler(db.DB, cfgStore, indexer)
 		api.POST("/rooms/:id/chat", chatHandler.SendMessage)
+		api.POST("/rooms/:id/preview", chatHandler.Preview)
 		api.GET("/rooms/:id/events", chatHandler.Events)
 		api.PUT("/messages/:msgId", chatHandler.EditMessage)
 		api.DELETE("/messages/:msgId", chatHandler.DeleteMessage)
 		api.POST("/rooms/:id/regenerate", chatHandler.Regenerate)
+		api.GET("/rooms/:id/status", chatHandler.Status)
 		api.GET("/messages/:msgId/llm-logs", chatHandler.GetLLMLogs)
 		api.GET("/messages/:msgId/decisions", chatHandler.GetDecisions)
+		api.GET("/messages/:msgId/swipes", chatHandler.GetSwipes)
 	}
 
 	// Use port from config
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// InstructTemplate describes how role-tagged messages are laid out in a
+// single prompt for text-completion backends. Each turn is written as
+// prefix + content + suffix; the prompt ends with AssistantPrefix so the
+// model continues as the assistant.
+type InstructTemplate struct {
+	SystemPrefix    string `json:"system_prefix"`
+	SystemSuffix    string `json:"system_suffix"`
+	UserPrefix      string `json:"user_prefix"`
+	UserSuffix      string `json:"user_suffix"`
+	AssistantPrefix string `json:"assistant_prefix"`
+	AssistantSuffix string `json:"assistant_suffix"`
+
+	// SystemAsUser folds system messages into the next user turn, for
+	// models trained without a system role
+	SystemAsUser bool `json:"system_as_user"`
+
+	// StopSequences end generation once the model starts a new turn
+	StopSequences []string `json:"stop_sequences"`
+}
+
+// Value stores the template as JSON
+func (t InstructTemplate) Value() (driver.Value, error) {
+	b, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan reads a template stored as JSON
+func (t *InstructTemplate) Scan(src interface{}) error {
+	var raw []byte
+	switch v := src.(type) {
+	case nil:
+		*t = InstructTemplate{}
+		return nil
+	case string:
+		raw = []byte(v)
+	case []byte:
+		raw = v
+	default:
+		return fmt.Errorf("cannot scan %T into InstructTemplate", src)
+	}
+	if len(raw) == 0 {
+		*t = InstructTemplate{}
+		return nil
+	}
+	return json.Unmarshal(raw, t)
+}
@@ -3,16 +3,70 @@ package models
 import "time"
 
 type Character struct {
-	ID             int64     `json:"id" db:"id"`
-	Name           string    `json:"name" db:"name"`
-	Avatar         string    `json:"avatar" db:"avatar"`
-	Prompt         string    `json:"prompt" db:"prompt"`
-	IsUserPlayable bool      `json:"is_user_playable" db:"is_user_playable"`
-	ModelName      string    `json:"model_name" db:"model_name"`
-	Temperature    float64   `json:"temperature" db:"temperature"`
-	MaxTokens      int       `json:"max_tokens" db:"max_tokens"`
-	CreatedAt      time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt      time.Time `json:"updated_at" db:"updated_at"`
+	ID                      int64     `json:"id" db:"id"`
+	Name                    string    `json:"name" db:"name"`
+	Avatar                  string    `json:"avatar" db:"avatar"`
+	Prompt                  string    `json:"prompt" db:"prompt"`
+	Description             string    `json:"description" db:"description"`
+	Personality             string    `json:"personality" db:"personality"`
+	Scenario                string    `json:"scenario" db:"scenario"`
+	ExampleDialogues        string    `json:"example_dialogues" db:"example_dialogues"`
+	Greeting                string    `json:"greeting" db:"greeting"`
+	PostHistoryInstructions string    `json:"post_history_instructions" db:"post_history_instructions"`
+	IsUserPlayable          bool      `json:"is_user_playable" db:"is_user_playable"`
+	ModelName               string    `json:"model_name" db:"model_name"`
+	Temperature             float64   `json:"temperature" db:"temperature"`
+	MaxTokens               int       `json:"max_tokens" db:"max_tokens"`
+	SamplerPresetID         int64     `json:"sampler_preset_id" db:"sampler_preset_id"`
+	Version                 int       `json:"version" db:"version"`
+	CreatedAt               time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt               time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// CharacterVersion is an immutable snapshot of a character's definition
+type CharacterVersion struct {
+	ID                      int64     `json:"id" db:"id"`
+	CharacterID             int64     `json:"character_id" db:"character_id"`
+	Version                 int       `json:"version" db:"version"`
+	Name                    string    `json:"name" db:"name"`
+	Avatar                  string    `json:"avatar" db:"avatar"`
+	Prompt                  string    `json:"prompt" db:"prompt"`
+	Description             string    `json:"description" db:"description"`
+	Personality             string    `json:"personality" db:"personality"`
+	Scenario                string    `json:"scenario" db:"scenario"`
+	ExampleDialogues        string    `json:"example_dialogues" db:"example_dialogues"`
+	Greeting                string    `json:"greeting" db:"greeting"`
+	PostHistoryInstructions string    `json:"post_history_instructions" db:"post_history_instructions"`
+	IsUserPlayable          bool      `json:"is_user_playable" db:"is_user_playable"`
+	ModelName               string    `json:"model_name" db:"model_name"`
+	Temperature             float64   `json:"temperature" db:"temperature"`
+	MaxTokens               int       `json:"max_tokens" db:"max_tokens"`
+	SamplerPresetID         int64     `json:"sampler_preset_id" db:"sampler_preset_id"`
+	CreatedAt               time.Time `json:"created_at" db:"created_at"`
+}
+
+// Character returns the version as a character definition
+func (v *CharacterVersion) Character() *Character {
+	return &Character{
+		ID:                      v.CharacterID,
+		Name:                    v.Name,
+		Avatar:                  v.Avatar,
+		Prompt:                  v.Prompt,
+		Description:             v.Description,
+		Personality:             v.Personality,
+		Scenario:                v.Scenario,
+		ExampleDialogues:        v.ExampleDialogues,
+		Greeting:                v.Greeting,
+		PostHistoryInstructions: v.PostHistoryInstructions,
+		IsUserPlayable:          v.IsUserPlayable,
+		ModelName:               v.ModelName,
+		Temperature:             v.Temperature,
+		MaxTokens:               v.MaxTokens,
+		SamplerPresetID:         v.SamplerPresetID,
+		Version:                 v.Version,
+		CreatedAt:               v.CreatedAt,
+		UpdatedAt:               v.CreatedAt,
+	}
 }
 
 type Room struct {
@@ -20,6 +74,11 @@ type Room struct {
 	Name        string    `json:"name" db:"name"`
 	Description string    `json:"description" db:"description"`
 	Setting     string    `json:"setting" db:"setting"`
+	// SamplerPresetID applies a sampler preset to every AI participant in the room
+	SamplerPresetID int64     `json:"sampler_preset_id" db:"sampler_preset_id"`
+	// TurnPolicy decides what a user message does while AI replies to an
+	// earlier one are still being generated
+	TurnPolicy  string    `json:"turn_policy" db:"turn_policy"`
 	CreatedAt   time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
 }
@@ -32,12 +91,36 @@ type RoomParticipant struct {
 	CharacterAvatar  string    `json:"character_avatar" db:"character_avatar"`
 	ParticipantType  string    `json:"participant_type" db:"participant_type"`
 	IsUser           bool      `json:"is_user" db:"is_user"`
+	// CharacterVersion pins the participant to a character version; 0 follows the latest
+	CharacterVersion int       `json:"character_version" db:"character_version"`
+	// Room-specific overrides of the character's defaults; empty or null
+	// values fall back to the character
+	ModelOverride       string   `json:"model_override" db:"model_override"`
+	TemperatureOverride *float64 `json:"temperature_override" db:"temperature_override"`
+	MaxTokensOverride   *int     `json:"max_tokens_override" db:"max_tokens_override"`
+	ExtraPrompt         string   `json:"extra_prompt" db:"extra_prompt"`
+	Nickname            string   `json:"nickname" db:"nickname"`
+	// UserID is the account a human participant is assigned to; players
+	// can only post as their own participants. 0 leaves it unassigned.
+	UserID           int64     `json:"user_id" db:"user_id"`
 	CreatedAt        time.Time `json:"created_at" db:"created_at"`
 }
 
+// ParticipantDetails is a participant with the values it effectively uses
+// after room overrides are applied
+type ParticipantDetails struct {
+	RoomParticipant
+	DisplayName string  `json:"display_name" db:"display_name"`
+	ModelName   string  `json:"model_name" db:"model_name"`
+	Temperature float64 `json:"temperature" db:"temperature"`
+	MaxTokens   int     `json:"max_tokens" db:"max_tokens"`
+}
+
 type Message struct {
 	ID              int64     `json:"id" db:"id"`
 	RoomID          int64     `json:"room_id" db:"room_id"`
+	// Seq orders the room's messages; it only ever increases
+	Seq             int64     `json:"seq" db:"seq"`
 	ParticipantID   int64     `json:"participant_id" db:"participant_id"`
 	ParticipantName string    `json:"participant_name" db:"participant_name"`
 	ParticipantAvatar string  `json:"participant_avatar" db:"participant_avatar"`
@@ -56,9 +139,67 @@ type Summary struct {
 }
 
 type Config struct {
-	APIEndpoint   string  `json:"api_endpoint" db:"api_endpoint"`
-	APIKey        string  `json:"api_key" db:"api_key"`
-	DefaultModel  string  `json:"default_model" db:"default_model"`
+	APIEndpoint    string `json:"api_endpoint" db:"api_endpoint"`
+	APIKey         string `json:"api_key" db:"api_key"`
+	DefaultModel   string `json:"default_model" db:"default_model"`
+	EmbeddingModel string `json:"embedding_model" db:"embedding_model"`
+	Provider       string `json:"provider" db:"provider"`
+
+	// APIMode is "chat" for /chat/completions or "completion" for
+	// /completions with the messages rendered through an instruct template
+	APIMode          string           `json:"api_mode" db:"api_mode"`
+	InstructTemplate string           `json:"instruct_template" db:"instruct_template"`
+	CustomTemplate   InstructTemplate `json:"custom_template" db:"custom_template"`
+}
+
+// User is a local account; sessions refer to it by ID
+type User struct {
+	ID           int64     `json:"id" db:"id"`
+	Username     string    `json:"username" db:"username"`
+	PasswordHash string    `json:"-" db:"password_hash"`
+	IsAdmin      bool      `json:"is_admin" db:"is_admin"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// Room roles, from least to most privileged. Spectators read messages and
+// events, players also post as their assigned participants, and owners
+// manage the room. Admins act as owners of every room.
+const (
+	RoomRoleSpectator = "spectator"
+	RoomRolePlayer    = "player"
+	RoomRoleOwner     = "owner"
+)
+
+// Turn policies. With queue, replies to a new user message wait for the
+// current turn to finish. With preempt, the new message cancels the
+// current turn and any queued ones, and its turn replies to all of them.
+const (
+	TurnPolicyQueue   = "queue"
+	TurnPolicyPreempt = "preempt"
+)
+
+type RoomMember struct {
+	ID        int64     `json:"id" db:"id"`
+	RoomID    int64     `json:"room_id" db:"room_id"`
+	UserID    int64     `json:"user_id" db:"user_id"`
+	Username  string    `json:"username" db:"username"`
+	Role      string    `json:"role" db:"role"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// ShareLink gives read-only access to a room's transcript to anyone with
+// the token, without an account
+type ShareLink struct {
+	ID     int64  `json:"id" db:"id"`
+	RoomID int64  `json:"room_id" db:"room_id"`
+	Token  string `json:"token" db:"token"`
+	// Live allows following new messages over SSE
+	Live bool `json:"live" db:"live"`
+	// ExpiresAt is nil for links that don't expire
+	ExpiresAt *time.Time `json:"expires_at" db:"expires_at"`
+	CreatedBy int64      `json:"created_by" db:"created_by"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
 }
 
 type LLMCallLog struct {
@@ -75,6 +216,8 @@ type LLMCallLog struct {
 	CompletionTokens  int       `json:"completion_tokens" db:"completion_tokens"`
 	LatencyMs         int64     `json:"latency_ms" db:"latency_ms"`
 	ErrorMessage      string    `json:"error_message" db:"error_message"`
+	CharacterID       int64     `json:"character_id" db:"character_id"`
+	CharacterVersion  int       `json:"character_version" db:"character_version"`
 	CreatedAt         time.Time `json:"created_at" db:"created_at"`
 }
 
@@ -90,3 +233,64 @@ type OrchestratorDecision struct {
 	Reason        string    `json:"reason" db:"reason"`
 	CreatedAt     time.Time `json:"created_at" db:"created_at"`
 }
+
+// AuthorsNote is steering text injected into the conversation a fixed
+// number of messages from the end. A note belongs to either a room
+// (CharacterID 0) or a character (RoomID 0).
+type AuthorsNote struct {
+	ID          int64     `json:"id" db:"id"`
+	RoomID      int64     `json:"room_id" db:"room_id"`
+	CharacterID int64     `json:"character_id" db:"character_id"`
+	Content     string    `json:"content" db:"content"`
+	// Depth is how many messages from the end the note goes; 0 places it
+	// after the last message
+	Depth int    `json:"depth" db:"depth"`
+	Role  string `json:"role" db:"role"`
+	// Frequency inserts the note every N user turns; 0 disables it
+	Frequency int       `json:"frequency" db:"frequency"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+type RoomCheckpoint struct {
+	ID            int64     `json:"id" db:"id"`
+	RoomID        int64     `json:"room_id" db:"room_id"`
+	Name          string    `json:"name" db:"name"`
+	Setting       string    `json:"setting" db:"setting"`
+	LastMessageID int64     `json:"last_message_id" db:"last_message_id"`
+	MessageCount  int       `json:"message_count" db:"message_count"`
+	Snapshot      string    `json:"-" db:"snapshot"`
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+}
+
+type RoomRewind struct {
+	ID               int64     `json:"id" db:"id"`
+	RoomID           int64     `json:"room_id" db:"room_id"`
+	CheckpointID     int64     `json:"checkpoint_id" db:"checkpoint_id"`
+	MessageID        int64     `json:"message_id" db:"message_id"`
+	RemovedMessages  string    `json:"removed_messages" db:"removed_messages"`
+	RestoredMessages string    `json:"restored_messages" db:"restored_messages"`
+	CreatedAt        time.Time `json:"created_at" db:"created_at"`
+}
+
+type EmbeddingJob struct {
+	ID            int64     `json:"id" db:"id"`
+	RoomID        int64     `json:"room_id" db:"room_id"`
+	Model         string    `json:"model" db:"model"`
+	Status        string    `json:"status" db:"status"`
+	Total         int       `json:"total" db:"total"`
+	Processed     int       `json:"processed" db:"processed"`
+	LastMessageID int64     `json:"last_message_id" db:"last_message_id"`
+	ErrorMessage  string    `json:"error_message" db:"error_message"`
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at" db:"updated_at"`
+}
+
+type MessageSwipe struct {
+	ID         int64     `json:"id" db:"id"`
+	MessageID  int64     `json:"message_id" db:"message_id"`
+	SwipeIndex int       `json:"swipe_index" db:"swipe_index"`
+	Content    string    `json:"content" db:"content"`
+	IsSelected bool      `json:"is_selected" db:"is_selected"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+}
@@ -0,0 +1,130 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// SamplingParams are the optional sampler settings sent with a completion
+// request. Nil fields are left to the provider's defaults.
+type SamplingParams struct {
+	Temperature       *float64           `json:"temperature,omitempty"`
+	TopP              *float64           `json:"top_p,omitempty"`
+	TopK              *int               `json:"top_k,omitempty"`
+	MinP              *float64           `json:"min_p,omitempty"`
+	FrequencyPenalty  *float64           `json:"frequency_penalty,omitempty"`
+	PresencePenalty   *float64           `json:"presence_penalty,omitempty"`
+	RepetitionPenalty *float64           `json:"repetition_penalty,omitempty"`
+	Seed              *int64             `json:"seed,omitempty"`
+	Stop              []string           `json:"stop,omitempty"`
+	LogitBias         map[string]float64 `json:"logit_bias,omitempty"`
+}
+
+// Merge returns p with every field that is set in o taken from o
+func (p SamplingParams) Merge(o SamplingParams) SamplingParams {
+	if o.Temperature != nil {
+		p.Temperature = o.Temperature
+	}
+	if o.TopP != nil {
+		p.TopP = o.TopP
+	}
+	if o.TopK != nil {
+		p.TopK = o.TopK
+	}
+	if o.MinP != nil {
+		p.MinP = o.MinP
+	}
+	if o.FrequencyPenalty != nil {
+		p.FrequencyPenalty = o.FrequencyPenalty
+	}
+	if o.PresencePenalty != nil {
+		p.PresencePenalty = o.PresencePenalty
+	}
+	if o.RepetitionPenalty != nil {
+		p.RepetitionPenalty = o.RepetitionPenalty
+	}
+	if o.Seed != nil {
+		p.Seed = o.Seed
+	}
+	if o.Stop != nil {
+		p.Stop = o.Stop
+	}
+	if o.LogitBias != nil {
+		p.LogitBias = o.LogitBias
+	}
+	return p
+}
+
+// Validate checks that every set parameter is within its accepted range
+func (p SamplingParams) Validate() error {
+	inRange := func(name string, v *float64, lo, hi float64) error {
+		if v != nil && (*v < lo || *v > hi) {
+			return fmt.Errorf("%s must be between %g and %g", name, lo, hi)
+		}
+		return nil
+	}
+	for _, check := range []error{
+		inRange("temperature", p.Temperature, 0, 2),
+		inRange("top_p", p.TopP, 0, 1),
+		inRange("min_p", p.MinP, 0, 1),
+		inRange("frequency_penalty", p.FrequencyPenalty, -2, 2),
+		inRange("presence_penalty", p.PresencePenalty, -2, 2),
+		inRange("repetition_penalty", p.RepetitionPenalty, 0, 10),
+	} {
+		if check != nil {
+			return check
+		}
+	}
+	if p.TopK != nil && *p.TopK < 0 {
+		return fmt.Errorf("top_k must not be negative")
+	}
+	for token, bias := range p.LogitBias {
+		if bias < -100 || bias > 100 {
+			return fmt.Errorf("logit_bias for token %s must be between -100 and 100", token)
+		}
+	}
+	return nil
+}
+
+// Value stores the params as JSON
+func (p SamplingParams) Value() (driver.Value, error) {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan reads params stored as JSON
+func (p *SamplingParams) Scan(src interface{}) error {
+	var raw []byte
+	switch v := src.(type) {
+	case nil:
+		*p = SamplingParams{}
+		return nil
+	case string:
+		raw = []byte(v)
+	case []byte:
+		raw = v
+	default:
+		return fmt.Errorf("cannot scan %T into SamplingParams", src)
+	}
+	if len(raw) == 0 {
+		*p = SamplingParams{}
+		return nil
+	}
+	return json.Unmarshal(raw, p)
+}
+
+// SamplerPreset is a named, reusable set of sampling parameters that
+// characters and rooms can reference
+type SamplerPreset struct {
+	ID          int64          `json:"id" db:"id"`
+	Name        string         `json:"name" db:"name"`
+	Description string         `json:"description" db:"description"`
+	Params      SamplingParams `json:"params" db:"params"`
+	CreatedAt   time.Time      `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at" db:"updated_at"`
+}
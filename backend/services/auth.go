@@ -0,0 +1,183 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/zucong/rp/db"
+	"github.com/zucong/rp/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Passwords are stored as bcrypt hashes. A login hands the client a random
+// session token; only its SHA-256 is stored, so a leaked database can't be
+// used to take over sessions.
+
+// MinPasswordLength is the shortest password accepted for an account
+const MinPasswordLength = 8
+
+// ErrInvalidLogin is returned for an unknown user or a wrong password alike
+var ErrInvalidLogin = errors.New("invalid username or password")
+
+// ErrNoSession is returned for a token that is unknown or has expired
+var ErrNoSession = errors.New("session expired or invalid")
+
+// HashPassword checks a new password's length and returns its bcrypt hash
+func HashPassword(password string) (string, error) {
+	if len(password) < MinPasswordLength {
+		return "", fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// CreateUser adds an account with the given password
+func CreateUser(database *sqlx.DB, username, password string, isAdmin bool) (*models.User, error) {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return nil, errors.New("username is required")
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := database.Exec("INSERT INTO users (username, password_hash, is_admin) VALUES (?, ?, ?)", username, hash, isAdmin)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			return nil, fmt.Errorf("username %q is taken", username)
+		}
+		return nil, err
+	}
+	id, _ := result.LastInsertId()
+
+	var user models.User
+	if err := database.Get(&user, "SELECT * FROM users WHERE id = ?", id); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// Authenticate returns the account matching a username and password
+func Authenticate(database *sqlx.DB, username, password string) (*models.User, error) {
+	var user models.User
+	err := database.Get(&user, "SELECT * FROM users WHERE username = ?", strings.TrimSpace(username))
+	if err == sql.ErrNoRows {
+		// Compare against a dummy hash anyway so unknown usernames take
+		// as long as wrong passwords
+		_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, ErrInvalidLogin
+	}
+	if err != nil {
+		return nil, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return nil, ErrInvalidLogin
+	}
+	return &user, nil
+}
+
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
+
+// CreateSession starts a session for a user and returns its token. Expired
+// sessions are cleared out at the same time.
+func CreateSession(database *sqlx.DB, userID int64, ttl time.Duration) (string, time.Time, error) {
+	token, err := RandomToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expires := time.Now().Add(ttl)
+
+	_, _ = database.Exec("DELETE FROM sessions WHERE expires_at <= ?", db.FormatTime(time.Now()))
+	_, err = database.Exec("INSERT INTO sessions (user_id, token_hash, expires_at) VALUES (?, ?, ?)",
+		userID, hashToken(token), db.FormatTime(expires))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expires, nil
+}
+
+// SessionUser returns the account a session token belongs to
+func SessionUser(database *sqlx.DB, token string) (*models.User, error) {
+	if token == "" {
+		return nil, ErrNoSession
+	}
+	var user models.User
+	err := database.Get(&user, `
+		SELECT u.* FROM sessions s
+		JOIN users u ON u.id = s.user_id
+		WHERE s.token_hash = ? AND s.expires_at > ?`,
+		hashToken(token), db.FormatTime(time.Now()))
+	if err == sql.ErrNoRows {
+		return nil, ErrNoSession
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// DeleteSession ends the session a token belongs to
+func DeleteSession(database *sqlx.DB, token string) error {
+	_, err := database.Exec("DELETE FROM sessions WHERE token_hash = ?", hashToken(token))
+	return err
+}
+
+// DeleteUserSessions ends every session of a user, for instance after a
+// password change
+func DeleteUserSessions(database sqlx.Execer, userID int64) error {
+	_, err := database.Exec("DELETE FROM sessions WHERE user_id = ?", userID)
+	return err
+}
+
+// RandomToken returns 32 random bytes encoded for use in URLs
+func RandomToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// BootstrapAdmin creates the admin account from config.yaml when there are
+// no accounts yet. Without one nobody could log in, so it is an error for
+// the database to have no accounts and the config no credentials.
+func BootstrapAdmin(database *sqlx.DB, username, password string) error {
+	var count int
+	if err := database.Get(&count, "SELECT COUNT(*) FROM users"); err != nil {
+		return err
+	}
+	if count > 0 {
+		if password != "" {
+			log.Printf("[Auth] Accounts exist, ignoring auth.admin_password; it can be removed from the config")
+		}
+		return nil
+	}
+	if username == "" || password == "" {
+		return errors.New("no user accounts exist: set auth.admin_username and auth.admin_password in the config to create the first admin")
+	}
+
+	user, err := CreateUser(database, username, password, true)
+	if err != nil {
+		return fmt.Errorf("failed to create admin account: %w", err)
+	}
+	log.Printf("[Auth] Created admin account %q", user.Username)
+	return nil
+}
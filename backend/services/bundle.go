@@ -0,0 +1,583 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/zucong/rp/db"
+	"github.com/zucong/rp/models"
+)
+
+// A room bundle is a zip archive that moves a room with everything it
+// depends on between instances. IDs inside a bundle are the exporting
+// instance's IDs and are remapped on import.
+
+const bundleVersion = 1
+
+// maxBundleEntrySize limits how far a single bundle file may decompress, so
+// a small archive can't expand into an unbounded allocation
+const maxBundleEntrySize = 512 << 20
+
+type BundleManifest struct {
+	Version     int       `json:"version"`
+	ExportedAt  time.Time `json:"exported_at"`
+	IncludeLogs bool      `json:"include_logs"`
+}
+
+type BundleCharacter struct {
+	models.Character
+	// Image is the avatar image kept from an imported character card
+	Image []byte `json:"image,omitempty"`
+}
+
+type BundleParticipant struct {
+	ID              int64  `json:"id" db:"id"`
+	CharacterID     int64  `json:"character_id" db:"character_id"`
+	ParticipantType string `json:"participant_type" db:"participant_type"`
+	IsUser          bool   `json:"is_user" db:"is_user"`
+	// CharacterVersion refers to a version in character_versions.json;
+	// 0 follows the latest version
+	CharacterVersion int `json:"character_version" db:"character_version"`
+	// Accounts differ between instances, so the assigned account is
+	// matched by username on import and left unassigned if there is none
+	UserID              int64     `json:"user_id" db:"user_id"`
+	Username            string    `json:"username" db:"username"`
+	ModelOverride       string    `json:"model_override" db:"model_override"`
+	TemperatureOverride *float64  `json:"temperature_override" db:"temperature_override"`
+	MaxTokensOverride   *int      `json:"max_tokens_override" db:"max_tokens_override"`
+	ExtraPrompt         string    `json:"extra_prompt" db:"extra_prompt"`
+	Nickname            string    `json:"nickname" db:"nickname"`
+	CreatedAt           time.Time `json:"created_at" db:"created_at"`
+}
+
+type BundleMessage struct {
+	ID            int64     `json:"id" db:"id"`
+	ParticipantID int64     `json:"participant_id" db:"participant_id"`
+	Content       string    `json:"content" db:"content"`
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// BundlePromptTemplate is one of the room's own prompt templates
+type BundlePromptTemplate struct {
+	Kind      string    `json:"kind" db:"kind"`
+	Content   string    `json:"content" db:"content"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+type bundleFile struct {
+	name string
+	data interface{}
+}
+
+// BundleImportResult reports how a bundle was merged into this instance
+type BundleImportResult struct {
+	RoomID            int64           `json:"room_id"`
+	CharactersCreated []string        `json:"characters_created"`
+	CharactersReused  []string        `json:"characters_reused"`
+	CharacterIDMap    map[int64]int64 `json:"character_id_map"`
+	MessagesImported  int             `json:"messages_imported"`
+	LLMLogsImported   int             `json:"llm_logs_imported"`
+	DecisionsImported int             `json:"decisions_imported"`
+	SummariesImported int             `json:"summaries_imported"`
+}
+
+// CharacterContentHash identifies characters with identical definitions
+// regardless of their ID or timestamps
+func CharacterContentHash(c *models.Character) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%v\x00%v\x00%d\x00%d",
+		c.Name, c.Prompt, c.ModelName, c.IsUserPlayable, c.Temperature, c.MaxTokens, c.SamplerPresetID)
+	for _, field := range []string{
+		c.Description, c.Personality, c.Scenario,
+		c.ExampleDialogues, c.Greeting, c.PostHistoryInstructions,
+	} {
+		fmt.Fprintf(h, "\x00%s", field)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ExportBundle writes a room, its characters and the versions in use,
+// participants, messages, summaries, prompt templates and author's notes to
+// a zip archive.
+// includeLogs adds the room's LLM call logs and orchestrator decisions.
+func ExportBundle(database *sqlx.DB, roomID int64, includeLogs bool) ([]byte, error) {
+	var room models.Room
+	err := database.Get(&room, "SELECT id, name, description, setting, turn_policy, created_at, updated_at FROM rooms WHERE id = ?", roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	participants := []BundleParticipant{}
+	err = database.Select(&participants, `
+		SELECT rp.id, rp.character_id, rp.participant_type, rp.is_user, rp.character_version,
+			rp.user_id, COALESCE(u.username, '') AS username,
+			rp.model_override, rp.temperature_override, rp.max_tokens_override, rp.extra_prompt, rp.nickname, rp.created_at
+		FROM room_participants rp
+		LEFT JOIN users u ON u.id = rp.user_id
+		WHERE rp.room_id = ? ORDER BY rp.id ASC`, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	characters := []BundleCharacter{}
+	err = database.Select(&characters, `
+		SELECT * FROM characters
+		WHERE id IN (SELECT character_id FROM room_participants WHERE room_id = ?)
+		ORDER BY id ASC`, roomID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range characters {
+		_ = database.Get(&characters[i].Image, "SELECT image FROM character_images WHERE character_id = ?", characters[i].ID)
+	}
+
+	// Versions that participants are pinned to or that LLM calls used
+	versions := []models.CharacterVersion{}
+	err = database.Select(&versions, `
+		SELECT * FROM character_versions
+		WHERE (character_id, version) IN (
+			SELECT character_id, character_version FROM room_participants WHERE room_id = ?
+			UNION
+			SELECT character_id, character_version FROM llm_call_logs WHERE room_id = ?
+		)
+		ORDER BY character_id ASC, version ASC`, roomID, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := []BundleMessage{}
+	err = database.Select(&messages, `
+		SELECT id, participant_id, content, created_at, updated_at
+		FROM messages WHERE room_id = ? ORDER BY seq ASC`, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := []models.Summary{}
+	err = database.Select(&summaries, "SELECT * FROM summaries WHERE room_id = ? ORDER BY id ASC", roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	templates := []BundlePromptTemplate{}
+	err = database.Select(&templates, "SELECT kind, content, updated_at FROM prompt_templates WHERE room_id = ? ORDER BY kind ASC", roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The room's note and those of its characters
+	notes := []models.AuthorsNote{}
+	err = database.Select(&notes, `
+		SELECT * FROM authors_notes
+		WHERE (room_id = ? AND character_id = 0)
+			OR (room_id = 0 AND character_id IN (SELECT character_id FROM room_participants WHERE room_id = ?))
+		ORDER BY character_id ASC`, roomID, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	files := []bundleFile{
+		{"manifest.json", BundleManifest{Version: bundleVersion, ExportedAt: now, IncludeLogs: includeLogs}},
+		{"room.json", room},
+		{"characters.json", characters},
+		{"character_versions.json", versions},
+		{"participants.json", participants},
+		{"messages.json", messages},
+		{"summaries.json", summaries},
+		{"prompt_templates.json", templates},
+		{"authors_notes.json", notes},
+	}
+
+	if includeLogs {
+		logs := []models.LLMCallLog{}
+		err = database.Select(&logs, "SELECT * FROM llm_call_logs WHERE room_id = ? ORDER BY id ASC", roomID)
+		if err != nil {
+			return nil, err
+		}
+		decisions := []models.OrchestratorDecision{}
+		err = database.Select(&decisions, "SELECT * FROM orchestrator_decisions WHERE room_id = ? ORDER BY id ASC", roomID)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files,
+			bundleFile{"llm_call_logs.json", logs},
+			bundleFile{"orchestrator_decisions.json", decisions},
+		)
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: f.name, Method: zip.Deflate, Modified: now})
+		if err != nil {
+			return nil, err
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(f.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ImportBundle creates a new room from a bundle archive. Characters that
+// already exist with the same content hash, or failing that the same name,
+// are reused instead of duplicated.
+func ImportBundle(database *sqlx.DB, data []byte) (*BundleImportResult, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid bundle archive: %w", err)
+	}
+
+	readJSON := func(name string, v interface{}, required bool) error {
+		f, err := zr.Open(name)
+		if err != nil {
+			if required {
+				return fmt.Errorf("bundle is missing %s", name)
+			}
+			return nil
+		}
+		defer f.Close()
+		// The declared size is checked up front, and the read is capped in
+		// case the header understates it
+		if info, err := f.Stat(); err == nil && uint64(info.Size()) > maxBundleEntrySize {
+			return fmt.Errorf("%s is too large", name)
+		}
+		raw, err := io.ReadAll(io.LimitReader(f, maxBundleEntrySize+1))
+		if err != nil {
+			return err
+		}
+		if len(raw) > maxBundleEntrySize {
+			return fmt.Errorf("%s is too large", name)
+		}
+		if err := json.Unmarshal(raw, v); err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+		return nil
+	}
+
+	var manifest BundleManifest
+	var room models.Room
+	var characters []BundleCharacter
+	var versions []models.CharacterVersion
+	var participants []BundleParticipant
+	var messages []BundleMessage
+	var summaries []models.Summary
+	var templates []BundlePromptTemplate
+	var notes []models.AuthorsNote
+	var logs []models.LLMCallLog
+	var decisions []models.OrchestratorDecision
+	for _, f := range []struct {
+		name     string
+		v        interface{}
+		required bool
+	}{
+		{"manifest.json", &manifest, true},
+		{"room.json", &room, true},
+		{"characters.json", &characters, true},
+		{"character_versions.json", &versions, false},
+		{"participants.json", &participants, true},
+		{"messages.json", &messages, true},
+		{"summaries.json", &summaries, false},
+		{"prompt_templates.json", &templates, false},
+		{"authors_notes.json", &notes, false},
+		{"llm_call_logs.json", &logs, false},
+		{"orchestrator_decisions.json", &decisions, false},
+	} {
+		if err := readJSON(f.name, f.v, f.required); err != nil {
+			return nil, err
+		}
+	}
+	if manifest.Version > bundleVersion {
+		return nil, fmt.Errorf("bundle version %d is newer than supported version %d", manifest.Version, bundleVersion)
+	}
+
+	// Index existing characters for deduplication
+	var existing []models.Character
+	if err := database.Select(&existing, "SELECT * FROM characters ORDER BY id ASC"); err != nil {
+		return nil, err
+	}
+	byHash := make(map[string]int64)
+	byName := make(map[string]int64)
+	for i := range existing {
+		hash := CharacterContentHash(&existing[i])
+		if _, ok := byHash[hash]; !ok {
+			byHash[hash] = existing[i].ID
+		}
+		if _, ok := byName[existing[i].Name]; !ok {
+			byName[existing[i].Name] = existing[i].ID
+		}
+	}
+
+	tx, err := database.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result := &BundleImportResult{
+		CharactersCreated: []string{},
+		CharactersReused:  []string{},
+		CharacterIDMap:    make(map[int64]int64),
+	}
+
+	for _, ch := range characters {
+		// Sampler presets are local to the exporting instance
+		ch.SamplerPresetID = 0
+		if id, ok := byHash[CharacterContentHash(&ch.Character)]; ok {
+			result.CharacterIDMap[ch.ID] = id
+			result.CharactersReused = append(result.CharactersReused, ch.Name)
+			continue
+		}
+		if id, ok := byName[ch.Name]; ok {
+			result.CharacterIDMap[ch.ID] = id
+			result.CharactersReused = append(result.CharactersReused, ch.Name)
+			continue
+		}
+		res, err := tx.NamedExec(
+			`INSERT INTO characters (
+				name, avatar, prompt, description, personality, scenario, example_dialogues,
+				greeting, post_history_instructions, is_user_playable, model_name, temperature, max_tokens, sampler_preset_id
+			) VALUES (
+				:name, :avatar, :prompt, :description, :personality, :scenario, :example_dialogues,
+				:greeting, :post_history_instructions, :is_user_playable, :model_name, :temperature, :max_tokens, :sampler_preset_id
+			)`,
+			&ch.Character,
+		)
+		if err != nil {
+			return nil, err
+		}
+		id, _ := res.LastInsertId()
+		if _, err := SnapshotCharacter(tx, id); err != nil {
+			return nil, err
+		}
+		if len(ch.Image) > 0 {
+			if _, err := tx.Exec("INSERT INTO character_images (character_id, image) VALUES (?, ?)", id, ch.Image); err != nil {
+				return nil, err
+			}
+		}
+		result.CharacterIDMap[ch.ID] = id
+		result.CharactersCreated = append(result.CharactersCreated, ch.Name)
+	}
+
+	// Bundled versions map to an identical version of the imported or
+	// reused character, or are added to its history. The current
+	// definition is then snapshotted again so it stays the latest version.
+	type versionKey struct {
+		characterID int64
+		version     int
+	}
+	versionMap := make(map[versionKey]int)
+	appended := make(map[int64]bool)
+	for _, v := range versions {
+		charID, ok := result.CharacterIDMap[v.CharacterID]
+		if !ok {
+			continue
+		}
+		v.SamplerPresetID = 0
+		hash := CharacterContentHash(v.Character())
+
+		var local []models.CharacterVersion
+		err := tx.Select(&local, "SELECT * FROM character_versions WHERE character_id = ? ORDER BY version ASC", charID)
+		if err != nil {
+			return nil, err
+		}
+		version := 0
+		for i := range local {
+			c := local[i].Character()
+			c.SamplerPresetID = 0
+			if CharacterContentHash(c) == hash {
+				version = local[i].Version
+				break
+			}
+		}
+		if version == 0 {
+			version = 1
+			if len(local) > 0 {
+				version = local[len(local)-1].Version + 1
+			}
+			_, err := tx.Exec(`
+				INSERT INTO character_versions (
+					character_id, version, name, avatar, prompt, description, personality, scenario,
+					example_dialogues, greeting, post_history_instructions, is_user_playable,
+					model_name, temperature, max_tokens, sampler_preset_id, created_at
+				) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 0, ?)`,
+				charID, version, v.Name, v.Avatar, v.Prompt, v.Description, v.Personality, v.Scenario,
+				v.ExampleDialogues, v.Greeting, v.PostHistoryInstructions, v.IsUserPlayable,
+				v.ModelName, v.Temperature, v.MaxTokens, db.FormatTime(v.CreatedAt))
+			if err != nil {
+				return nil, err
+			}
+			appended[charID] = true
+		}
+		versionMap[versionKey{v.CharacterID, v.Version}] = version
+	}
+	for charID := range appended {
+		if _, err := SnapshotCharacter(tx, charID); err != nil {
+			return nil, err
+		}
+	}
+
+	switch room.TurnPolicy {
+	case "":
+		room.TurnPolicy = models.TurnPolicyQueue
+	case models.TurnPolicyQueue, models.TurnPolicyPreempt:
+	default:
+		return nil, fmt.Errorf("invalid turn policy %q", room.TurnPolicy)
+	}
+	res, err := tx.Exec(`
+		INSERT INTO rooms (name, description, setting, turn_policy, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		room.Name, room.Description, room.Setting, room.TurnPolicy, db.FormatTime(room.CreatedAt), db.FormatTime(room.UpdatedAt))
+	if err != nil {
+		return nil, err
+	}
+	result.RoomID, _ = res.LastInsertId()
+
+	participantMap := make(map[int64]int64)
+	for _, p := range participants {
+		charID, ok := result.CharacterIDMap[p.CharacterID]
+		if !ok {
+			return nil, fmt.Errorf("participant %d references unknown character %d", p.ID, p.CharacterID)
+		}
+		// A version missing from the bundle falls back to the latest
+		version := versionMap[versionKey{p.CharacterID, p.CharacterVersion}]
+		var userID int64
+		if p.Username != "" {
+			_ = tx.Get(&userID, "SELECT id FROM users WHERE username = ?", p.Username)
+		}
+		res, err := tx.Exec(`
+			INSERT INTO room_participants (
+				room_id, character_id, participant_type, is_user, character_version, user_id,
+				model_override, temperature_override, max_tokens_override, extra_prompt, nickname, created_at
+			) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			result.RoomID, charID, p.ParticipantType, p.IsUser, version, userID,
+			p.ModelOverride, p.TemperatureOverride, p.MaxTokensOverride, p.ExtraPrompt, p.Nickname, db.FormatTime(p.CreatedAt))
+		if err != nil {
+			return nil, err
+		}
+		participantMap[p.ID], _ = res.LastInsertId()
+	}
+
+	// Messages are inserted in bundle order, which numbers them in that order
+	messageMap := make(map[int64]int64)
+	for _, m := range messages {
+		pid, ok := participantMap[m.ParticipantID]
+		if !ok {
+			return nil, fmt.Errorf("message %d references unknown participant %d", m.ID, m.ParticipantID)
+		}
+		res, err := tx.Exec(`
+			INSERT INTO messages (room_id, seq, participant_id, content, created_at, updated_at)
+			VALUES (?, `+db.NextMessageSeq+`, ?, ?, ?, ?)`,
+			result.RoomID, result.RoomID, pid, m.Content, db.FormatTime(m.CreatedAt), db.FormatTime(m.UpdatedAt))
+		if err != nil {
+			return nil, err
+		}
+		messageMap[m.ID], _ = res.LastInsertId()
+		result.MessagesImported++
+	}
+
+	for _, s := range summaries {
+		_, err := tx.Exec(`
+			INSERT INTO summaries (room_id, content, message_from, message_to, created_at)
+			VALUES (?, ?, ?, ?, ?)`,
+			result.RoomID, s.Content, messageMap[s.MsgFrom], messageMap[s.MsgTo], db.FormatTime(s.CreatedAt))
+		if err != nil {
+			return nil, err
+		}
+		result.SummariesImported++
+	}
+
+	for _, t := range templates {
+		if !slices.Contains(PromptKinds, t.Kind) {
+			continue
+		}
+		_, err := tx.Exec(`
+			INSERT INTO prompt_templates (room_id, kind, content, updated_at)
+			VALUES (?, ?, ?, ?)`,
+			result.RoomID, t.Kind, t.Content, db.FormatTime(t.UpdatedAt))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// A reused character keeps the note it already has here
+	for _, n := range notes {
+		roomID, charID := result.RoomID, int64(0)
+		if n.CharacterID != 0 {
+			var ok bool
+			if charID, ok = result.CharacterIDMap[n.CharacterID]; !ok {
+				continue
+			}
+			roomID = 0
+		}
+		_, err := tx.Exec(`
+			INSERT INTO authors_notes (room_id, character_id, content, depth, role, frequency, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(room_id, character_id) DO NOTHING`,
+			roomID, charID, n.Content, n.Depth, n.Role, n.Frequency, db.FormatTime(n.CreatedAt), db.FormatTime(n.UpdatedAt))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	logMap := make(map[int64]int64)
+	for _, l := range logs {
+		msgID, ok := messageMap[l.MessageID]
+		if !ok {
+			continue
+		}
+		res, err := tx.Exec(`
+			INSERT INTO llm_call_logs (
+				message_id, room_id, call_type, model_name, temperature, max_tokens,
+				request_body, response_body, prompt_tokens, completion_tokens,
+				latency_ms, error_message, character_id, character_version, created_at
+			) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			msgID, result.RoomID, l.CallType, l.ModelName, l.Temperature, l.MaxTokens,
+			l.RequestBody, l.ResponseBody, l.PromptTokens, l.CompletionTokens,
+			l.LatencyMs, l.ErrorMessage, result.CharacterIDMap[l.CharacterID],
+			versionMap[versionKey{l.CharacterID, l.CharacterVersion}], db.FormatTime(l.CreatedAt))
+		if err != nil {
+			return nil, err
+		}
+		logMap[l.ID], _ = res.LastInsertId()
+		result.LLMLogsImported++
+	}
+
+	for _, d := range decisions {
+		msgID, ok := messageMap[d.MessageID]
+		if !ok {
+			continue
+		}
+		_, err := tx.Exec(`
+			INSERT INTO orchestrator_decisions (
+				message_id, room_id, step_order, step_type,
+				input_data, output_data, llm_call_log_id, reason, created_at
+			) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			msgID, result.RoomID, d.StepOrder, d.StepType,
+			d.InputData, d.OutputData, logMap[d.LLMCallLogID], d.Reason, db.FormatTime(d.CreatedAt))
+		if err != nil {
+			return nil, err
+		}
+		result.DecisionsImported++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
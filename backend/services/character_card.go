@@ -0,0 +1,211 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strings"
+)
+
+// Character cards are the community TavernAI / SillyTavern format for sharing
+// characters: a JSON document, usually embedded in a PNG tEXt chunk under the
+// "chara" keyword as base64.
+
+const cardPNGKeyword = "chara"
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// CardData holds the character fields shared by V1 cards and the data
+// section of V2 cards
+type CardData struct {
+	Name                    string                 `json:"name"`
+	Description             string                 `json:"description"`
+	Personality             string                 `json:"personality"`
+	Scenario                string                 `json:"scenario"`
+	FirstMes                string                 `json:"first_mes"`
+	MesExample              string                 `json:"mes_example"`
+	CreatorNotes            string                 `json:"creator_notes,omitempty"`
+	SystemPrompt            string                 `json:"system_prompt,omitempty"`
+	PostHistoryInstructions string                 `json:"post_history_instructions,omitempty"`
+	AlternateGreetings      []string               `json:"alternate_greetings,omitempty"`
+	Tags                    []string               `json:"tags,omitempty"`
+	Creator                 string                 `json:"creator,omitempty"`
+	CharacterVersion        string                 `json:"character_version,omitempty"`
+	Extensions              map[string]interface{} `json:"extensions,omitempty"`
+}
+
+// CardV2 is the chara_card_v2 envelope
+type CardV2 struct {
+	Spec        string   `json:"spec"`
+	SpecVersion string   `json:"spec_version"`
+	Data        CardData `json:"data"`
+}
+
+// ParseCard decodes a V1 or V2 card from JSON
+func ParseCard(raw []byte) (*CardData, error) {
+	var envelope struct {
+		Spec string          `json:"spec"`
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(raw, &envelope); err != nil {
+		return nil, fmt.Errorf("invalid card JSON: %w", err)
+	}
+
+	var card CardData
+	if strings.HasPrefix(envelope.Spec, "chara_card_v") && len(envelope.Data) > 0 {
+		if err := json.Unmarshal(envelope.Data, &card); err != nil {
+			return nil, fmt.Errorf("invalid card data: %w", err)
+		}
+	} else if err := json.Unmarshal(raw, &card); err != nil {
+		return nil, fmt.Errorf("invalid card JSON: %w", err)
+	}
+
+	if card.Name == "" {
+		return nil, errors.New("card has no name")
+	}
+	return &card, nil
+}
+
+// ReadCardPNG extracts the card JSON from a PNG's "chara" tEXt chunk
+func ReadCardPNG(data []byte) ([]byte, error) {
+	var found []byte
+	err := walkPNGChunks(data, func(typ string, body []byte) {
+		if typ != "tEXt" {
+			return
+		}
+		keyword, text, ok := bytes.Cut(body, []byte{0})
+		if ok && string(keyword) == cardPNGKeyword {
+			found = text
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, errors.New("PNG has no character card data")
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(string(found))
+	if err != nil {
+		return nil, fmt.Errorf("invalid card encoding: %w", err)
+	}
+	return decoded, nil
+}
+
+// WriteCardPNG returns a copy of img with cardJSON embedded as a "chara"
+// tEXt chunk, replacing any card already present. If img is empty a
+// placeholder image is generated from seed.
+func WriteCardPNG(img []byte, cardJSON []byte, seed string) ([]byte, error) {
+	if len(img) == 0 {
+		var err error
+		img, err = placeholderPNG(seed)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	text := append([]byte(cardPNGKeyword+"\x00"), base64.StdEncoding.EncodeToString(cardJSON)...)
+
+	var out bytes.Buffer
+	out.Write(pngSignature)
+	err := walkPNGChunks(img, func(typ string, body []byte) {
+		if typ == "tEXt" {
+			keyword, _, _ := bytes.Cut(body, []byte{0})
+			if string(keyword) == cardPNGKeyword {
+				return
+			}
+		}
+		if typ == "IEND" {
+			writePNGChunk(&out, "tEXt", text)
+		}
+		writePNGChunk(&out, typ, body)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// IsPNG reports whether data starts with the PNG signature
+func IsPNG(data []byte) bool {
+	return bytes.HasPrefix(data, pngSignature)
+}
+
+func walkPNGChunks(data []byte, fn func(typ string, body []byte)) error {
+	if !IsPNG(data) {
+		return errors.New("not a PNG file")
+	}
+	r := bytes.NewReader(data[len(pngSignature):])
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("truncated PNG: %w", err)
+		}
+		length := binary.BigEndian.Uint32(header[:4])
+		if int64(length) > int64(r.Len()) {
+			return errors.New("truncated PNG chunk")
+		}
+		body := make([]byte, length)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return fmt.Errorf("truncated PNG: %w", err)
+		}
+		var crc [4]byte
+		if _, err := io.ReadFull(r, crc[:]); err != nil {
+			return fmt.Errorf("truncated PNG: %w", err)
+		}
+		typ := string(header[4:])
+		fn(typ, body)
+		if typ == "IEND" {
+			return nil
+		}
+	}
+}
+
+func writePNGChunk(w *bytes.Buffer, typ string, body []byte) {
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(body)))
+	w.Write(length[:])
+	w.WriteString(typ)
+	w.Write(body)
+
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(typ))
+	crc.Write(body)
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc.Sum32())
+	w.Write(sum[:])
+}
+
+// placeholderPNG renders a solid card-sized image whose colour is derived
+// from seed, for characters that have no avatar image
+func placeholderPNG(seed string) ([]byte, error) {
+	h := fnv.New32a()
+	h.Write([]byte(seed))
+	sum := h.Sum32()
+	fill := color.RGBA{R: uint8(sum>>16) | 0x40, G: uint8(sum>>8) | 0x40, B: uint8(sum) | 0x40, A: 0xff}
+
+	img := image.NewRGBA(image.Rect(0, 0, 400, 600))
+	for y := 0; y < 600; y++ {
+		for x := 0; x < 400; x++ {
+			img.SetRGBA(x, y, fill)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,196 @@
+package services
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/zucong/rp/models"
+)
+
+// Character versions are immutable snapshots of a character's definition.
+// Every create or update that changes the definition appends a version;
+// characters.version points at the latest one. Room participants either
+// follow the latest version or are pinned to a specific one.
+
+// characterVersionColumns are the character columns copied into each version
+const characterVersionColumns = `name, avatar, prompt, description, personality, scenario,
+	example_dialogues, greeting, post_history_instructions, is_user_playable,
+	model_name, temperature, max_tokens, sampler_preset_id`
+
+// EffectiveVersionJoin joins the character version a room participant (rp)
+// of character c uses as cv: its pinned version, or the character's latest
+const EffectiveVersionJoin = `JOIN character_versions cv ON cv.character_id = rp.character_id
+		AND cv.version = COALESCE(NULLIF(rp.character_version, 0), c.version)`
+
+// ParticipantName is a participant's name within its room: the room
+// nickname if set, otherwise the name of the character version it uses.
+// Queries selecting it need EffectiveVersionJoin.
+const ParticipantName = "COALESCE(NULLIF(rp.nickname, ''), cv.name)"
+
+// SnapshotCharacter records the character's current definition as a new
+// version, unless it is identical to the latest version. It returns the
+// version number the character is now at.
+func SnapshotCharacter(q sqlx.Ext, characterID int64) (int, error) {
+	var current models.Character
+	if err := sqlx.Get(q, &current, "SELECT * FROM characters WHERE id = ?", characterID); err != nil {
+		return 0, err
+	}
+
+	var latest models.CharacterVersion
+	err := sqlx.Get(q, &latest, `
+		SELECT * FROM character_versions
+		WHERE character_id = ?
+		ORDER BY version DESC LIMIT 1`, characterID)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return 0, err
+	default:
+		if CharacterContentHash(latest.Character()) == CharacterContentHash(&current) {
+			if current.Version != latest.Version {
+				_, err := q.Exec("UPDATE characters SET version = ? WHERE id = ?", latest.Version, characterID)
+				if err != nil {
+					return 0, err
+				}
+			}
+			return latest.Version, nil
+		}
+	}
+
+	version := latest.Version + 1
+	_, err = q.Exec(`
+		INSERT INTO character_versions (character_id, version, `+characterVersionColumns+`)
+		SELECT id, ?, `+characterVersionColumns+`
+		FROM characters WHERE id = ?`, version, characterID)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := q.Exec("UPDATE characters SET version = ? WHERE id = ?", version, characterID); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// GetCharacterVersion loads one version of a character. Version 0 means the
+// latest version.
+func GetCharacterVersion(q sqlx.Queryer, characterID int64, version int) (*models.CharacterVersion, error) {
+	var v models.CharacterVersion
+	var err error
+	if version > 0 {
+		err = sqlx.Get(q, &v, "SELECT * FROM character_versions WHERE character_id = ? AND version = ?", characterID, version)
+	} else {
+		err = sqlx.Get(q, &v, "SELECT * FROM character_versions WHERE character_id = ? ORDER BY version DESC LIMIT 1", characterID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// FieldDiff describes how one field changed between two versions. Text
+// fields spanning several lines also carry a line diff.
+type FieldDiff struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+	Lines []DiffLine  `json:"lines,omitempty"`
+}
+
+// DiffLine is one line of a line diff; Op is "=", "-" or "+"
+type DiffLine struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// DiffCharacterVersions lists the fields that differ between two versions
+func DiffCharacterVersions(from, to *models.CharacterVersion) []FieldDiff {
+	diffs := []FieldDiff{}
+	for _, f := range []struct {
+		name     string
+		from, to string
+	}{
+		{"name", from.Name, to.Name},
+		{"avatar", from.Avatar, to.Avatar},
+		{"prompt", from.Prompt, to.Prompt},
+		{"description", from.Description, to.Description},
+		{"personality", from.Personality, to.Personality},
+		{"scenario", from.Scenario, to.Scenario},
+		{"example_dialogues", from.ExampleDialogues, to.ExampleDialogues},
+		{"greeting", from.Greeting, to.Greeting},
+		{"post_history_instructions", from.PostHistoryInstructions, to.PostHistoryInstructions},
+		{"model_name", from.ModelName, to.ModelName},
+	} {
+		if f.from == f.to {
+			continue
+		}
+		d := FieldDiff{Field: f.name, From: f.from, To: f.to}
+		if strings.Contains(f.from, "\n") || strings.Contains(f.to, "\n") {
+			d.Lines = diffLines(f.from, f.to)
+		}
+		diffs = append(diffs, d)
+	}
+
+	if from.IsUserPlayable != to.IsUserPlayable {
+		diffs = append(diffs, FieldDiff{Field: "is_user_playable", From: from.IsUserPlayable, To: to.IsUserPlayable})
+	}
+	if from.Temperature != to.Temperature {
+		diffs = append(diffs, FieldDiff{Field: "temperature", From: from.Temperature, To: to.Temperature})
+	}
+	if from.MaxTokens != to.MaxTokens {
+		diffs = append(diffs, FieldDiff{Field: "max_tokens", From: from.MaxTokens, To: to.MaxTokens})
+	}
+	if from.SamplerPresetID != to.SamplerPresetID {
+		diffs = append(diffs, FieldDiff{Field: "sampler_preset_id", From: from.SamplerPresetID, To: to.SamplerPresetID})
+	}
+	return diffs
+}
+
+// diffLines computes a line diff from the longest common subsequence
+func diffLines(a, b string) []DiffLine {
+	split := func(s string) []string {
+		if s == "" {
+			return nil
+		}
+		return strings.Split(s, "\n")
+	}
+	x, y := split(a), split(b)
+
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var lines []DiffLine
+	i, j := 0, 0
+	for i < len(x) && j < len(y) {
+		switch {
+		case x[i] == y[j]:
+			lines = append(lines, DiffLine{"=", x[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, DiffLine{"-", x[i]})
+			i++
+		default:
+			lines = append(lines, DiffLine{"+", y[j]})
+			j++
+		}
+	}
+	for ; i < len(x); i++ {
+		lines = append(lines, DiffLine{"-", x[i]})
+	}
+	for ; j < len(y); j++ {
+		lines = append(lines, DiffLine{"+", y[j]})
+	}
+	return lines
+}
@@ -0,0 +1,495 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/zucong/rp/db"
+)
+
+// Chats from other frontends are converted into an ImportedChat before being
+// written, so every format shares the same room/participant/message mapping.
+// Fields a format carries that have no place here are counted per field path
+// and reported back instead of being dropped silently.
+
+type ImportedChat struct {
+	Format   string
+	RoomName string
+	Messages []ImportedMessage
+	// Unmapped counts occurrences of source fields that were not imported,
+	// keyed by path (e.g. "message.extra")
+	Unmapped map[string]int
+}
+
+type ImportedMessage struct {
+	Speaker   string
+	IsUser    bool
+	Content   string
+	CreatedAt time.Time
+	// Swipes holds every alternative response including Content
+	Swipes     []string
+	SwipeIndex int
+}
+
+// ChatImportResult reports what an import created
+type ChatImportResult struct {
+	RoomID            int64          `json:"room_id"`
+	Format            string         `json:"format"`
+	CharactersCreated []string       `json:"characters_created"`
+	CharactersReused  []string       `json:"characters_reused"`
+	MessagesImported  int            `json:"messages_imported"`
+	SwipesImported    int            `json:"swipes_imported"`
+	MessagesSkipped   int            `json:"messages_skipped"`
+	UnmappedFields    map[string]int `json:"unmapped_fields"`
+}
+
+// ParseChat detects the export format of data and converts it. format may be
+// "sillytavern", "agnai" or "risuai"; an empty format is detected.
+func ParseChat(data []byte, format string) (*ImportedChat, error) {
+	data = bytes.TrimPrefix(bytes.TrimSpace(data), []byte("\xef\xbb\xbf"))
+	if len(data) == 0 {
+		return nil, errors.New("chat file is empty")
+	}
+
+	if format == "" {
+		format = detectChatFormat(data)
+	}
+
+	var chat *ImportedChat
+	var err error
+	switch format {
+	case "sillytavern":
+		chat, err = parseSillyTavernChat(data)
+	case "agnai":
+		chat, err = parseAgnaiChat(data)
+	case "risuai":
+		chat, err = parseRisuChat(data)
+	default:
+		return nil, errors.New("unrecognized chat format; expected SillyTavern .jsonl, Agnai or RisuAI export")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(chat.Messages) == 0 {
+		return nil, errors.New("chat has no messages")
+	}
+	chat.Format = format
+	return chat, nil
+}
+
+func detectChatFormat(data []byte) string {
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(data, &probe); err != nil {
+		// Not a single JSON document; SillyTavern writes one object per line
+		return "sillytavern"
+	}
+	if t, ok := probe["type"]; ok && string(t) == `"risuChat"` {
+		return "risuai"
+	}
+	if _, ok := probe["messages"]; ok {
+		return "agnai"
+	}
+	if _, ok := probe["user_name"]; ok {
+		// A .jsonl file holding only the metadata line
+		return "sillytavern"
+	}
+	return ""
+}
+
+// fieldSet decodes a JSON object and tracks which of its keys were consumed
+type fieldSet map[string]json.RawMessage
+
+func (f fieldSet) str(key string) string {
+	var s string
+	if raw, ok := f[key]; ok {
+		json.Unmarshal(raw, &s)
+		delete(f, key)
+	}
+	return s
+}
+
+func (f fieldSet) boolean(key string) bool {
+	var b bool
+	if raw, ok := f[key]; ok {
+		json.Unmarshal(raw, &b)
+		delete(f, key)
+	}
+	return b
+}
+
+func (f fieldSet) decode(key string, v interface{}) bool {
+	raw, ok := f[key]
+	if !ok {
+		return false
+	}
+	delete(f, key)
+	return json.Unmarshal(raw, v) == nil
+}
+
+// ignore drops keys that carry nothing worth reporting
+func (f fieldSet) ignore(keys ...string) {
+	for _, k := range keys {
+		delete(f, k)
+	}
+}
+
+func (f fieldSet) report(prefix string, unmapped map[string]int) {
+	for k, raw := range f {
+		if s := strings.TrimSpace(string(raw)); s == "null" || s == `""` || s == "{}" || s == "[]" {
+			continue
+		}
+		unmapped[prefix+"."+k]++
+	}
+}
+
+// parseChatTime accepts the timestamp formats seen in exports: RFC3339,
+// epoch milliseconds and SillyTavern's "January 2, 2006 3:04pm" style
+func parseChatTime(raw json.RawMessage) (time.Time, bool) {
+	var n float64
+	if err := json.Unmarshal(raw, &n); err == nil && n > 0 {
+		if n < 1e11 {
+			return time.Unix(int64(n), 0), true
+		}
+		return time.UnixMilli(int64(n)), true
+	}
+
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil || s == "" {
+		return time.Time{}, false
+	}
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return parseChatTime(json.RawMessage(strconv.FormatInt(ms, 10)))
+	}
+	// Humanized dates end in a millisecond field the layouts can't express
+	if i := strings.LastIndex(s, " "); i > 0 && strings.HasSuffix(s, "ms") {
+		s = s[:i]
+	}
+	for _, layout := range []string{
+		time.RFC3339Nano,
+		"January 2, 2006 3:04pm",
+		"January 2, 2006 3:04 pm",
+		"2006-1-2 @15h 4m 5s",
+		"2006-01-02 15:04:05",
+	} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// parseSillyTavernChat reads a SillyTavern .jsonl chat: a metadata line
+// followed by one line per message
+func parseSillyTavernChat(data []byte) (*ImportedChat, error) {
+	chat := &ImportedChat{Unmapped: make(map[string]int)}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		var f fieldSet
+		if err := json.Unmarshal(text, &f); err != nil {
+			return nil, fmt.Errorf("line %d: invalid JSON: %w", line, err)
+		}
+
+		if _, isMessage := f["mes"]; !isMessage {
+			userName := f.str("user_name")
+			charName := f.str("character_name")
+			if charName != "" {
+				chat.RoomName = charName
+				if userName != "" {
+					chat.RoomName = fmt.Sprintf("%s & %s", userName, charName)
+				}
+			}
+			f.ignore("create_date")
+			f.report("metadata", chat.Unmapped)
+			continue
+		}
+
+		msg := ImportedMessage{
+			Speaker: f.str("name"),
+			IsUser:  f.boolean("is_user"),
+			Content: f.str("mes"),
+		}
+		isSystem := f.boolean("is_system")
+		if raw, ok := f["send_date"]; ok {
+			msg.CreatedAt, _ = parseChatTime(raw)
+			delete(f, "send_date")
+		}
+		f.decode("swipes", &msg.Swipes)
+		f.decode("swipe_id", &msg.SwipeIndex)
+		f.ignore("swipe_info", "force_avatar", "original_avatar", "gen_started", "gen_finished")
+		if isSystem {
+			// Hidden or narrator messages have no participant to map to
+			chat.Unmapped["message.is_system"]++
+			continue
+		}
+		f.report("message", chat.Unmapped)
+		chat.Messages = append(chat.Messages, msg)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return chat, nil
+}
+
+// parseAgnaiChat reads an Agnai chat export. Agnai identifies speakers by
+// character and user IDs; the export's "characters" map, when present,
+// resolves them to names.
+func parseAgnaiChat(data []byte) (*ImportedChat, error) {
+	var f fieldSet
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("invalid Agnai export: %w", err)
+	}
+	chat := &ImportedChat{Unmapped: make(map[string]int), RoomName: f.str("name")}
+
+	names := make(map[string]string)
+	var characters map[string]struct {
+		Name string `json:"name"`
+	}
+	if f.decode("characters", &characters) {
+		for id, c := range characters {
+			names[id] = c.Name
+		}
+	}
+	charName := f.str("characterName")
+
+	var messages []fieldSet
+	if !f.decode("messages", &messages) {
+		return nil, errors.New("invalid Agnai export: messages must be a list")
+	}
+	f.ignore("_id", "kind", "userId", "characterId")
+	f.report("chat", chat.Unmapped)
+
+	for _, m := range messages {
+		msg := ImportedMessage{Content: m.str("msg")}
+		charID := m.str("characterId")
+		userID := m.str("userId")
+		handle := m.str("handle")
+		switch {
+		case userID != "":
+			msg.IsUser = true
+			msg.Speaker = handle
+		case names[charID] != "":
+			msg.Speaker = names[charID]
+		case handle != "":
+			msg.Speaker = handle
+		default:
+			msg.Speaker = charName
+		}
+		if msg.Speaker == "" {
+			msg.Speaker = "Character"
+			if msg.IsUser {
+				msg.Speaker = "User"
+			}
+		}
+		if raw, ok := m["createdAt"]; ok {
+			msg.CreatedAt, _ = parseChatTime(raw)
+			delete(m, "createdAt")
+		}
+		var retries []string
+		if m.decode("retries", &retries) && len(retries) > 0 {
+			msg.Swipes = append([]string{msg.Content}, retries...)
+		}
+		m.ignore("_id", "chatId", "kind", "updatedAt")
+		m.report("message", chat.Unmapped)
+		chat.Messages = append(chat.Messages, msg)
+	}
+	return chat, nil
+}
+
+// parseRisuChat reads a RisuAI chat export ({"type": "risuChat", "data": {...}})
+func parseRisuChat(data []byte) (*ImportedChat, error) {
+	var envelope fieldSet
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, fmt.Errorf("invalid RisuAI export: %w", err)
+	}
+	var f fieldSet
+	if !envelope.decode("data", &f) || f == nil {
+		return nil, errors.New("invalid RisuAI export: missing data")
+	}
+	chat := &ImportedChat{Unmapped: make(map[string]int), RoomName: f.str("name")}
+	envelope.ignore("type", "ver")
+	envelope.report("export", chat.Unmapped)
+
+	var messages []fieldSet
+	if !f.decode("message", &messages) {
+		return nil, errors.New("invalid RisuAI export: message must be a list")
+	}
+	f.ignore("id", "chatId")
+	f.report("chat", chat.Unmapped)
+
+	for _, m := range messages {
+		msg := ImportedMessage{
+			Content: m.str("data"),
+			IsUser:  m.str("role") == "user",
+			Speaker: m.str("name"),
+		}
+		if msg.Speaker == "" {
+			msg.Speaker = m.str("saying")
+		}
+		if msg.Speaker == "" {
+			msg.Speaker = "Character"
+			if msg.IsUser {
+				msg.Speaker = "User"
+			}
+		}
+		m.ignore("saying", "chatId")
+		if raw, ok := m["time"]; ok {
+			msg.CreatedAt, _ = parseChatTime(raw)
+			delete(m, "time")
+		}
+		m.report("message", chat.Unmapped)
+		chat.Messages = append(chat.Messages, msg)
+	}
+	return chat, nil
+}
+
+// ImportChat creates a room for chat. Speakers are matched to existing
+// characters by name; unknown speakers become new characters. Messages
+// without a timestamp are placed one second after the previous one.
+func ImportChat(database *sqlx.DB, chat *ImportedChat, roomName string) (*ChatImportResult, error) {
+	if roomName == "" {
+		roomName = chat.RoomName
+	}
+	if roomName == "" {
+		roomName = "Imported chat"
+	}
+
+	result := &ChatImportResult{
+		Format:            chat.Format,
+		CharactersCreated: []string{},
+		CharactersReused:  []string{},
+		UnmappedFields:    chat.Unmapped,
+	}
+
+	tx, err := database.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	first := time.Now()
+	for _, m := range chat.Messages {
+		if !m.CreatedAt.IsZero() {
+			first = m.CreatedAt
+			break
+		}
+	}
+
+	res, err := tx.Exec(`
+		INSERT INTO rooms (name, description, created_at, updated_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP)`,
+		roomName, fmt.Sprintf("Imported from %s", chat.Format), db.FormatTime(first))
+	if err != nil {
+		return nil, err
+	}
+	result.RoomID, _ = res.LastInsertId()
+
+	participants := make(map[string]int64)
+	participantFor := func(m ImportedMessage) (int64, error) {
+		if id, ok := participants[m.Speaker]; ok {
+			return id, nil
+		}
+
+		var charID int64
+		err := tx.Get(&charID, "SELECT id FROM characters WHERE name = ? ORDER BY id ASC LIMIT 1", m.Speaker)
+		if err == nil {
+			result.CharactersReused = append(result.CharactersReused, m.Speaker)
+		} else {
+			res, err := tx.Exec(
+				"INSERT INTO characters (name, prompt, is_user_playable) VALUES (?, ?, ?)",
+				m.Speaker, "", m.IsUser,
+			)
+			if err != nil {
+				return 0, err
+			}
+			charID, _ = res.LastInsertId()
+			if _, err := SnapshotCharacter(tx, charID); err != nil {
+				return 0, err
+			}
+			result.CharactersCreated = append(result.CharactersCreated, m.Speaker)
+		}
+
+		participantType := "ai"
+		if m.IsUser {
+			participantType = "human"
+		}
+		res, err := tx.Exec(`
+			INSERT INTO room_participants (room_id, character_id, participant_type, is_user, created_at)
+			VALUES (?, ?, ?, ?, ?)`,
+			result.RoomID, charID, participantType, m.IsUser, db.FormatTime(first))
+		if err != nil {
+			return 0, err
+		}
+		id, _ := res.LastInsertId()
+		participants[m.Speaker] = id
+		return id, nil
+	}
+
+	last := first
+	for _, m := range chat.Messages {
+		if strings.TrimSpace(m.Content) == "" && len(m.Swipes) == 0 {
+			result.MessagesSkipped++
+			continue
+		}
+		pid, err := participantFor(m)
+		if err != nil {
+			return nil, err
+		}
+
+		createdAt := m.CreatedAt
+		if createdAt.IsZero() {
+			createdAt = last.Add(time.Second)
+		}
+		last = createdAt
+
+		content := m.Content
+		if m.SwipeIndex >= 0 && m.SwipeIndex < len(m.Swipes) {
+			content = m.Swipes[m.SwipeIndex]
+		}
+
+		res, err := tx.Exec(`
+			INSERT INTO messages (room_id, seq, participant_id, content, created_at, updated_at)
+			VALUES (?, `+db.NextMessageSeq+`, ?, ?, ?, ?)`,
+			result.RoomID, result.RoomID, pid, content, db.FormatTime(createdAt), db.FormatTime(createdAt))
+		if err != nil {
+			return nil, err
+		}
+		msgID, _ := res.LastInsertId()
+		result.MessagesImported++
+
+		if len(m.Swipes) > 1 {
+			for i, swipe := range m.Swipes {
+				_, err := tx.Exec(`
+					INSERT INTO message_swipes (message_id, swipe_index, content, is_selected, created_at)
+					VALUES (?, ?, ?, ?, ?)`,
+					msgID, i, swipe, i == m.SwipeIndex, db.FormatTime(createdAt))
+				if err != nil {
+					return nil, err
+				}
+				result.SwipesImported++
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	sort.Strings(result.CharactersCreated)
+	sort.Strings(result.CharactersReused)
+	return result, nil
+}
@@ -124,6 +124,37 @@ func (dr *DecisionRecorder) RecordResponseGeneration(characterID int64, characte
 		"Generated AI response for character")
 }
 
+// NoteInsertion describes where an author's note went in a character's
+// context, or why it was left out
+type NoteInsertion struct {
+	NoteID int64 `json:"note_id"`
+	// Scope is "room" or "character"
+	Scope string `json:"scope"`
+	Depth int    `json:"depth"`
+	Role  string `json:"role"`
+	// Index is the note's position among the conversation messages that
+	// follow the system prompt, or -1 when the note was skipped this turn
+	Index int `json:"index"`
+	Turn  int `json:"turn"`
+	// Frequency is how often, in user turns, the note is inserted
+	Frequency int `json:"frequency"`
+}
+
+// RecordAuthorsNotes records where author's notes were spliced into a
+// character's context
+func (dr *DecisionRecorder) RecordAuthorsNotes(characterID int64, characterName string, notes []NoteInsertion) error {
+	input, _ := json.Marshal(map[string]interface{}{
+		"character_id":   characterID,
+		"character_name": characterName,
+	})
+	output, _ := json.Marshal(map[string]interface{}{
+		"notes": notes,
+	})
+
+	return dr.recordStep("authors_note", string(input), string(output), 0,
+		"Inserted author's notes into the conversation at their configured depth")
+}
+
 // recordStep is the internal method to save a decision step
 func (dr *DecisionRecorder) recordStep(stepType, inputData, outputData string, llmCallLogID int64, reason string) error {
 	dr.stepOrder++
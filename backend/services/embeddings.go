@@ -0,0 +1,399 @@
+package services
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/zucong/rp/config"
+	"github.com/zucong/rp/llm"
+	"github.com/zucong/rp/models"
+)
+
+const (
+	// embeddingChunkSize is the maximum number of characters embedded per chunk
+	embeddingChunkSize = 1000
+	// embeddingBatchSize is the number of messages embedded per API call
+	embeddingBatchSize = 16
+)
+
+// EmbeddingIndexer embeds room messages in the background and answers
+// similarity queries against the stored vectors
+type EmbeddingIndexer struct {
+	db       *sqlx.DB
+	cfgStore *config.Store
+
+	mu      sync.Mutex
+	running map[int64]bool
+	// rescan marks rooms whose running job has to start over from the
+	// first message before finishing, because a message it may already
+	// have passed was edited
+	rescan map[int64]bool
+}
+
+// NewEmbeddingIndexer creates a new indexer
+func NewEmbeddingIndexer(db *sqlx.DB, cfgStore *config.Store) *EmbeddingIndexer {
+	return &EmbeddingIndexer{
+		db:       db,
+		cfgStore: cfgStore,
+		running:  make(map[int64]bool),
+		rescan:   make(map[int64]bool),
+	}
+}
+
+// SemanticMatch is a message ranked by similarity to a query
+type SemanticMatch struct {
+	MessageID int64   `json:"message_id"`
+	Score     float64 `json:"score"`
+	Chunk     string  `json:"chunk"`
+}
+
+// GetJob returns the most recent backfill job for a room
+func (ei *EmbeddingIndexer) GetJob(roomID int64) (*models.EmbeddingJob, error) {
+	var job models.EmbeddingJob
+	err := ei.db.Get(&job, `
+		SELECT * FROM embedding_jobs
+		WHERE room_id = ?
+		ORDER BY id DESC LIMIT 1`, roomID)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// Start begins a backfill job that embeds every message in the room that has
+// no embedding for the configured model yet. If a job is already running for
+// the room it is returned unchanged.
+func (ei *EmbeddingIndexer) Start(roomID int64) (*models.EmbeddingJob, error) {
+	ei.mu.Lock()
+	defer ei.mu.Unlock()
+
+	if ei.running[roomID] {
+		return ei.GetJob(roomID)
+	}
+
+	cfg, err := ei.cfgStore.Get()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.EmbeddingModel == "" {
+		return nil, fmt.Errorf("no embedding model configured")
+	}
+
+	total, err := ei.unembedded(roomID, cfg.EmbeddingModel)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := ei.db.Exec(`
+		INSERT INTO embedding_jobs (room_id, model, status, total)
+		VALUES (?, ?, 'running', ?)`, roomID, cfg.EmbeddingModel, total)
+	if err != nil {
+		return nil, err
+	}
+	jobID, _ := result.LastInsertId()
+
+	ei.running[roomID] = true
+	go ei.run(jobID, roomID, cfg.EmbeddingModel, 0)
+
+	return ei.GetJob(roomID)
+}
+
+// unembedded counts the room's messages that have no embedding for model
+func (ei *EmbeddingIndexer) unembedded(roomID int64, model string) (int, error) {
+	var total int
+	err := ei.db.Get(&total, `
+		SELECT COUNT(*) FROM messages m
+		WHERE m.room_id = ? AND NOT EXISTS (
+			SELECT 1 FROM message_embeddings e WHERE e.message_id = m.id AND e.model = ?
+		)`, roomID, model)
+	return total, err
+}
+
+// Refresh embeds new or edited messages in rooms that have been indexed before
+func (ei *EmbeddingIndexer) Refresh(roomID int64) {
+	if _, err := ei.GetJob(roomID); err != nil {
+		return
+	}
+	if _, err := ei.Start(roomID); err != nil {
+		log.Printf("[Embeddings] Failed to refresh room %d: %v", roomID, err)
+	}
+}
+
+// ResumeAll restarts jobs that were interrupted by a shutdown
+func (ei *EmbeddingIndexer) ResumeAll() {
+	var jobs []models.EmbeddingJob
+	err := ei.db.Select(&jobs, "SELECT * FROM embedding_jobs WHERE status = 'running'")
+	if err != nil {
+		log.Printf("[Embeddings] Failed to load interrupted jobs: %v", err)
+		return
+	}
+
+	ei.mu.Lock()
+	defer ei.mu.Unlock()
+	for _, job := range jobs {
+		if ei.running[job.RoomID] {
+			continue
+		}
+		log.Printf("[Embeddings] Resuming job %d for room %d at message %d", job.ID, job.RoomID, job.LastMessageID)
+		ei.running[job.RoomID] = true
+		go ei.run(job.ID, job.RoomID, job.Model, job.LastMessageID)
+	}
+}
+
+func (ei *EmbeddingIndexer) run(jobID, roomID int64, model string, lastMessageID int64) {
+	released := false
+	defer func() {
+		if released {
+			return
+		}
+		ei.mu.Lock()
+		delete(ei.running, roomID)
+		delete(ei.rescan, roomID)
+		ei.mu.Unlock()
+	}()
+
+	for {
+		var batch []struct {
+			ID      int64  `db:"id"`
+			Name    string `db:"character_name"`
+			Content string `db:"content"`
+		}
+		err := ei.db.Select(&batch, `
+			SELECT m.id, `+ParticipantName+` as character_name, m.content
+			FROM messages m
+			JOIN room_participants rp ON m.participant_id = rp.id
+			JOIN characters c ON rp.character_id = c.id
+			`+EffectiveVersionJoin+`
+			WHERE m.room_id = ? AND m.id > ? AND NOT EXISTS (
+				SELECT 1 FROM message_embeddings e WHERE e.message_id = m.id AND e.model = ?
+			)
+			ORDER BY m.id ASC
+			LIMIT ?`, roomID, lastMessageID, model, embeddingBatchSize)
+		if err != nil {
+			ei.finish(jobID, "failed", err.Error())
+			return
+		}
+		if len(batch) == 0 {
+			// Checked and cleared under the lock, so a message forgotten
+			// after this either starts over this job or finds it gone and
+			// starts a new one
+			ei.mu.Lock()
+			again := ei.rescan[roomID]
+			delete(ei.rescan, roomID)
+			if !again {
+				delete(ei.running, roomID)
+				released = true
+			}
+			ei.mu.Unlock()
+			if again {
+				// The rescan counts its own progress from the start
+				lastMessageID = 0
+				total, err := ei.unembedded(roomID, model)
+				if err == nil {
+					_, err = ei.db.Exec(`
+						UPDATE embedding_jobs
+						SET processed = 0, total = ?, last_message_id = 0, updated_at = CURRENT_TIMESTAMP
+						WHERE id = ?`, total, jobID)
+				}
+				if err != nil {
+					ei.finish(jobID, "failed", err.Error())
+					return
+				}
+				continue
+			}
+			ei.finish(jobID, "completed", "")
+			return
+		}
+
+		var inputs []string
+		var owners []int64
+		for _, m := range batch {
+			for _, chunk := range chunkText(m.Content, embeddingChunkSize) {
+				inputs = append(inputs, fmt.Sprintf("%s: %s", m.Name, chunk))
+				owners = append(owners, m.ID)
+			}
+		}
+
+		if len(inputs) > 0 {
+			cfg, err := ei.cfgStore.Get()
+			if err != nil {
+				ei.finish(jobID, "failed", err.Error())
+				return
+			}
+			vectors, err := llm.NewClient(cfg).Embed(inputs, model)
+			if err != nil {
+				log.Printf("[Embeddings] Job %d failed: %v", jobID, err)
+				ei.finish(jobID, "failed", err.Error())
+				return
+			}
+
+			chunkIndex := make(map[int64]int)
+			for i, vec := range vectors {
+				msgID := owners[i]
+				_, err := ei.db.Exec(`
+					INSERT OR REPLACE INTO message_embeddings (message_id, room_id, model, chunk_index, chunk_text, embedding)
+					VALUES (?, ?, ?, ?, ?, ?)`,
+					msgID, roomID, model, chunkIndex[msgID], inputs[i], encodeEmbedding(vec))
+				if err != nil {
+					ei.finish(jobID, "failed", err.Error())
+					return
+				}
+				chunkIndex[msgID]++
+			}
+		}
+
+		lastMessageID = batch[len(batch)-1].ID
+		_, err = ei.db.Exec(`
+			UPDATE embedding_jobs
+			SET processed = processed + ?, last_message_id = ?, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ?`, len(batch), lastMessageID, jobID)
+		if err != nil {
+			log.Printf("[Embeddings] Failed to update job %d: %v", jobID, err)
+		}
+	}
+}
+
+func (ei *EmbeddingIndexer) finish(jobID int64, status, errMsg string) {
+	_, err := ei.db.Exec(`
+		UPDATE embedding_jobs
+		SET status = ?, error_message = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?`, status, errMsg, jobID)
+	if err != nil {
+		log.Printf("[Embeddings] Failed to finish job %d: %v", jobID, err)
+	}
+}
+
+// Search ranks the room's embedded messages by cosine similarity to query.
+// Messages split into several chunks are scored by their best chunk.
+func (ei *EmbeddingIndexer) Search(roomID int64, query string, limit int) ([]SemanticMatch, error) {
+	cfg, err := ei.cfgStore.Get()
+	if err != nil {
+		return nil, err
+	}
+	vectors, err := llm.NewClient(cfg).Embed([]string{query}, cfg.EmbeddingModel)
+	if err != nil {
+		return nil, err
+	}
+	queryVec := vectors[0]
+
+	var rows []struct {
+		MessageID int64  `db:"message_id"`
+		ChunkText string `db:"chunk_text"`
+		Embedding []byte `db:"embedding"`
+	}
+	err = ei.db.Select(&rows, `
+		SELECT e.message_id, e.chunk_text, e.embedding
+		FROM message_embeddings e
+		JOIN messages m ON m.id = e.message_id
+		WHERE e.room_id = ? AND e.model = ?`, roomID, cfg.EmbeddingModel)
+	if err != nil {
+		return nil, err
+	}
+
+	best := make(map[int64]SemanticMatch)
+	for _, r := range rows {
+		score := cosineSimilarity(queryVec, decodeEmbedding(r.Embedding))
+		if cur, ok := best[r.MessageID]; !ok || score > cur.Score {
+			best[r.MessageID] = SemanticMatch{MessageID: r.MessageID, Score: score, Chunk: r.ChunkText}
+		}
+	}
+
+	matches := make([]SemanticMatch, 0, len(best))
+	for _, m := range best {
+		matches = append(matches, m)
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].Score > matches[j].Score
+	})
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches, nil
+}
+
+// ForgetMessage drops the stored embeddings of an edited or deleted message.
+// An edited message is re-embedded with its current content on the next
+// refresh; a job already running in the room may have passed it, so that
+// job starts over before finishing.
+func (ei *EmbeddingIndexer) ForgetMessage(roomID, messageID int64) {
+	_, err := ei.db.Exec("DELETE FROM message_embeddings WHERE message_id = ?", messageID)
+	if err != nil {
+		log.Printf("[Embeddings] Failed to delete embeddings for message %d: %v", messageID, err)
+	}
+
+	ei.mu.Lock()
+	if ei.running[roomID] {
+		ei.rescan[roomID] = true
+	}
+	ei.mu.Unlock()
+}
+
+// ForgetRoom drops the stored embeddings of every message in a room, for
+// when its chat is cleared or the room is deleted
+func (ei *EmbeddingIndexer) ForgetRoom(roomID int64) {
+	_, err := ei.db.Exec("DELETE FROM message_embeddings WHERE room_id = ?", roomID)
+	if err != nil {
+		log.Printf("[Embeddings] Failed to delete embeddings for room %d: %v", roomID, err)
+	}
+}
+
+// chunkText splits s into pieces of at most size characters, breaking on
+// whitespace where possible
+func chunkText(s string, size int) []string {
+	var chunks []string
+	runes := []rune(strings.TrimSpace(s))
+	for len(runes) > 0 {
+		if len(runes) <= size {
+			chunks = append(chunks, string(runes))
+			break
+		}
+		cut := size
+		for i := size; i > size/2; i-- {
+			if runes[i] == ' ' || runes[i] == '\n' {
+				cut = i
+				break
+			}
+		}
+		chunks = append(chunks, strings.TrimSpace(string(runes[:cut])))
+		runes = []rune(strings.TrimSpace(string(runes[cut:])))
+	}
+	return chunks
+}
+
+func encodeEmbedding(vec []float64) []byte {
+	buf := make([]byte, 4*len(vec))
+	for i, v := range vec {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(float32(v)))
+	}
+	return buf
+}
+
+func decodeEmbedding(buf []byte) []float64 {
+	vec := make([]float64, len(buf)/4)
+	for i := range vec {
+		vec[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(buf[4*i:])))
+	}
+	return vec
+}
+
+func cosineSimilarity(a, b []float64) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
@@ -14,6 +14,11 @@ type LLMCallMetadata struct {
 	MessageID int64
 	RoomID    int64
 	CallType  string // intent_analysis, fallback_selection, response_generation
+
+	// CharacterID and CharacterVersion identify the character version that
+	// produced a response; zero for orchestrator calls
+	CharacterID      int64
+	CharacterVersion int
 }
 
 // LoggedClient wraps llm.Client to record all API calls
@@ -33,25 +38,41 @@ func NewLoggedClient(client *llm.Client, db *sqlx.DB, metadata *LLMCallMetadata)
 }
 
 // Complete wraps the original Complete method with logging
-func (lc *LoggedClient) Complete(messages []llm.Message, model string, temperature float64, maxTokens int) (string, error) {
-	response, _, err := lc.CompleteWithLogID(messages, model, temperature, maxTokens)
+func (lc *LoggedClient) Complete(messages []llm.Message, model string, temperature float64, maxTokens int, params models.SamplingParams) (string, error) {
+	response, _, err := lc.CompleteWithLogID(messages, model, temperature, maxTokens, params)
 	return response, err
 }
 
 // CompleteWithLogID wraps Complete and returns the LLM call log ID for decision tracking
-func (lc *LoggedClient) CompleteWithLogID(messages []llm.Message, model string, temperature float64, maxTokens int) (string, int64, error) {
+func (lc *LoggedClient) CompleteWithLogID(messages []llm.Message, model string, temperature float64, maxTokens int, params models.SamplingParams) (string, int64, error) {
 	start := time.Now()
 
-	// Serialize request
-	reqBody, _ := json.Marshal(map[string]interface{}{
+	// Serialize request as sent, after the provider's unsupported params are dropped
+	params, dropped := lc.client.FilterParams(params)
+	request := map[string]interface{}{
 		"model":       model,
 		"messages":    messages,
 		"temperature": temperature,
 		"max_tokens":  maxTokens,
-	})
+	}
+	raw, _ := json.Marshal(params)
+	json.Unmarshal(raw, &request)
+	request["temperature"] = temperature
+	if len(dropped) > 0 {
+		request["dropped_params"] = dropped
+	}
+	if lc.client.CompletionMode() {
+		// Log the prompt as rendered through the instruct template
+		delete(request, "messages")
+		if prompt, stop, err := lc.client.BuildPrompt(messages, params.Stop); err == nil {
+			request["prompt"] = prompt
+			request["stop"] = stop
+		}
+	}
+	reqBody, _ := json.Marshal(request)
 
 	// Make the actual call
-	response, err := lc.client.Complete(messages, model, temperature, maxTokens)
+	response, err := lc.client.Complete(messages, model, temperature, maxTokens, params)
 
 	latency := time.Since(start).Milliseconds()
 
@@ -65,6 +86,9 @@ func (lc *LoggedClient) CompleteWithLogID(messages []llm.Message, model string,
 		MaxTokens:   maxTokens,
 		RequestBody: string(reqBody),
 		LatencyMs:   latency,
+
+		CharacterID:      lc.metadata.CharacterID,
+		CharacterVersion: lc.metadata.CharacterVersion,
 	}
 
 	if err != nil {
@@ -75,6 +99,13 @@ func (lc *LoggedClient) CompleteWithLogID(messages []llm.Message, model string,
 		// which requires modifying the llm.Client to return usage info
 	}
 
+	// The request is built from messages and params, but provider errors
+	// and responses can echo anything back, so keep the API key out of all
+	// three fields
+	log.RequestBody = lc.client.Scrub(log.RequestBody)
+	log.ResponseBody = lc.client.Scrub(log.ResponseBody)
+	log.ErrorMessage = lc.client.Scrub(log.ErrorMessage)
+
 	// Sync write to get the ID
 	logID := lc.saveLogSync(&log)
 
@@ -90,11 +121,12 @@ func (lc *LoggedClient) saveLogSync(log *models.LLMCallLog) int64 {
 		INSERT INTO llm_call_logs (
 			message_id, room_id, call_type, model_name, temperature, max_tokens,
 			request_body, response_body, prompt_tokens, completion_tokens,
-			latency_ms, error_message
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			latency_ms, error_message, character_id, character_version
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`, log.MessageID, log.RoomID, log.CallType, log.ModelName, log.Temperature,
 		log.MaxTokens, log.RequestBody, log.ResponseBody, log.PromptTokens,
-		log.CompletionTokens, log.LatencyMs, log.ErrorMessage)
+		log.CompletionTokens, log.LatencyMs, log.ErrorMessage,
+		log.CharacterID, log.CharacterVersion)
 
 	if err != nil {
 		// Log error but don't fail the main flow
@@ -0,0 +1,249 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Prompt templates are Go text/template sources for the prompts sent to the
+// LLM. Each kind has a built-in default; a global template (room_id 0)
+// replaces the default and a room's own template replaces both.
+
+const (
+	// PromptSystem is the system prompt of a character's reply
+	PromptSystem = "system"
+	// PromptIntent asks the orchestrator who the user is addressing
+	PromptIntent = "intent"
+	// PromptFallback asks the orchestrator to pick responders when intent
+	// analysis named no one
+	PromptFallback = "fallback"
+)
+
+// PromptKinds lists the template kinds
+var PromptKinds = []string{PromptSystem, PromptIntent, PromptFallback}
+
+// DefaultPromptTemplates are the built-in templates, by kind
+var DefaultPromptTemplates = map[string]string{
+	PromptSystem: `[AI Persona]
+{{.Character.Persona}}
+
+[Setting]
+{{if .Room.Setting}}{{.Room.Setting}}{{else}}No specific setting defined.{{end}}
+
+[User Persona]
+{{range $i, $u := .Users}}{{if $i}}
+
+{{end}}Name: {{$u.Name}}
+{{$u.Persona}}{{else}}A user is speaking to you.{{end}}
+{{- if .Summaries}}
+
+[Story So Far]
+{{range .Summaries}}{{.}}
+{{end}}{{end}}`,
+
+	PromptIntent: `You are analyzing a user's message in a group chat to determine which character(s) they are addressing.
+
+Available characters:
+{{range .Participants}}- {{.Name}} (ID: {{.ID}})
+{{end}}
+
+User message: "{{.Message}}"
+
+Analyze the user's intent:
+1. Is the user DIRECTLY ADDRESSING a specific character? (e.g., "Alice, how are you?", "What do you think, Bob?")
+2. Is the user asking a QUESTION that implies a specific character should answer?
+3. Is the user speaking to the GROUP in general?
+
+IMPORTANT: Names mentioned as EXAMPLES or REFERENCES (like "you too, like Alice") do NOT mean that character should reply. The user is talking TO someone ABOUT another character.
+
+Reply in this exact format:
+INTENT: direct | question | group
+CHARACTERS: ID1,ID2 (or "none")
+
+Examples:
+- "Alice, how are you?" → INTENT: direct\nCHARACTERS: 3
+- "What do you think?" → INTENT: question\nCHARACTERS: (select 1-2 most relevant)
+- "You are like Alice, aren't you?" → INTENT: question\nCHARACTERS: (current speaker, not Alice)
+- "How is everyone?" → INTENT: group\nCHARACTERS: 3,4`,
+
+	PromptFallback: `Select 1-2 characters most relevant to reply to: "{{.Message}}"
+
+Characters:
+{{range .Participants}}- {{.Name}} (ID: {{.ID}})
+{{end}}
+
+Reply with IDs only: 3 or 3,4`,
+}
+
+// PromptData is the data every prompt template is executed with
+type PromptData struct {
+	// Character is the character replying; empty in orchestrator prompts
+	Character PromptCharacter
+	Room      PromptRoom
+	// Participants are the room's AI characters
+	Participants []PromptParticipant
+	// User is the player being answered; Users are all the room's players
+	User  PromptUser
+	Users []PromptUser
+	// Summaries are the room's conversation summaries, oldest first
+	Summaries []string
+	// Message is the user message being answered; set in orchestrator
+	// prompts
+	Message string
+}
+
+// PromptCharacter holds the replying character's definition, with {{char}}
+// and {{user}} already expanded
+type PromptCharacter struct {
+	Name             string
+	Prompt           string
+	Description      string
+	Personality      string
+	Scenario         string
+	ExampleDialogues string
+	// ExtraPrompt is the room-specific prompt of the participant
+	ExtraPrompt string
+	// Persona is the assembled persona: the prompt followed by each
+	// non-empty field and the extra prompt
+	Persona string
+}
+
+type PromptRoom struct {
+	Name        string
+	Description string
+	Setting     string
+}
+
+type PromptParticipant struct {
+	ID     int64
+	Name   string
+	Prompt string
+}
+
+// PromptUser is a human player; Present is false when the room has none
+type PromptUser struct {
+	Present bool
+	Name    string
+	Persona string
+	// Speaking marks the player whose message is being answered
+	Speaking bool
+}
+
+// PromptVariables documents the fields available to templates
+var PromptVariables = map[string]string{
+	".Character.Name":             "Name of the replying character (nickname if set)",
+	".Character.Prompt":           "Character system prompt",
+	".Character.Description":      "Character description",
+	".Character.Personality":      "Character personality",
+	".Character.Scenario":         "Character scenario",
+	".Character.ExampleDialogues": "Character example dialogues",
+	".Character.ExtraPrompt":      "Room-specific extra prompt of the participant",
+	".Character.Persona":          "All of the above assembled into the default persona text",
+	".Room.Name":                  "Room name",
+	".Room.Description":           "Room description",
+	".Room.Setting":               "Room background setting",
+	".Participants":               "AI characters in the room, each with .ID, .Name and .Prompt",
+	".User.Present":               "Whether the room has a human player",
+	".User.Name":                  "Name of the player being answered",
+	".User.Persona":               "Prompt of the player's character",
+	".Users":                      "All human players in the room, each with .Name, .Persona and .Speaking",
+	".Summaries":                  "Conversation summaries, oldest first",
+	".Message":                    "User message being answered (intent and fallback prompts)",
+}
+
+// SamplePromptData is used to validate templates and as preview data when
+// no room is given
+func SamplePromptData() PromptData {
+	return PromptData{
+		Character: PromptCharacter{
+			Name:        "Alice",
+			Prompt:      "A curious explorer.",
+			Personality: "Cheerful and brave.",
+			Persona:     "You are Alice.\nA curious explorer.\n\nPersonality:\nCheerful and brave.",
+		},
+		Room: PromptRoom{Name: "Tavern", Setting: "A busy tavern at the edge of town."},
+		Participants: []PromptParticipant{
+			{ID: 1, Name: "Alice", Prompt: "A curious explorer."},
+			{ID: 2, Name: "Bob", Prompt: "A grumpy innkeeper."},
+		},
+		User: PromptUser{Present: true, Name: "Player", Persona: "A travelling bard.", Speaking: true},
+		Users: []PromptUser{
+			{Present: true, Name: "Player", Persona: "A travelling bard.", Speaking: true},
+			{Present: true, Name: "Guest", Persona: "A wandering knight."},
+		},
+		Summaries: []string{"The party arrived at the tavern."},
+		Message:   "Bob, do you have a room for the night?",
+	}
+}
+
+// ParsePromptTemplate parses a template source
+func ParsePromptTemplate(kind, text string) (*template.Template, error) {
+	return template.New(kind).Parse(text)
+}
+
+// ValidatePromptTemplate checks that a template parses and executes against
+// sample data; unknown fields only show up on execution
+func ValidatePromptTemplate(kind, text string) error {
+	if strings.TrimSpace(text) == "" {
+		return fmt.Errorf("template is empty")
+	}
+	_, err := RenderPromptTemplate(kind, text, SamplePromptData())
+	return err
+}
+
+// RenderPromptTemplate executes a template source with data
+func RenderPromptTemplate(kind, text string, data PromptData) (string, error) {
+	tmpl, err := ParsePromptTemplate(kind, text)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// LoadPromptTemplate returns the template that applies in a room and where
+// it came from: "room", "global" or "default"
+func LoadPromptTemplate(db *sqlx.DB, roomID int64, kind string) (string, string, error) {
+	var rows []struct {
+		RoomID  int64  `db:"room_id"`
+		Content string `db:"content"`
+	}
+	err := db.Select(&rows, `
+		SELECT room_id, content FROM prompt_templates
+		WHERE kind = ? AND room_id IN (?, 0)
+		ORDER BY room_id DESC`, kind, roomID)
+	if err != nil && err != sql.ErrNoRows {
+		return "", "", err
+	}
+	if len(rows) > 0 {
+		if rows[0].RoomID == 0 {
+			return rows[0].Content, "global", nil
+		}
+		return rows[0].Content, "room", nil
+	}
+	return DefaultPromptTemplates[kind], "default", nil
+}
+
+// RenderRoomPrompt renders the template of the given kind that applies in
+// a room. A stored template that fails to render falls back to the
+// built-in one so a bad edit can't stop replies.
+func RenderRoomPrompt(db *sqlx.DB, roomID int64, kind string, data PromptData) string {
+	text, source, err := LoadPromptTemplate(db, roomID, kind)
+	if err == nil {
+		var out string
+		if out, err = RenderPromptTemplate(kind, text, data); err == nil {
+			return out
+		}
+	}
+	log.Printf("[Prompt] %s template (%s) for room %d failed, using default: %v", kind, source, roomID, err)
+	out, _ := RenderPromptTemplate(kind, DefaultPromptTemplates[kind], data)
+	return out
+}
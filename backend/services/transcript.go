@@ -0,0 +1,310 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/zucong/rp/models"
+)
+
+// Transcript is a room's full history prepared for export
+type Transcript struct {
+	Room         models.Room             `json:"room"`
+	Participants []TranscriptParticipant `json:"participants"`
+	Messages     []TranscriptMessage     `json:"messages"`
+	ExportedAt   time.Time               `json:"exported_at"`
+}
+
+type TranscriptParticipant struct {
+	ID              int64  `json:"id" db:"id"`
+	CharacterID     int64  `json:"character_id" db:"character_id"`
+	Name            string `json:"name" db:"name"`
+	Avatar          string `json:"avatar" db:"avatar"`
+	AvatarImage     []byte `json:"-" db:"avatar_image"`
+	ParticipantType string `json:"participant_type" db:"participant_type"`
+	IsUser          bool   `json:"is_user" db:"is_user"`
+}
+
+type TranscriptMessage struct {
+	ID            int64                         `json:"id" db:"id"`
+	ParticipantID int64                         `json:"participant_id" db:"participant_id"`
+	Speaker       string                        `json:"speaker" db:"speaker"`
+	IsAI          bool                          `json:"is_ai" db:"is_ai"`
+	Content       string                        `json:"content" db:"content"`
+	CreatedAt     time.Time                     `json:"created_at" db:"created_at"`
+	Decisions     []models.OrchestratorDecision `json:"decisions,omitempty" db:"-"`
+}
+
+// LoadTranscript reads a room's history. When withDecisions is set, each
+// message carries the orchestrator decisions recorded for it.
+func LoadTranscript(db *sqlx.DB, roomID int64, withDecisions bool) (*Transcript, error) {
+	t := &Transcript{
+		Participants: []TranscriptParticipant{},
+		Messages:     []TranscriptMessage{},
+		ExportedAt:   time.Now(),
+	}
+
+	err := db.Get(&t.Room, "SELECT id, name, description, setting, created_at, updated_at FROM rooms WHERE id = ?", roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	t.Participants, err = LoadTranscriptParticipants(db, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Select(&t.Messages, `
+		SELECT m.id, m.participant_id, `+ParticipantName+` as speaker, rp.participant_type = 'ai' as is_ai,
+			m.content, m.created_at
+		FROM messages m
+		JOIN room_participants rp ON m.participant_id = rp.id
+		JOIN characters c ON rp.character_id = c.id
+		`+EffectiveVersionJoin+`
+		WHERE m.room_id = ?
+		ORDER BY m.seq ASC`, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	if withDecisions {
+		for i := range t.Messages {
+			decisions, err := GetDecisionsForMessage(db, t.Messages[i].ID)
+			if err != nil {
+				return nil, err
+			}
+			t.Messages[i].Decisions = decisions
+		}
+	}
+
+	return t, nil
+}
+
+// LoadTranscriptParticipants reads a room's participants as they appear in
+// transcripts
+func LoadTranscriptParticipants(db *sqlx.DB, roomID int64) ([]TranscriptParticipant, error) {
+	participants := []TranscriptParticipant{}
+	err := db.Select(&participants, `
+		SELECT rp.id, rp.character_id, `+ParticipantName+` as name, cv.avatar, COALESCE(ci.image, x'') as avatar_image,
+			rp.participant_type, rp.is_user
+		FROM room_participants rp
+		JOIN characters c ON rp.character_id = c.id
+		`+EffectiveVersionJoin+`
+		LEFT JOIN character_images ci ON ci.character_id = c.id
+		WHERE rp.room_id = ?
+		ORDER BY rp.id ASC`, roomID)
+	return participants, err
+}
+
+func (t *Transcript) participant(id int64) *TranscriptParticipant {
+	for i := range t.Participants {
+		if t.Participants[i].ID == id {
+			return &t.Participants[i]
+		}
+	}
+	return nil
+}
+
+// JSON renders the transcript as indented JSON
+func (t *Transcript) JSON() ([]byte, error) {
+	return json.MarshalIndent(t, "", "  ")
+}
+
+// Markdown renders the transcript as a Markdown document
+func (t *Transcript) Markdown() []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", t.Room.Name)
+	if t.Room.Description != "" {
+		fmt.Fprintf(&b, "%s\n\n", t.Room.Description)
+	}
+	if t.Room.Setting != "" {
+		b.WriteString("## Setting\n\n")
+		for _, line := range strings.Split(t.Room.Setting, "\n") {
+			fmt.Fprintf(&b, "> %s\n", line)
+		}
+		b.WriteString("\n")
+	}
+
+	b.WriteString("## Participants\n\n")
+	for _, p := range t.Participants {
+		role := "AI"
+		if p.ParticipantType != "ai" {
+			role = "Human"
+		}
+		fmt.Fprintf(&b, "- %s**%s** (%s)\n", avatarPrefix(p.Avatar), p.Name, role)
+	}
+	b.WriteString("\n---\n\n")
+
+	for _, m := range t.Messages {
+		avatar := ""
+		if p := t.participant(m.ParticipantID); p != nil {
+			avatar = avatarPrefix(p.Avatar)
+		}
+		fmt.Fprintf(&b, "%s**%s** · %s\n\n%s\n\n", avatar, m.Speaker, m.CreatedAt.Format("2006-01-02 15:04"), m.Content)
+		if len(m.Decisions) > 0 {
+			b.WriteString("<details><summary>Orchestrator decisions</summary>\n\n")
+			for _, d := range m.Decisions {
+				fmt.Fprintf(&b, "%d. `%s` — %s\n   - input: `%s`\n   - output: `%s`\n", d.StepOrder, d.StepType, d.Reason, d.InputData, d.OutputData)
+			}
+			b.WriteString("\n</details>\n\n")
+		}
+	}
+
+	return []byte(b.String())
+}
+
+// Fountain renders the transcript in the Fountain screenplay format
+func (t *Transcript) Fountain() []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Title: %s\n", t.Room.Name)
+	if t.Room.Description != "" {
+		fmt.Fprintf(&b, "Notes: %s\n", strings.ReplaceAll(t.Room.Description, "\n", " "))
+	}
+	fmt.Fprintf(&b, "Draft date: %s\n\n", t.ExportedAt.Format("2006-01-02"))
+
+	b.WriteString("FADE IN:\n\n")
+	if t.Room.Setting != "" {
+		fmt.Fprintf(&b, "%s\n\n", fountainBlock(t.Room.Setting))
+	}
+
+	for _, m := range t.Messages {
+		fmt.Fprintf(&b, "%s\n%s\n\n", fountainCharacter(m.Speaker), fountainBlock(m.Content))
+		for _, d := range m.Decisions {
+			fmt.Fprintf(&b, "[[%s: %s]]\n\n", d.StepType, d.Reason)
+		}
+	}
+
+	b.WriteString("FADE OUT.\n")
+	return []byte(b.String())
+}
+
+// fountainCharacter formats a speaker cue. Names that don't upper-case
+// cleanly (e.g. non-Latin scripts) are forced with the @ prefix.
+func fountainCharacter(name string) string {
+	upper := strings.ToUpper(name)
+	hasUpper := false
+	for _, r := range upper {
+		if unicode.IsLower(r) {
+			return "@" + name
+		}
+		if unicode.IsUpper(r) {
+			hasUpper = true
+		}
+	}
+	if !hasUpper {
+		return "@" + name
+	}
+	return upper
+}
+
+// fountainBlock keeps multi-paragraph text in one element; Fountain ends an
+// element at a blank line unless the line holds two spaces
+func fountainBlock(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			lines[i] = "  "
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func avatarPrefix(avatar string) string {
+	if avatar == "" {
+		return ""
+	}
+	return avatar + " "
+}
+
+var transcriptHTML = template.Must(template.New("transcript").Funcs(template.FuncMap{
+	"avatarImage": func(p *TranscriptParticipant) template.URL {
+		if p == nil || len(p.AvatarImage) == 0 {
+			return ""
+		}
+		return template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(p.AvatarImage))
+	},
+	"initial": func(name string) string {
+		for _, r := range name {
+			return string(r)
+		}
+		return "?"
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.T.Room.Name}}</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 760px; margin: 2rem auto; padding: 0 1rem; color: #1f2937; background: #fafafa; }
+h1 { margin-bottom: 0.25rem; }
+.description { color: #6b7280; }
+.setting { border-left: 4px solid #d1d5db; padding: 0.5rem 1rem; background: #f3f4f6; white-space: pre-wrap; }
+.message { display: flex; gap: 0.75rem; margin: 1.25rem 0; }
+.message.human { flex-direction: row-reverse; text-align: right; }
+.avatar { width: 40px; height: 40px; border-radius: 50%; flex-shrink: 0; display: flex; align-items: center; justify-content: center; background: #e0e7ff; font-size: 1.25rem; overflow: hidden; }
+.avatar img { width: 100%; height: 100%; object-fit: cover; }
+.meta { font-size: 0.8rem; color: #6b7280; margin-bottom: 0.25rem; }
+.bubble { display: inline-block; text-align: left; padding: 0.5rem 1rem; border-radius: 0.5rem; background: #e5e7eb; white-space: pre-wrap; }
+.human .bubble { background: #4f46e5; color: #fff; }
+details { font-size: 0.8rem; color: #4b5563; margin-top: 0.25rem; text-align: left; }
+code { word-break: break-all; }
+.nav { text-align: center; font-size: 0.9rem; }
+footer { margin-top: 2rem; font-size: 0.75rem; color: #9ca3af; }
+</style>
+</head>
+<body>
+<h1>{{.T.Room.Name}}</h1>
+{{with .T.Room.Description}}<p class="description">{{.}}</p>{{end}}
+{{with .T.Room.Setting}}<div class="setting">{{.}}</div>{{end}}
+{{with .Earlier}}<p class="nav"><a href="{{.}}">Earlier messages</a></p>{{end}}
+{{range .T.Messages}}{{$p := index $.Participants .ParticipantID}}
+<div class="message{{if not .IsAI}} human{{end}}">
+  <div class="avatar">{{with avatarImage $p}}<img src="{{.}}" alt="">{{else}}{{if and $p $p.Avatar}}{{$p.Avatar}}{{else}}{{initial .Speaker}}{{end}}{{end}}</div>
+  <div>
+    <div class="meta">{{.Speaker}} · {{.CreatedAt.Format "2006-01-02 15:04"}}</div>
+    <div class="bubble">{{.Content}}</div>
+    {{if .Decisions}}<details><summary>Orchestrator decisions</summary><ol>
+    {{range .Decisions}}<li><strong>{{.StepType}}</strong> — {{.Reason}}<br><code>{{.InputData}}</code><br><code>{{.OutputData}}</code></li>
+    {{end}}</ol></details>{{end}}
+  </div>
+</div>
+{{end}}
+{{with .Later}}<p class="nav"><a href="{{.}}">Later messages</a></p>{{end}}
+<footer>Exported {{.T.ExportedAt.Format "2006-01-02 15:04"}}</footer>
+</body>
+</html>
+`))
+
+// HTML renders the transcript as a self-contained HTML page with avatars inlined
+func (t *Transcript) HTML() ([]byte, error) {
+	return t.HTMLPage("", "")
+}
+
+// HTMLPage renders one page of a longer transcript as HTML, with links to
+// the earlier and later pages when their URLs are given
+func (t *Transcript) HTMLPage(earlier, later string) ([]byte, error) {
+	participants := make(map[int64]*TranscriptParticipant, len(t.Participants))
+	for i := range t.Participants {
+		participants[t.Participants[i].ID] = &t.Participants[i]
+	}
+
+	var buf bytes.Buffer
+	err := transcriptHTML.Execute(&buf, struct {
+		T            *Transcript
+		Participants map[int64]*TranscriptParticipant
+		Earlier      string
+		Later        string
+	}{t, participants, earlier, later})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
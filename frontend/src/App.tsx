@@ -1,3 +1,4 @@
+import { useState, useEffect } from 'react'
 import { Routes, Route } from 'react-router-dom'
 import Layout from './components/Layout'
 import CharacterList from './pages/CharacterList'
@@ -7,10 +8,37 @@ import RoomForm from './pages/RoomForm'
 import RoomDetail from './pages/RoomDetail'
 import ChatRoom from './pages/ChatRoom'
 import Settings from './pages/Settings'
+import Login from './pages/Login'
+import { watchUnauthorized, type User } from './lib/auth'
 
 function App() {
+  const [user, setUser] = useState<User | null>(null)
+  const [checking, setChecking] = useState(true)
+
+  useEffect(() => {
+    const restore = watchUnauthorized(() => setUser(null))
+    fetch('/api/auth/me')
+      .then((res) => (res.ok ? res.json() : null))
+      .then((data) => setUser(data))
+      .catch((err) => console.error('Failed to check session:', err))
+      .finally(() => setChecking(false))
+    return restore
+  }, [])
+
+  const handleLogout = async () => {
+    try {
+      await fetch('/api/auth/logout', { method: 'POST' })
+    } catch (err) {
+      console.error('Failed to log out:', err)
+    }
+    setUser(null)
+  }
+
+  if (checking) return null
+  if (!user) return <Login onLogin={setUser} />
+
   return (
-    <Layout>
+    <Layout user={user} onLogout={handleLogout}>
       <Routes>
         <Route path="/" element={<RoomList />} />
         <Route path="/rooms" element={<RoomList />} />
@@ -1,18 +1,21 @@
 import { Link, useLocation } from 'react-router-dom'
-import { Users, MessageSquare, Settings } from 'lucide-react'
+import { Users, MessageSquare, Settings, LogOut } from 'lucide-react'
 import { cn } from '../lib/utils'
+import type { User } from '../lib/auth'
 
 interface LayoutProps {
   children: React.ReactNode
+  user: User
+  onLogout: () => void
 }
 
-export default function Layout({ children }: LayoutProps) {
+export default function Layout({ children, user, onLogout }: LayoutProps) {
   const location = useLocation()
 
   const navItems = [
     { path: '/rooms', label: 'Rooms', icon: MessageSquare },
     { path: '/characters', label: 'Characters', icon: Users },
-    { path: '/settings', label: 'Settings', icon: Settings },
+    ...(user.is_admin ? [{ path: '/settings', label: 'Settings', icon: Settings }] : []),
   ]
 
   return (
@@ -40,6 +43,16 @@ export default function Layout({ children }: LayoutProps) {
                 </Link>
               ))}
             </div>
+            <div className="ml-auto flex items-center gap-3 text-sm">
+              <span className="text-muted-foreground">{user.username}</span>
+              <button
+                onClick={onLogout}
+                className="flex items-center gap-2 px-3 py-2 rounded-md font-medium hover:bg-muted"
+              >
+                <LogOut className="h-4 w-4" />
+                Log Out
+              </button>
+            </div>
           </div>
         </div>
       </nav>
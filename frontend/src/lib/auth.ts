@@ -0,0 +1,23 @@
+export interface User {
+  id: number
+  username: string
+  is_admin: boolean
+}
+
+// watchUnauthorized calls onUnauthorized whenever an API request comes back
+// 401, which means the session expired or was ended elsewhere. It returns a
+// function that restores the original fetch.
+export function watchUnauthorized(onUnauthorized: () => void): () => void {
+  const original = window.fetch
+  window.fetch = async (input, init) => {
+    const res = await original(input, init)
+    const url = typeof input === 'string' ? input : input instanceof URL ? input.href : input.url
+    if (res.status === 401 && url.includes('/api/') && !url.includes('/api/auth/login')) {
+      onUnauthorized()
+    }
+    return res
+  }
+  return () => {
+    window.fetch = original
+  }
+}
@@ -5,28 +5,62 @@ interface CharacterFormData {
   name: string
   avatar: string
   prompt: string
+  description: string
+  personality: string
+  scenario: string
+  example_dialogues: string
+  greeting: string
+  post_history_instructions: string
   is_user_playable: boolean
   model_name: string
   temperature: number
   max_tokens: number
+  sampler_preset_id: number
 }
 
 const defaultFormData: CharacterFormData = {
   name: '',
   avatar: '',
   prompt: '',
+  description: '',
+  personality: '',
+  scenario: '',
+  example_dialogues: '',
+  greeting: '',
+  post_history_instructions: '',
   is_user_playable: false,
   model_name: 'gpt-3.5-turbo',
   temperature: 0.7,
   max_tokens: 1000,
+  sampler_preset_id: 0,
 }
 
+type TextField = 'description' | 'personality' | 'scenario' | 'example_dialogues' | 'greeting' | 'post_history_instructions'
+
+// {{char}} and {{user}} are replaced with the character and user names
+const characterFields: { key: TextField; label: string; placeholder: string; tall?: boolean }[] = [
+  { key: 'description', label: 'Description', placeholder: "Appearance, background, what {{char}} is like...", tall: true },
+  { key: 'personality', label: 'Personality', placeholder: 'e.g., calm, strategic, speaks in proverbs' },
+  { key: 'scenario', label: 'Scenario', placeholder: 'The situation {{char}} and {{user}} are in' },
+  { key: 'example_dialogues', label: 'Example Dialogues', placeholder: '{{user}}: Hello\n{{char}}: Greetings, traveler.', tall: true },
+  { key: 'greeting', label: 'Greeting', placeholder: 'First message posted when {{char}} joins an empty room', tall: true },
+  { key: 'post_history_instructions', label: 'Post-History Instructions', placeholder: 'Instructions sent after the conversation history' },
+]
+
 export default function CharacterForm() {
   const { id } = useParams()
   const navigate = useNavigate()
   const isEdit = !!id
   const [formData, setFormData] = useState<CharacterFormData>(defaultFormData)
   const [saving, setSaving] = useState(false)
+  const [presets, setPresets] = useState<{ id: number; name: string }[]>([])
+
+  useEffect(() => {
+    fetch('/api/presets')
+      .then((res) => res.json())
+      .then(setPresets)
+      .catch((err) => console.error('Failed to fetch presets:', err))
+  }, [])
 
   useEffect(() => {
     if (isEdit) {
@@ -102,7 +136,7 @@ export default function CharacterForm() {
         </div>
 
         <div className="space-y-2">
-          <label className="text-sm font-medium">System Prompt *</label>
+          <label className="text-sm font-medium">System Prompt</label>
           <textarea
             value={formData.prompt}
             onChange={(e) => setFormData({ ...formData, prompt: e.target.value })}
@@ -111,6 +145,18 @@ export default function CharacterForm() {
           />
         </div>
 
+        {characterFields.map((field) => (
+          <div key={field.key} className="space-y-2">
+            <label className="text-sm font-medium">{field.label}</label>
+            <textarea
+              value={formData[field.key]}
+              onChange={(e) => setFormData({ ...formData, [field.key]: e.target.value })}
+              className={`w-full px-3 py-2 border rounded-md ${field.tall ? 'h-32' : 'h-20'}`}
+              placeholder={field.placeholder}
+            />
+          </div>
+        ))}
+
         <div className="flex items-center gap-2">
           <input
             type="checkbox"
@@ -156,6 +202,21 @@ export default function CharacterForm() {
               />
             </div>
           </div>
+
+          <div className="space-y-2 mt-4">
+            <label className="text-sm font-medium">Sampler Preset</label>
+            <select
+              value={formData.sampler_preset_id}
+              onChange={(e) => setFormData({ ...formData, sampler_preset_id: parseInt(e.target.value) })}
+              className="w-full px-3 py-2 border rounded-md"
+            >
+              <option value={0}>None</option>
+              {presets.map((preset) => (
+                <option key={preset.id} value={preset.id}>{preset.name}</option>
+              ))}
+            </select>
+            <p className="text-xs text-muted-foreground">Extra sampling parameters such as top_p and penalties.</p>
+          </div>
         </div>
 
         <div className="flex gap-4 pt-4">
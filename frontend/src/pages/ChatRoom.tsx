@@ -6,6 +6,7 @@ import DecisionTreeViewer from '../components/DecisionTreeViewer'
 
 interface Message {
   id: number
+  seq: number
   participant_id: number
   participant_name: string
   participant_avatar: string
@@ -22,24 +23,44 @@ interface Participant {
   character_avatar: string
   participant_type: 'ai' | 'human'
   is_user: boolean
+  user_id: number
 }
 
 interface Room {
   id: number
   name: string
   setting: string
+  role: 'owner' | 'player' | 'spectator'
+}
+
+interface Turn {
+  id: number
+  kind: 'reply' | 'regenerate'
+  stage: 'queued' | 'selecting' | 'generating'
+  generating: number[]
+}
+
+interface RoomStatus {
+  state: 'idle' | 'processing'
+  current: Turn | null
+  queued: Turn[]
 }
 
 export default function ChatRoom() {
   const { id } = useParams<{ id: string }>()
   const roomId = parseInt(id || '0')
   const [room, setRoom] = useState<Room | null>(null)
+  const [accountId, setAccountId] = useState<number | null>(null)
   const [participants, setParticipants] = useState<Participant[]>([])
+  const [speakerId, setSpeakerId] = useState<number | null>(null)
   const [messages, setMessages] = useState<Message[]>([])
+  const [hasMoreBefore, setHasMoreBefore] = useState(false)
+  const [loadingOlder, setLoadingOlder] = useState(false)
   const [input, setInput] = useState('')
   const [loading, setLoading] = useState(true)
   const [sending, setSending] = useState(false)
   const [typingParticipants, setTypingParticipants] = useState<number[]>([])
+  const [turnStatus, setTurnStatus] = useState<RoomStatus | null>(null)
   const [editingMessage, setEditingMessage] = useState<Message | null>(null)
   const [editContent, setEditContent] = useState('')
   const [viewingLogs, setViewingLogs] = useState<number | null>(null)
@@ -48,6 +69,7 @@ export default function ChatRoom() {
   const eventSourceRef = useRef<EventSource | null>(null)
   const editTextareaRef = useRef<HTMLTextAreaElement | null>(null)
   const editContentRef = useRef<HTMLDivElement | null>(null)
+  const skipScrollRef = useRef(false)
 
   useEffect(() => {
     if (editTextareaRef.current) {
@@ -60,6 +82,7 @@ export default function ChatRoom() {
   useEffect(() => {
     fetchRoomData()
     fetchMessages()
+    fetchStatus()
     connectEventSource()
     return () => {
       eventSourceRef.current?.close()
@@ -67,19 +90,27 @@ export default function ChatRoom() {
   }, [roomId])
 
   useEffect(() => {
+    if (skipScrollRef.current) {
+      skipScrollRef.current = false
+      return
+    }
     messagesEndRef.current?.scrollIntoView({ behavior: 'smooth' })
   }, [messages])
 
   const fetchRoomData = async () => {
     try {
-      const [roomRes, participantsRes] = await Promise.all([
+      const [roomRes, participantsRes, meRes] = await Promise.all([
         fetch(`/api/rooms/${roomId}`),
         fetch(`/api/rooms/${roomId}/participants`),
+        fetch('/api/auth/me'),
       ])
+      if (!roomRes.ok) return
       const roomData = await roomRes.json()
       const participantsData = await participantsRes.json()
+      const meData = await meRes.json()
       setRoom(roomData)
       setParticipants(participantsData || [])
+      setAccountId(meData.id)
     } catch (err) {
       console.error('Failed to fetch room data:', err)
     } finally {
@@ -91,12 +122,43 @@ export default function ChatRoom() {
     try {
       const res = await fetch(`/api/rooms/${roomId}/messages`)
       const data = await res.json()
-      setMessages(data || [])
+      setMessages(data.messages || [])
+      setHasMoreBefore(!!data.has_more_before)
     } catch (err) {
       console.error('Failed to fetch messages:', err)
     }
   }
 
+  const applyStatus = (status: RoomStatus) => {
+    setTurnStatus(status)
+    setTypingParticipants(status.current?.generating || [])
+  }
+
+  const fetchStatus = async () => {
+    try {
+      const res = await fetch(`/api/rooms/${roomId}/status`)
+      if (res.ok) applyStatus(await res.json())
+    } catch (err) {
+      console.error('Failed to fetch room status:', err)
+    }
+  }
+
+  const fetchOlderMessages = async () => {
+    if (loadingOlder || messages.length === 0) return
+    setLoadingOlder(true)
+    try {
+      const res = await fetch(`/api/rooms/${roomId}/messages?before=${messages[0].id}`)
+      const data = await res.json()
+      skipScrollRef.current = true
+      setMessages((prev) => [...(data.messages || []), ...prev])
+      setHasMoreBefore(!!data.has_more_before)
+    } catch (err) {
+      console.error('Failed to fetch older messages:', err)
+    } finally {
+      setLoadingOlder(false)
+    }
+  }
+
   const connectEventSource = () => {
     eventSourceRef.current?.close()
     console.log('[SSE] Connecting to room', roomId)
@@ -111,7 +173,8 @@ export default function ChatRoom() {
       try {
         const data = JSON.parse(event.data)
         if (data.type === 'message') {
-          setMessages((prev) => [...prev, data.message])
+          // Replies generated in parallel can arrive out of order
+          setMessages((prev) => [...prev, data.message].sort((a, b) => a.seq - b.seq))
           setTypingParticipants((prev) => prev.filter((id) => id !== data.message.participant_id))
         } else if (data.type === 'typing') {
           setTypingParticipants((prev) => [...prev, data.participant_id])
@@ -123,6 +186,11 @@ export default function ChatRoom() {
           )
         } else if (data.type === 'message_deleted') {
           setMessages((prev) => prev.filter((msg) => msg.id !== data.message_id))
+        } else if (data.type === 'turn_status') {
+          applyStatus(data.status)
+        } else if (data.type === 'room_rewound') {
+          fetchRoomData()
+          fetchMessages()
         }
       } catch (err) {
         console.error('[SSE] Failed to parse message:', err)
@@ -143,7 +211,7 @@ export default function ChatRoom() {
       const res = await fetch(`/api/rooms/${roomId}/chat`, {
         method: 'POST',
         headers: { 'Content-Type': 'application/json' },
-        body: JSON.stringify({ content: input }),
+        body: JSON.stringify({ content: input, participant_id: currentUser?.id }),
       })
       if (!res.ok) throw new Error('Failed to send')
       setInput('')
@@ -227,13 +295,20 @@ export default function ChatRoom() {
       })
       if (!res.ok) throw new Error('Failed to reset chat')
       setMessages([])
+      setHasMoreBefore(false)
     } catch (err) {
       console.error('Failed to reset chat:', err)
       alert('Failed to clear chat history')
     }
   }
 
-  const currentUser = participants.find((p) => p.is_user)
+  const isOwner = room?.role === 'owner'
+  // Players can only speak as the participants assigned to them
+  const users = participants.filter((p) => p.is_user && (isOwner || p.user_id === accountId))
+  const currentUser = users.find((p) => p.id === speakerId) ?? users[0]
+  const canPost = room?.role !== 'spectator' && users.length > 0
+  const ownsMessage = (msg: Message) =>
+    isOwner || participants.some((p) => p.id === msg.participant_id && p.user_id === accountId)
 
   if (loading) return <div className="text-center py-8">Loading...</div>
   if (!room) return <div className="text-center py-8">Room not found</div>
@@ -243,23 +318,50 @@ export default function ChatRoom() {
       <div className="border-b pb-4 mb-4">
         <div className="flex items-center justify-between">
           <h1 className="text-xl font-bold">{room.name}</h1>
-          <button
-            onClick={handleResetChat}
-            className="px-3 py-1.5 text-sm text-destructive hover:bg-destructive/10 rounded-lg flex items-center gap-1.5"
-          >
-            <Trash2 className="h-4 w-4" />
-            Clear History
-          </button>
+          {isOwner && (
+            <button
+              onClick={handleResetChat}
+              className="px-3 py-1.5 text-sm text-destructive hover:bg-destructive/10 rounded-lg flex items-center gap-1.5"
+            >
+              <Trash2 className="h-4 w-4" />
+              Clear History
+            </button>
+          )}
         </div>
         <p className="text-sm text-muted-foreground">{room.setting}</p>
-        {currentUser && (
+        {currentUser && users.length === 1 && (
           <p className="text-sm text-primary mt-1">
             Current Identity: {currentUser.character_name}
           </p>
         )}
+        {users.length > 1 && (
+          <p className="text-sm text-primary mt-1">
+            Current Identity:{' '}
+            <select
+              value={currentUser?.id}
+              onChange={(e) => setSpeakerId(parseInt(e.target.value))}
+              className="px-2 py-0.5 border rounded-md bg-background"
+            >
+              {users.map((u) => (
+                <option key={u.id} value={u.id}>{u.character_name}</option>
+              ))}
+            </select>
+          </p>
+        )}
       </div>
 
       <div className="flex-1 overflow-y-auto space-y-4 pr-2">
+        {hasMoreBefore && (
+          <div className="text-center">
+            <button
+              onClick={fetchOlderMessages}
+              disabled={loadingOlder}
+              className="px-3 py-1.5 text-sm text-muted-foreground hover:bg-muted rounded-lg disabled:opacity-50"
+            >
+              {loadingOlder ? 'Loading...' : 'Load earlier messages'}
+            </button>
+          </div>
+        )}
         {(() => {
           const lastUserMsgId = [...messages].reverse().find(m => !m.is_ai)?.id
           return messages.map((msg) => {
@@ -327,21 +429,25 @@ export default function ChatRoom() {
                 </div>
                 {!editingMessage && (
                   <div className={`flex gap-1 mt-1 ${isHuman ? 'justify-end' : ''}`}>
-                    <button
-                      onClick={() => handleEditStart(msg)}
-                      className="p-1 rounded hover:bg-muted opacity-0 group-hover:opacity-100 transition-opacity"
-                      title="Edit"
-                    >
-                      <Edit2 className="h-3 w-3" />
-                    </button>
-                    <button
-                      onClick={() => handleDelete(msg.id)}
-                      className="p-1 rounded hover:bg-muted text-destructive opacity-0 group-hover:opacity-100 transition-opacity"
-                      title="Delete"
-                    >
-                      <Trash2 className="h-3 w-3" />
-                    </button>
-                    {isLastUserMsg && (
+                    {ownsMessage(msg) && (
+                      <>
+                        <button
+                          onClick={() => handleEditStart(msg)}
+                          className="p-1 rounded hover:bg-muted opacity-0 group-hover:opacity-100 transition-opacity"
+                          title="Edit"
+                        >
+                          <Edit2 className="h-3 w-3" />
+                        </button>
+                        <button
+                          onClick={() => handleDelete(msg.id)}
+                          className="p-1 rounded hover:bg-muted text-destructive opacity-0 group-hover:opacity-100 transition-opacity"
+                          title="Delete"
+                        >
+                          <Trash2 className="h-3 w-3" />
+                        </button>
+                      </>
+                    )}
+                    {isLastUserMsg && ownsMessage(msg) && (
                       <button
                         onClick={handleRegenerate}
                         className="p-1 rounded hover:bg-muted opacity-0 group-hover:opacity-100 transition-opacity"
@@ -350,7 +456,7 @@ export default function ChatRoom() {
                         <RefreshCw className="h-3 w-3" />
                       </button>
                     )}
-                    {isHuman && (
+                    {isHuman && isOwner && (
                       <>
                         <button
                           onClick={() => setViewingDecisions(msg.id)}
@@ -375,6 +481,11 @@ export default function ChatRoom() {
           )
         })})()}
 
+        {turnStatus?.state === 'processing' && typingParticipants.length === 0 && (
+          <div className="text-sm text-muted-foreground">
+            {turnStatus.current?.kind === 'regenerate' ? 'Regenerating replies...' : 'Choosing who replies...'}
+          </div>
+        )}
         {typingParticipants.length > 0 && (
           <div className="flex gap-3">
             {typingParticipants.map((pid) => {
@@ -390,10 +501,22 @@ export default function ChatRoom() {
             })}
           </div>
         )}
+        {turnStatus && turnStatus.queued.length > 0 && (
+          <div className="text-xs text-muted-foreground">
+            {turnStatus.queued.length} more {turnStatus.queued.length === 1 ? 'turn' : 'turns'} waiting
+          </div>
+        )}
         <div ref={messagesEndRef} />
       </div>
 
       <div className="border-t pt-4 mt-4">
+        {!canPost ? (
+          <p className="text-sm text-muted-foreground text-center py-2">
+            {room.role === 'spectator'
+              ? 'You are watching this room as a spectator.'
+              : 'No participant in this room is assigned to you.'}
+          </p>
+        ) : (
         <div className="flex gap-2">
           <textarea
             value={input}
@@ -410,6 +533,7 @@ export default function ChatRoom() {
             <Send className="h-5 w-5" />
           </button>
         </div>
+        )}
       </div>
 
       {viewingLogs && (
@@ -0,0 +1,78 @@
+import { useState } from 'react'
+import type { User } from '../lib/auth'
+
+interface LoginProps {
+  onLogin: (user: User) => void
+}
+
+export default function Login({ onLogin }: LoginProps) {
+  const [username, setUsername] = useState('')
+  const [password, setPassword] = useState('')
+  const [error, setError] = useState('')
+  const [submitting, setSubmitting] = useState(false)
+
+  const handleSubmit = async (e: React.FormEvent) => {
+    e.preventDefault()
+    setSubmitting(true)
+    setError('')
+    try {
+      const res = await fetch('/api/auth/login', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ username, password }),
+      })
+      const data = await res.json()
+      if (!res.ok) {
+        setError(data.error || 'Login failed')
+        return
+      }
+      onLogin(data.user)
+    } catch (err) {
+      console.error('Failed to log in:', err)
+      setError('Login failed')
+    } finally {
+      setSubmitting(false)
+    }
+  }
+
+  return (
+    <div className="min-h-screen bg-background flex items-center justify-center">
+      <form onSubmit={handleSubmit} className="w-full max-w-sm space-y-4 p-6 border rounded-lg bg-card">
+        <h1 className="text-2xl font-bold">Roleplay Chat</h1>
+
+        <div className="space-y-2">
+          <label className="text-sm font-medium">Username</label>
+          <input
+            type="text"
+            value={username}
+            onChange={(e) => setUsername(e.target.value)}
+            className="w-full px-3 py-2 border rounded-md"
+            autoComplete="username"
+            autoFocus
+          />
+        </div>
+
+        <div className="space-y-2">
+          <label className="text-sm font-medium">Password</label>
+          <input
+            type="password"
+            value={password}
+            onChange={(e) => setPassword(e.target.value)}
+            className="w-full px-3 py-2 border rounded-md"
+            autoComplete="current-password"
+          />
+        </div>
+
+        {error && <p className="text-sm text-destructive">{error}</p>}
+
+        <button
+          type="submit"
+          disabled={submitting || !username || !password}
+          className="w-full px-6 py-2 bg-primary text-primary-foreground rounded-md hover:bg-primary/90 disabled:opacity-50"
+        >
+          {submitting ? 'Logging in...' : 'Log In'}
+        </button>
+      </form>
+    </div>
+  )
+}
@@ -7,6 +7,7 @@ interface Room {
   name: string
   description: string
   system_prompt: string
+  role: 'owner' | 'player' | 'spectator'
 }
 
 interface Character {
@@ -23,6 +24,25 @@ interface Participant {
   character_avatar: string
   participant_type: string
   is_user: boolean
+  user_id: number
+}
+
+interface Member {
+  user_id: number
+  username: string
+  role: 'owner' | 'player' | 'spectator'
+}
+
+interface ShareLink {
+  id: number
+  token: string
+  live: boolean
+  expires_at: string | null
+}
+
+interface Account {
+  id: number
+  username: string
 }
 
 export default function RoomDetail() {
@@ -31,6 +51,13 @@ export default function RoomDetail() {
   const [room, setRoom] = useState<Room | null>(null)
   const [participants, setParticipants] = useState<Participant[]>([])
   const [availableChars, setAvailableChars] = useState<Character[]>([])
+  const [members, setMembers] = useState<Member[]>([])
+  const [accounts, setAccounts] = useState<Account[]>([])
+  const [accountId, setAccountId] = useState<number | null>(null)
+  const [newMemberId, setNewMemberId] = useState('')
+  const [shares, setShares] = useState<ShareLink[]>([])
+  const [shareHours, setShareHours] = useState('0')
+  const [shareLive, setShareLive] = useState(false)
   const [loading, setLoading] = useState(true)
 
   useEffect(() => {
@@ -39,17 +66,28 @@ export default function RoomDetail() {
 
   const fetchData = async () => {
     try {
-      const [roomRes, participantsRes, charsRes] = await Promise.all([
+      const [roomRes, participantsRes, charsRes, membersRes, accountsRes, meRes] = await Promise.all([
         fetch(`/api/rooms/${id}`),
         fetch(`/api/rooms/${id}/participants`),
         fetch('/api/characters'),
+        fetch(`/api/rooms/${id}/members`),
+        fetch('/api/users'),
+        fetch('/api/auth/me'),
       ])
+      if (!roomRes.ok) return
       const roomData = await roomRes.json()
       const participantsData = await participantsRes.json()
       const charsData = await charsRes.json()
 
       setRoom(roomData)
       setParticipants(participantsData || [])
+      setMembers((await membersRes.json()) || [])
+      setAccounts((await accountsRes.json()) || [])
+      setAccountId((await meRes.json()).id)
+      if (roomData.role === 'owner') {
+        const sharesRes = await fetch(`/api/rooms/${id}/shares`)
+        setShares((await sharesRes.json()) || [])
+      }
 
       // Filter out characters already in room
       const participantCharIds = new Set((participantsData || []).map((p: Participant) => p.character_id))
@@ -70,6 +108,7 @@ export default function RoomDetail() {
           character_id: charId,
           participant_type: isUser ? 'human' : 'ai',
           is_user: isUser,
+          user_id: isUser ? accountId : 0,
         }),
       })
       fetchData()
@@ -89,9 +128,70 @@ export default function RoomDetail() {
     }
   }
 
+  // assignParticipant keeps the participant's other settings, which an
+  // update replaces
+  const assignParticipant = async (p: Participant, userId: number) => {
+    const res = await fetch(`/api/rooms/${id}/participants/${p.id}`, {
+      method: 'PUT',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ ...p, user_id: userId }),
+    })
+    if (!res.ok) {
+      const data = await res.json()
+      alert(data.error || 'Failed to assign participant')
+    }
+    fetchData()
+  }
+
+  const setMemberRole = async (userId: number, role: string) => {
+    const res = await fetch(`/api/rooms/${id}/members/${userId}`, {
+      method: 'PUT',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ role }),
+    })
+    if (!res.ok) {
+      const data = await res.json()
+      alert(data.error || 'Failed to update member')
+    }
+    fetchData()
+  }
+
+  const removeMember = async (userId: number) => {
+    const res = await fetch(`/api/rooms/${id}/members/${userId}`, { method: 'DELETE' })
+    if (!res.ok) {
+      const data = await res.json()
+      alert(data.error || 'Failed to remove member')
+    }
+    fetchData()
+  }
+
+  const createShare = async () => {
+    const res = await fetch(`/api/rooms/${id}/shares`, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ expires_in_hours: parseInt(shareHours) || 0, live: shareLive }),
+    })
+    if (!res.ok) {
+      const data = await res.json()
+      alert(data.error || 'Failed to create share link')
+    }
+    fetchData()
+  }
+
+  const revokeShare = async (shareId: number) => {
+    if (!confirm('Revoke this share link? Anyone using it will lose access.')) return
+    await fetch(`/api/rooms/${id}/shares/${shareId}`, { method: 'DELETE' })
+    fetchData()
+  }
+
+  const shareURL = (token: string) => `${window.location.origin}/share/${token}?format=html`
+
   if (loading) return <div className="text-center py-8">Loading...</div>
   if (!room) return <div className="text-center py-8">Room not found</div>
 
+  const isOwner = room.role === 'owner'
+  const posters = members.filter((m) => m.role !== 'spectator')
+
   return (
     <div className="space-y-6">
       <div className="flex items-center gap-4">
@@ -139,12 +239,29 @@ export default function RoomDetail() {
                       )}
                     </div>
                   </div>
-                  <button
-                    onClick={() => removeParticipant(p.id)}
-                    className="p-2 hover:bg-destructive/10 text-destructive rounded-md"
-                  >
-                    <Trash2 className="h-4 w-4" />
-                  </button>
+                  {isOwner && (
+                    <div className="flex items-center gap-2">
+                      {p.is_user && (
+                        <select
+                          value={p.user_id}
+                          onChange={(e) => assignParticipant(p, parseInt(e.target.value))}
+                          className="px-2 py-1 text-sm border rounded-md bg-background"
+                          title="Played by"
+                        >
+                          <option value={0}>Unassigned</option>
+                          {posters.map((m) => (
+                            <option key={m.user_id} value={m.user_id}>{m.username}</option>
+                          ))}
+                        </select>
+                      )}
+                      <button
+                        onClick={() => removeParticipant(p.id)}
+                        className="p-2 hover:bg-destructive/10 text-destructive rounded-md"
+                      >
+                        <Trash2 className="h-4 w-4" />
+                      </button>
+                    </div>
+                  )}
                 </div>
               ))}
             </div>
@@ -152,6 +269,7 @@ export default function RoomDetail() {
         </div>
 
         {/* Available Characters */}
+        {isOwner && (
         <div className="border rounded-lg p-4">
           <h2 className="font-semibold mb-4">Available Characters</h2>
           {availableChars.length === 0 ? (
@@ -194,6 +312,121 @@ export default function RoomDetail() {
             </div>
           )}
         </div>
+        )}
+
+        {/* Members */}
+        <div className="border rounded-lg p-4">
+          <h2 className="font-semibold mb-4">Members ({members.length})</h2>
+          <div className="space-y-2">
+            {members.map((m) => (
+              <div key={m.user_id} className="flex items-center justify-between p-3 bg-muted rounded-md">
+                <span className="font-medium">{m.username}</span>
+                {isOwner ? (
+                  <div className="flex items-center gap-2">
+                    <select
+                      value={m.role}
+                      onChange={(e) => setMemberRole(m.user_id, e.target.value)}
+                      className="px-2 py-1 text-sm border rounded-md bg-background"
+                    >
+                      <option value="owner">Owner</option>
+                      <option value="player">Player</option>
+                      <option value="spectator">Spectator</option>
+                    </select>
+                    <button
+                      onClick={() => removeMember(m.user_id)}
+                      className="p-2 hover:bg-destructive/10 text-destructive rounded-md"
+                    >
+                      <Trash2 className="h-4 w-4" />
+                    </button>
+                  </div>
+                ) : (
+                  <span className="text-sm text-muted-foreground capitalize">{m.role}</span>
+                )}
+              </div>
+            ))}
+          </div>
+          {isOwner && (
+            <div className="flex gap-2 mt-4">
+              <select
+                value={newMemberId}
+                onChange={(e) => setNewMemberId(e.target.value)}
+                className="flex-1 px-2 py-1 text-sm border rounded-md bg-background"
+              >
+                <option value="">Add a member...</option>
+                {accounts
+                  .filter((a) => !members.some((m) => m.user_id === a.id))
+                  .map((a) => (
+                    <option key={a.id} value={a.id}>{a.username}</option>
+                  ))}
+              </select>
+              <button
+                onClick={() => {
+                  setMemberRole(parseInt(newMemberId), 'player')
+                  setNewMemberId('')
+                }}
+                disabled={!newMemberId}
+                className="px-3 py-1 text-sm bg-primary text-primary-foreground hover:bg-primary/90 rounded-md disabled:opacity-50"
+              >
+                Add as Player
+              </button>
+            </div>
+          )}
+        </div>
+
+        {/* Share Links */}
+        {isOwner && (
+          <div className="border rounded-lg p-4">
+            <h2 className="font-semibold mb-4">Share Links ({shares.length})</h2>
+            <div className="space-y-2">
+              {shares.map((s) => (
+                <div key={s.id} className="flex items-center justify-between gap-2 p-3 bg-muted rounded-md">
+                  <div className="min-w-0">
+                    <input
+                      readOnly
+                      value={shareURL(s.token)}
+                      onFocus={(e) => e.target.select()}
+                      className="w-full px-2 py-1 text-xs border rounded-md bg-background"
+                    />
+                    <p className="text-xs text-muted-foreground mt-1">
+                      {s.live ? 'Live feed · ' : ''}
+                      {s.expires_at ? `Expires ${new Date(s.expires_at).toLocaleString()}` : 'Never expires'}
+                    </p>
+                  </div>
+                  <button
+                    onClick={() => revokeShare(s.id)}
+                    className="p-2 hover:bg-destructive/10 text-destructive rounded-md"
+                    title="Revoke"
+                  >
+                    <Trash2 className="h-4 w-4" />
+                  </button>
+                </div>
+              ))}
+            </div>
+            <div className="flex items-center gap-2 mt-4 text-sm">
+              <select
+                value={shareHours}
+                onChange={(e) => setShareHours(e.target.value)}
+                className="px-2 py-1 border rounded-md bg-background"
+              >
+                <option value="0">Never expires</option>
+                <option value="1">1 hour</option>
+                <option value="24">1 day</option>
+                <option value="168">1 week</option>
+                <option value="720">30 days</option>
+              </select>
+              <label className="flex items-center gap-1">
+                <input type="checkbox" checked={shareLive} onChange={(e) => setShareLive(e.target.checked)} />
+                Live feed
+              </label>
+              <button
+                onClick={createShare}
+                className="ml-auto px-3 py-1 bg-primary text-primary-foreground hover:bg-primary/90 rounded-md"
+              >
+                Create Link
+              </button>
+            </div>
+          </div>
+        )}
       </div>
     </div>
   )
@@ -5,12 +5,16 @@ interface RoomFormData {
   name: string
   description: string
   setting: string
+  sampler_preset_id: number
+  turn_policy: 'queue' | 'preempt'
 }
 
 const defaultFormData: RoomFormData = {
   name: '',
   description: '',
   setting: '',
+  sampler_preset_id: 0,
+  turn_policy: 'queue',
 }
 
 export default function RoomForm() {
@@ -19,6 +23,14 @@ export default function RoomForm() {
   const isEdit = !!id
   const [formData, setFormData] = useState<RoomFormData>(defaultFormData)
   const [saving, setSaving] = useState(false)
+  const [presets, setPresets] = useState<{ id: number; name: string }[]>([])
+
+  useEffect(() => {
+    fetch('/api/presets')
+      .then((res) => res.json())
+      .then(setPresets)
+      .catch((err) => console.error('Failed to fetch presets:', err))
+  }, [])
 
   useEffect(() => {
     if (isEdit) {
@@ -101,6 +113,33 @@ export default function RoomForm() {
           />
         </div>
 
+        <div className="space-y-2">
+          <label className="text-sm font-medium">Sampler Preset</label>
+          <select
+            value={formData.sampler_preset_id}
+            onChange={(e) => setFormData({ ...formData, sampler_preset_id: parseInt(e.target.value) })}
+            className="w-full px-3 py-2 border rounded-md"
+          >
+            <option value={0}>None</option>
+            {presets.map((preset) => (
+              <option key={preset.id} value={preset.id}>{preset.name}</option>
+            ))}
+          </select>
+          <p className="text-xs text-muted-foreground">Applies to every AI character in this room, over their own presets.</p>
+        </div>
+
+        <div className="space-y-2">
+          <label className="text-sm font-medium">New Messages While Replying</label>
+          <select
+            value={formData.turn_policy}
+            onChange={(e) => setFormData({ ...formData, turn_policy: e.target.value as RoomFormData['turn_policy'] })}
+            className="w-full px-3 py-2 border rounded-md"
+          >
+            <option value="queue">Queue: reply to each message in order</option>
+            <option value="preempt">Preempt: stop the current replies and answer the newest message</option>
+          </select>
+        </div>
+
         <div className="flex gap-4 pt-4">
           <button
             type="submit"
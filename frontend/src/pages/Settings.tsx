@@ -1,31 +1,148 @@
 import { useState, useEffect } from 'react'
 
+interface InstructTemplate {
+  system_prefix: string
+  system_suffix: string
+  user_prefix: string
+  user_suffix: string
+  assistant_prefix: string
+  assistant_suffix: string
+  system_as_user: boolean
+  stop_sequences: string[]
+}
+
 interface Config {
   api_endpoint: string
   api_key: string
   default_model: string
+  embedding_model: string
+  provider: string
+  api_mode: string
+  instruct_template: string
+  custom_template: InstructTemplate
+}
+
+interface EffectiveSetting {
+  key: string
+  env?: string
+  value: unknown
+  source: 'default' | 'yaml' | 'env' | 'db'
+  pending_restart?: boolean
+}
+
+type ConfigField = keyof Config
+
+const sourceLabels: Record<string, string> = {
+  default: 'built-in default',
+  yaml: 'config.yaml',
+  env: 'environment',
+}
+
+interface Account {
+  id: number
+  username: string
+  is_admin: boolean
 }
 
+const templateFields: { key: keyof InstructTemplate; label: string }[] = [
+  { key: 'system_prefix', label: 'System Prefix' },
+  { key: 'system_suffix', label: 'System Suffix' },
+  { key: 'user_prefix', label: 'User Prefix' },
+  { key: 'user_suffix', label: 'User Suffix' },
+  { key: 'assistant_prefix', label: 'Assistant Prefix' },
+  { key: 'assistant_suffix', label: 'Assistant Suffix' },
+]
+
 const defaultConfig: Config = {
   api_endpoint: 'https://api.openai.com/v1',
   api_key: '',
   default_model: 'gpt-3.5-turbo',
+  embedding_model: 'text-embedding-3-small',
+  provider: 'auto',
+  api_mode: 'chat',
+  instruct_template: 'chatml',
+  custom_template: {
+    system_prefix: '',
+    system_suffix: '',
+    user_prefix: '',
+    user_suffix: '',
+    assistant_prefix: '',
+    assistant_suffix: '',
+    system_as_user: false,
+    stop_sequences: [],
+  },
 }
 
 export default function Settings() {
   const [config, setConfig] = useState<Config>(defaultConfig)
   const [saving, setSaving] = useState(false)
   const [saved, setSaved] = useState(false)
+  // The server only ever returns the key redacted; a new one is sent only
+  // when typed here
+  const [newApiKey, setNewApiKey] = useState('')
+  // Fields saved here override config.yaml and the environment; the rest
+  // are sent as null so they keep inheriting
+  const [overridden, setOverridden] = useState<ConfigField[]>([])
+  const [effective, setEffective] = useState<EffectiveSetting[]>([])
+  const [accounts, setAccounts] = useState<Account[]>([])
+  const [newAccount, setNewAccount] = useState({ username: '', password: '', is_admin: false })
 
   useEffect(() => {
     fetchConfig()
+    fetchAccounts()
   }, [])
 
+  const fetchAccounts = async () => {
+    try {
+      const res = await fetch('/api/users')
+      if (res.ok) setAccounts(await res.json())
+    } catch (err) {
+      console.error('Failed to fetch accounts:', err)
+    }
+  }
+
+  const handleCreateAccount = async () => {
+    const res = await fetch('/api/users', {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify(newAccount),
+    })
+    if (!res.ok) {
+      const data = await res.json()
+      alert(data.error || 'Failed to create account')
+      return
+    }
+    setNewAccount({ username: '', password: '', is_admin: false })
+    fetchAccounts()
+  }
+
+  const handleDeleteAccount = async (account: Account) => {
+    if (!confirm(`Delete account ${account.username}?`)) return
+    const res = await fetch(`/api/users/${account.id}`, { method: 'DELETE' })
+    if (!res.ok) {
+      const data = await res.json()
+      alert(data.error || 'Failed to delete account')
+      return
+    }
+    fetchAccounts()
+  }
+
   const fetchConfig = async () => {
     try {
       const res = await fetch('/api/config')
       const data = await res.json()
-      setConfig({ ...defaultConfig, ...data })
+      setOverridden(data.overridden ?? [])
+      const effectiveRes = await fetch('/api/config/effective')
+      if (effectiveRes.ok) setEffective(await effectiveRes.json())
+      setConfig({
+        ...defaultConfig,
+        ...data,
+        custom_template: {
+          ...defaultConfig.custom_template,
+          ...data.custom_template,
+          stop_sequences: data.custom_template?.stop_sequences ?? [],
+        },
+      })
     } catch (err) {
       console.error('Failed to fetch config:', err)
     }
@@ -37,9 +154,15 @@ export default function Settings() {
       const res = await fetch('/api/config', {
         method: 'PUT',
         headers: { 'Content-Type': 'application/json' },
-        body: JSON.stringify(config),
+        body: JSON.stringify(overridesToSave()),
       })
-      if (!res.ok) throw new Error('Failed to save')
+      if (!res.ok) {
+        const data = await res.json()
+        alert(data.error || 'Failed to save settings')
+        return
+      }
+      setNewApiKey('')
+      await fetchConfig()
       setSaved(true)
       setTimeout(() => setSaved(false), 2000)
     } catch (err) {
@@ -50,6 +173,56 @@ export default function Settings() {
     }
   }
 
+  // edit changes fields and marks them as overridden here
+  const edit = (patch: Partial<Config>) => {
+    setConfig({ ...config, ...patch })
+    const fields = Object.keys(patch) as ConfigField[]
+    setOverridden([...overridden.filter((f) => !fields.includes(f)), ...fields])
+  }
+
+  const reset = (field: ConfigField) => {
+    setOverridden(overridden.filter((f) => f !== field))
+    if (field === 'api_key') setNewApiKey('')
+  }
+
+  const overridesToSave = () => {
+    const body: Record<string, unknown> = {}
+    for (const field of Object.keys(defaultConfig) as ConfigField[]) {
+      if (field === 'api_key') continue
+      body[field] = overridden.includes(field) ? config[field] : null
+    }
+    // The key is left out to keep the saved one; an empty string clears it
+    if (newApiKey) body.api_key = newApiKey
+    else if (!overridden.includes('api_key')) body.api_key = ''
+    return body
+  }
+
+  const setTemplate = (patch: Partial<InstructTemplate>) =>
+    edit({ custom_template: { ...config.custom_template, ...patch } })
+
+  // sourceNote tells where a field's value comes from and lets an override
+  // saved here be reset to the inherited value
+  const sourceNote = (field: ConfigField) => {
+    if (overridden.includes(field)) {
+      return (
+        <p className="text-xs text-muted-foreground">
+          Saved here.{' '}
+          <button onClick={() => reset(field)} className="underline hover:text-foreground">
+            Reset to inherited value
+          </button>
+        </p>
+      )
+    }
+    const setting = effective.find((s) => s.key === `llm.${field}`)
+    if (!setting) return null
+    return (
+      <p className="text-xs text-muted-foreground">
+        From {sourceLabels[setting.source] ?? setting.source}
+        {setting.env && setting.source === 'env' ? ` (${setting.env})` : ''}
+      </p>
+    )
+  }
+
   return (
     <div className="max-w-2xl mx-auto">
       <h1 className="text-2xl font-bold mb-6">Settings</h1>
@@ -60,24 +233,122 @@ export default function Settings() {
           <input
             type="text"
             value={config.api_endpoint}
-            onChange={(e) => setConfig({ ...config, api_endpoint: e.target.value })}
+            onChange={(e) => edit({ api_endpoint: e.target.value })}
             className="w-full px-3 py-2 border rounded-md"
             placeholder="https://api.openai.com/v1"
           />
+          {sourceNote('api_endpoint')}
           <p className="text-xs text-muted-foreground">
             Supports OpenAI-compatible API endpoints, such as OpenAI, Azure, local Ollama, etc.
           </p>
         </div>
 
+        <div className="space-y-2">
+          <label className="text-sm font-medium">Provider</label>
+          <select
+            value={config.provider}
+            onChange={(e) => edit({ provider: e.target.value })}
+            className="w-full px-3 py-2 border rounded-md"
+          >
+            <option value="auto">Detect from endpoint</option>
+            <option value="openai">OpenAI</option>
+            <option value="azure">Azure OpenAI</option>
+            <option value="openrouter">OpenRouter</option>
+            <option value="groq">Groq</option>
+            <option value="mistral">Mistral</option>
+            <option value="ollama">Ollama</option>
+            <option value="generic">Other (llama.cpp, vLLM, KoboldCpp...)</option>
+          </select>
+          {sourceNote('provider')}
+          <p className="text-xs text-muted-foreground">
+            Sampling parameters the provider doesn't support are left out of requests.
+          </p>
+        </div>
+
+        <div className="space-y-2">
+          <label className="text-sm font-medium">API Mode</label>
+          <select
+            value={config.api_mode}
+            onChange={(e) => edit({ api_mode: e.target.value })}
+            className="w-full px-3 py-2 border rounded-md"
+          >
+            <option value="chat">Chat completions</option>
+            <option value="completion">Text completion (instruct template)</option>
+          </select>
+          {sourceNote('api_mode')}
+          <p className="text-xs text-muted-foreground">
+            Text completion sends a single prompt to /completions, which suits local backends serving base models.
+          </p>
+        </div>
+
+        {config.api_mode === 'completion' && (
+          <div className="space-y-2">
+            <label className="text-sm font-medium">Instruct Template</label>
+            <select
+              value={config.instruct_template}
+              onChange={(e) => edit({ instruct_template: e.target.value })}
+              className="w-full px-3 py-2 border rounded-md"
+            >
+              <option value="chatml">ChatML</option>
+              <option value="llama3">Llama 3</option>
+              <option value="mistral">Mistral</option>
+              <option value="alpaca">Alpaca</option>
+              <option value="gemma">Gemma</option>
+              <option value="custom">Custom</option>
+            </select>
+            {sourceNote('instruct_template')}
+          </div>
+        )}
+
+        {config.api_mode === 'completion' && config.instruct_template === 'custom' && (
+          <div className="space-y-4 border rounded-md p-4">
+            <div className="grid grid-cols-2 gap-4">
+              {templateFields.map(({ key, label }) => (
+                <div key={key} className="space-y-2">
+                  <label className="text-sm font-medium">{label}</label>
+                  <textarea
+                    value={config.custom_template[key] as string}
+                    onChange={(e) => setTemplate({ [key]: e.target.value })}
+                    className="w-full px-3 py-2 border rounded-md font-mono text-sm"
+                    rows={2}
+                  />
+                </div>
+              ))}
+            </div>
+            <div className="space-y-2">
+              <label className="text-sm font-medium">Stop Sequences</label>
+              <textarea
+                value={config.custom_template.stop_sequences.join('\n')}
+                onChange={(e) => setTemplate({ stop_sequences: e.target.value.split('\n').filter((s) => s !== '') })}
+                className="w-full px-3 py-2 border rounded-md font-mono text-sm"
+                rows={2}
+                placeholder="One per line"
+              />
+            </div>
+            <label className="flex items-center gap-2 text-sm">
+              <input
+                type="checkbox"
+                checked={config.custom_template.system_as_user}
+                onChange={(e) => setTemplate({ system_as_user: e.target.checked })}
+              />
+              Fold system messages into the next user turn
+            </label>
+          </div>
+        )}
+
         <div className="space-y-2">
           <label className="text-sm font-medium">API Key</label>
           <input
             type="password"
-            value={config.api_key}
-            onChange={(e) => setConfig({ ...config, api_key: e.target.value })}
+            value={newApiKey}
+            onChange={(e) => {
+              setNewApiKey(e.target.value)
+              if (e.target.value && !overridden.includes('api_key')) setOverridden([...overridden, 'api_key'])
+            }}
             className="w-full px-3 py-2 border rounded-md"
-            placeholder="sk-..."
+            placeholder={config.api_key ? `${config.api_key} (leave empty to keep)` : 'sk-...'}
           />
+          {sourceNote('api_key')}
         </div>
 
         <div className="space-y-2">
@@ -85,10 +356,26 @@ export default function Settings() {
           <input
             type="text"
             value={config.default_model}
-            onChange={(e) => setConfig({ ...config, default_model: e.target.value })}
+            onChange={(e) => edit({ default_model: e.target.value })}
             className="w-full px-3 py-2 border rounded-md"
             placeholder="gpt-3.5-turbo"
           />
+          {sourceNote('default_model')}
+        </div>
+
+        <div className="space-y-2">
+          <label className="text-sm font-medium">Embedding Model</label>
+          <input
+            type="text"
+            value={config.embedding_model}
+            onChange={(e) => edit({ embedding_model: e.target.value })}
+            className="w-full px-3 py-2 border rounded-md"
+            placeholder="text-embedding-3-small"
+          />
+          {sourceNote('embedding_model')}
+          <p className="text-xs text-muted-foreground">
+            Used for semantic search over chat history.
+          </p>
         </div>
 
         <div className="pt-4">
@@ -100,6 +387,55 @@ export default function Settings() {
             {saving ? 'Saving...' : saved ? 'Saved!' : 'Save Settings'}
           </button>
         </div>
+
+        <div className="space-y-2 border-t pt-6">
+          <h2 className="text-lg font-semibold">Accounts</h2>
+          <ul className="divide-y border rounded-md">
+            {accounts.map((account) => (
+              <li key={account.id} className="flex items-center justify-between px-3 py-2 text-sm">
+                <span>
+                  {account.username}
+                  {account.is_admin && <span className="ml-2 text-xs text-muted-foreground">admin</span>}
+                </span>
+                <button onClick={() => handleDeleteAccount(account)} className="text-xs text-destructive hover:underline">
+                  Delete
+                </button>
+              </li>
+            ))}
+          </ul>
+          <div className="flex gap-2">
+            <input
+              type="text"
+              value={newAccount.username}
+              onChange={(e) => setNewAccount({ ...newAccount, username: e.target.value })}
+              className="flex-1 px-3 py-2 border rounded-md"
+              placeholder="Username"
+            />
+            <input
+              type="password"
+              value={newAccount.password}
+              onChange={(e) => setNewAccount({ ...newAccount, password: e.target.value })}
+              className="flex-1 px-3 py-2 border rounded-md"
+              placeholder="Password (8+ characters)"
+              autoComplete="new-password"
+            />
+            <label className="flex items-center gap-1 text-sm">
+              <input
+                type="checkbox"
+                checked={newAccount.is_admin}
+                onChange={(e) => setNewAccount({ ...newAccount, is_admin: e.target.checked })}
+              />
+              Admin
+            </label>
+            <button
+              onClick={handleCreateAccount}
+              disabled={!newAccount.username || !newAccount.password}
+              className="px-4 py-2 bg-primary text-primary-foreground rounded-md hover:bg-primary/90 disabled:opacity-50"
+            >
+              Add
+            </button>
+          </div>
+        </div>
       </div>
     </div>
   )